@@ -0,0 +1,757 @@
+//! Caches compiled programs in `.tardic` files.
+//!
+//! A cache is a snapshot of the environment after a script has been compiled
+//! but before it runs: the instructions, the constant pool, the op table,
+//! the source positions, and the modules' word tables. Loading one skips
+//! bootstrapping and compiling altogether.
+//!
+//! The format is a little-endian binary one:
+//!
+//! * the magic bytes `TARDIC`, the format version, and the version of Tardi
+//!   that wrote it. Caches from any other version are rejected, since the
+//!   built-ins they refer to may have changed;
+//! * the path and hash of every source file that went into the program, so
+//!   stale caches can be spotted;
+//! * the instruction pointer to start running from;
+//! * the instructions, op table, constants, positions, and modules.
+//!
+//! Built-ins are written as the internal module and name that define them,
+//! and they're looked up again when the cache is read. Primitives are
+//! written by name, so the host program has to register them again before
+//! reading the cache.
+
+use std::collections::{HashMap, HashSet};
+use std::convert::{TryFrom, TryInto};
+use std::fs;
+use std::mem;
+use std::path::{Path, PathBuf};
+
+use indexmap::IndexMap;
+
+use crate::env::{Environment, SourceLoc};
+use crate::error::{Error, Result};
+use crate::module::internal::define_module;
+use crate::module::internal::kernel::KERNEL;
+use crate::module::{Module, ModuleManager};
+use crate::shared::{shared, Shared};
+use crate::value::frozen::FrozenValueData;
+use crate::value::lambda::{Callable, Lambda, OpFn};
+use crate::value::{Keyword, Pos, Value, ValueData};
+
+/// The extension for cache files.
+pub const CACHE_EXTENSION: &str = "tardic";
+
+const MAGIC: &[u8] = b"TARDIC";
+const FORMAT_VERSION: u32 = 1;
+const TARDI_VERSION: &str = env!("CARGO_PKG_VERSION");
+
+/// Where the cache for a script goes by default, next to it.
+pub fn cache_path(script: &Path) -> PathBuf {
+    script.with_extension(CACHE_EXTENSION)
+}
+
+/// Writes the compiled program in `env` to `cache_file`. The program is run
+/// by starting at `start_ip`, and it was compiled from `script` and the
+/// modules it uses.
+pub fn write_cache(
+    env: &Environment,
+    script: &Path,
+    start_ip: usize,
+    cache_file: &Path,
+) -> Result<()> {
+    let mut sources = vec![script.canonicalize()?];
+    sources.extend(
+        env.module_manager
+            .iter_modules()
+            .filter_map(|module| module.path.clone()),
+    );
+
+    let mut encoder = Encoder::new(env);
+    encoder.header();
+    encoder.sources(&sources)?;
+    encoder.usize(start_ip);
+    encoder.environment(env)?;
+
+    fs::write(cache_file, encoder.buffer)?;
+    Ok(())
+}
+
+/// Reads the cache in `cache_file` into `env` and returns the instruction
+/// pointer to start running from.
+///
+/// This returns `None` if there isn't a cache, or, with `check_sources`, if
+/// any of the sources it was compiled from have changed. It's an error if
+/// the cache is corrupt or was written by a different version of Tardi.
+/// Either way, `env` is only changed if the whole cache could be read.
+pub fn read_cache(
+    env: &mut Environment,
+    cache_file: &Path,
+    check_sources: bool,
+) -> Result<Option<usize>> {
+    if !cache_file.exists() {
+        return Ok(None);
+    }
+    let bytes = fs::read(cache_file)?;
+    let mut decoder = Decoder::new(&bytes, env);
+
+    decoder.header()?;
+    if !decoder.sources_are_fresh()? && check_sources {
+        log::debug!("cache {} is stale", cache_file.display());
+        return Ok(None);
+    }
+    let start_ip = decoder.usize()?;
+    let cached = decoder.environment()?;
+
+    env.instructions = cached.instructions;
+    env.op_table = cached.op_table;
+    env.constants = cached.constants;
+    env.positions = cached.positions;
+    env.symbols = cached.symbols;
+    env.module_manager.modules = cached.modules;
+
+    Ok(Some(start_ip))
+}
+
+/// Hashes the contents of a source file with 64-bit FNV-1a. This needs to
+/// be stable between runs and builds, which `DefaultHasher` isn't.
+fn hash_source(bytes: &[u8]) -> u64 {
+    bytes.iter().fold(0xcbf2_9ce4_8422_2325, |hash, byte| {
+        (hash ^ u64::from(*byte)).wrapping_mul(0x0100_0000_01b3)
+    })
+}
+
+fn invalid<S: Into<String>>(reason: S) -> Error {
+    Error::InvalidCache(reason.into())
+}
+
+const TAG_BUILTIN: u8 = 0;
+const TAG_COMPILED: u8 = 1;
+const TAG_PRIMITIVE: u8 = 2;
+
+const TAG_INTEGER: u8 = 0;
+const TAG_FLOAT: u8 = 1;
+const TAG_BOOLEAN: u8 = 2;
+const TAG_CHAR: u8 = 3;
+const TAG_STRING: u8 = 4;
+const TAG_LIST: u8 = 5;
+const TAG_HASHMAP: u8 = 6;
+const TAG_FUNCTION: u8 = 7;
+const TAG_ADDRESS: u8 = 8;
+const TAG_WORD: u8 = 9;
+const TAG_SYMBOL: u8 = 10;
+const TAG_MACRO: u8 = 11;
+const TAG_LITERAL: u8 = 12;
+const TAG_RETURN: u8 = 13;
+const TAG_END_OF_INPUT: u8 = 14;
+const TAG_KEYWORD: u8 = 15;
+
+struct Encoder {
+    buffer: Vec<u8>,
+    /// The internal module and name for each built-in, keyed by its function
+    /// pointer.
+    builtins: HashMap<usize, (String, String)>,
+}
+
+impl Encoder {
+    fn new(env: &Environment) -> Self {
+        let mut builtins = HashMap::new();
+        for module in env.module_manager.iter_modules() {
+            if !env.module_manager.is_internal(&module.name) {
+                continue;
+            }
+            for (name, index) in module.defined.iter() {
+                if let Some(Callable::BuiltIn { function }) = env
+                    .op_table
+                    .get(*index)
+                    .map(|l| l.borrow().callable.clone())
+                {
+                    builtins.insert(function as usize, (module.name.clone(), name.clone()));
+                }
+            }
+        }
+
+        Encoder {
+            buffer: Vec::new(),
+            builtins,
+        }
+    }
+
+    fn header(&mut self) {
+        self.buffer.extend_from_slice(MAGIC);
+        self.buffer.extend_from_slice(&FORMAT_VERSION.to_le_bytes());
+        self.string(TARDI_VERSION);
+    }
+
+    fn sources(&mut self, sources: &[PathBuf]) -> Result<()> {
+        self.usize(sources.len());
+        for source in sources {
+            let bytes = fs::read(source)?;
+            self.path(source);
+            self.u64(hash_source(&bytes));
+        }
+        Ok(())
+    }
+
+    fn environment(&mut self, env: &Environment) -> Result<()> {
+        self.usize(env.instructions.len());
+        for instruction in env.instructions.iter() {
+            self.usize(*instruction);
+        }
+
+        self.usize(env.op_table.len());
+        for lambda in env.op_table.iter() {
+            self.lambda(&lambda.borrow())?;
+        }
+
+        self.usize(env.constants.len());
+        for constant in env.constants.iter() {
+            self.value(constant)?;
+        }
+
+        self.usize(env.positions.len());
+        for (ip, loc) in env.positions.iter() {
+            self.usize(*ip);
+            self.string(&loc.source);
+            self.pos(&loc.pos);
+        }
+
+        self.usize(env.module_manager.modules.len());
+        for module in env.module_manager.iter_modules() {
+            self.module(module);
+        }
+
+        Ok(())
+    }
+
+    fn module(&mut self, module: &Module) {
+        self.string(&module.name);
+        self.option(module.path.as_ref(), |e, path| e.path(path));
+        self.word_table(&module.defined);
+        self.word_table(&module.imported);
+        self.usize(module.exported.len());
+        for name in module.exported.iter() {
+            self.string(name);
+        }
+    }
+
+    fn word_table(&mut self, table: &HashMap<String, usize>) {
+        self.usize(table.len());
+        for (name, index) in table.iter() {
+            self.string(name);
+            self.usize(*index);
+        }
+    }
+
+    fn lambda(&mut self, lambda: &Lambda) -> Result<()> {
+        self.option(lambda.name.as_ref(), |e, name| e.string(name));
+        self.bool(lambda.immediate);
+        self.bool(lambda.defined);
+        match lambda.callable {
+            Callable::BuiltIn { function } => {
+                let (module, name) = self
+                    .builtins
+                    .get(&(function as usize))
+                    .cloned()
+                    .ok_or_else(|| invalid(format!("unknown built-in {}", lambda)))?;
+                self.u8(TAG_BUILTIN);
+                self.string(&module);
+                self.string(&name);
+            }
+            Callable::Primitive { .. } => {
+                let name = lambda
+                    .name
+                    .as_ref()
+                    .ok_or_else(|| invalid("unnamed primitive"))?;
+                self.u8(TAG_PRIMITIVE);
+                self.string(name);
+            }
+            Callable::Compiled {
+                ref words,
+                ip,
+                length,
+                is_loop,
+            } => {
+                self.u8(TAG_COMPILED);
+                self.usize(words.len());
+                for word in words {
+                    self.string(word);
+                }
+                self.usize(ip);
+                self.usize(length);
+                self.bool(is_loop);
+            }
+        }
+        Ok(())
+    }
+
+    fn value(&mut self, value: &Value) -> Result<()> {
+        self.value_data(&value.data)?;
+        self.option(value.lexeme.as_ref(), |e, lexeme| e.string(lexeme));
+        self.option(value.pos.as_ref(), |e, pos| e.pos(pos));
+        Ok(())
+    }
+
+    fn value_data(&mut self, data: &ValueData) -> Result<()> {
+        match data {
+            ValueData::Integer(n) => {
+                self.u8(TAG_INTEGER);
+                self.u64(*n as u64);
+            }
+            ValueData::Float(n) => {
+                self.u8(TAG_FLOAT);
+                self.u64(n.to_bits());
+            }
+            ValueData::Boolean(b) => {
+                self.u8(TAG_BOOLEAN);
+                self.bool(*b);
+            }
+            ValueData::Char(c) => {
+                self.u8(TAG_CHAR);
+                self.u64(u64::from(u32::from(*c)));
+            }
+            ValueData::String(s) => {
+                self.u8(TAG_STRING);
+                self.string(s);
+            }
+            ValueData::List(items) => {
+                self.u8(TAG_LIST);
+                self.usize(items.len());
+                for item in items {
+                    self.value(&item.borrow())?;
+                }
+            }
+            ValueData::HashMap(map) => {
+                self.u8(TAG_HASHMAP);
+                self.usize(map.len());
+                for (key, value) in map.iter() {
+                    self.value_data(&ValueData::from(key.clone()))?;
+                    self.value(&value.borrow())?;
+                }
+            }
+            ValueData::Function(lambda) => {
+                self.u8(TAG_FUNCTION);
+                self.lambda(lambda)?;
+            }
+            ValueData::Address(address) => {
+                self.u8(TAG_ADDRESS);
+                self.usize(*address);
+            }
+            ValueData::Word(word) => {
+                self.u8(TAG_WORD);
+                self.string(word);
+            }
+            ValueData::Symbol { module, word } => {
+                self.u8(TAG_SYMBOL);
+                self.string(module);
+                self.string(word);
+            }
+            ValueData::Keyword(keyword) => {
+                self.u8(TAG_KEYWORD);
+                self.string(keyword.name());
+            }
+            ValueData::Macro => self.u8(TAG_MACRO),
+            ValueData::Literal(value) => {
+                self.u8(TAG_LITERAL);
+                self.value(value)?;
+            }
+            ValueData::Return(address, is_loop) => {
+                self.u8(TAG_RETURN);
+                self.usize(*address);
+                self.bool(*is_loop);
+            }
+            ValueData::EndOfInput => self.u8(TAG_END_OF_INPUT),
+            ValueData::Writer(_)
+            | ValueData::Reader(_)
+            | ValueData::Record(_)
+            | ValueData::Coroutine(_)
+            | ValueData::Regex(_) => {
+                return Err(invalid(format!("cannot cache {}", data)));
+            }
+        }
+        Ok(())
+    }
+
+    fn pos(&mut self, pos: &Pos) {
+        self.usize(pos.line);
+        self.usize(pos.column);
+        self.usize(pos.offset);
+        self.usize(pos.length);
+    }
+
+    fn option<T, F>(&mut self, value: Option<T>, write: F)
+    where
+        F: FnOnce(&mut Self, T),
+    {
+        match value {
+            Some(value) => {
+                self.bool(true);
+                write(self, value);
+            }
+            None => self.bool(false),
+        }
+    }
+
+    fn path(&mut self, path: &Path) {
+        self.string(&path.to_string_lossy());
+    }
+
+    fn string(&mut self, s: &str) {
+        self.usize(s.len());
+        self.buffer.extend_from_slice(s.as_bytes());
+    }
+
+    fn usize(&mut self, n: usize) {
+        self.u64(n as u64);
+    }
+
+    fn u64(&mut self, n: u64) {
+        self.buffer.extend_from_slice(&n.to_le_bytes());
+    }
+
+    fn bool(&mut self, b: bool) {
+        self.u8(b as u8);
+    }
+
+    fn u8(&mut self, n: u8) {
+        self.buffer.push(n);
+    }
+}
+
+/// The parts of the environment that are read from a cache.
+struct CachedEnvironment {
+    instructions: Vec<usize>,
+    op_table: Vec<Shared<Lambda>>,
+    constants: Vec<Value>,
+    positions: HashMap<usize, SourceLoc>,
+    modules: HashMap<String, Module>,
+    symbols: HashMap<String, Keyword>,
+}
+
+struct Decoder<'a> {
+    bytes: &'a [u8],
+    index: usize,
+    module_manager: &'a ModuleManager,
+    op_table: &'a [Shared<Lambda>],
+    /// Internal modules that have been defined to look up built-ins, along
+    /// with their op tables.
+    internals: HashMap<String, (Module, Vec<Shared<Lambda>>)>,
+    /// The symbols that have been read, so each one is interned again.
+    symbols: HashMap<String, Keyword>,
+}
+
+impl<'a> Decoder<'a> {
+    fn new(bytes: &'a [u8], env: &'a Environment) -> Self {
+        Decoder {
+            bytes,
+            index: 0,
+            module_manager: &env.module_manager,
+            op_table: &env.op_table,
+            internals: HashMap::new(),
+            symbols: HashMap::new(),
+        }
+    }
+
+    fn header(&mut self) -> Result<()> {
+        if self.take(MAGIC.len())? != MAGIC {
+            return Err(invalid("not a Tardi cache"));
+        }
+        let format_version = u32::from_le_bytes(self.take(4)?.try_into().unwrap());
+        if format_version != FORMAT_VERSION {
+            return Err(invalid(format!(
+                "format version {}, expected {}",
+                format_version, FORMAT_VERSION
+            )));
+        }
+        let tardi_version = self.string()?;
+        if tardi_version != TARDI_VERSION {
+            return Err(invalid(format!(
+                "written by Tardi v{}, expected v{}",
+                tardi_version, TARDI_VERSION
+            )));
+        }
+        Ok(())
+    }
+
+    fn sources_are_fresh(&mut self) -> Result<bool> {
+        let mut is_fresh = true;
+        for _ in 0..self.usize()? {
+            let path = self.path()?;
+            let hash = self.u64()?;
+            // Keep reading, even once a source has changed, so the decoder
+            // is left at the end of the list.
+            is_fresh = is_fresh && fs::read(&path).is_ok_and(|bytes| hash_source(&bytes) == hash);
+        }
+        Ok(is_fresh)
+    }
+
+    fn environment(&mut self) -> Result<CachedEnvironment> {
+        let mut instructions = Vec::new();
+        for _ in 0..self.usize()? {
+            instructions.push(self.usize()?);
+        }
+
+        let mut op_table = Vec::new();
+        for _ in 0..self.usize()? {
+            op_table.push(shared(self.lambda()?));
+        }
+
+        let mut constants = Vec::new();
+        for _ in 0..self.usize()? {
+            constants.push(self.value()?);
+        }
+
+        let mut positions = HashMap::new();
+        for _ in 0..self.usize()? {
+            let ip = self.usize()?;
+            let source = self.string()?;
+            let pos = self.pos()?;
+            positions.insert(ip, SourceLoc { source, pos });
+        }
+
+        let mut modules = HashMap::new();
+        for _ in 0..self.usize()? {
+            let module = self.module()?;
+            modules.insert(module.name.clone(), module);
+        }
+
+        if self.index != self.bytes.len() {
+            return Err(invalid("unexpected data after the modules"));
+        }
+
+        Ok(CachedEnvironment {
+            instructions,
+            op_table,
+            constants,
+            positions,
+            modules,
+            symbols: mem::take(&mut self.symbols),
+        })
+    }
+
+    fn module(&mut self) -> Result<Module> {
+        let name = self.string()?;
+        let path = self.option(|d| d.path())?;
+        let defined = self.word_table()?;
+        let imported = self.word_table()?;
+        let mut exported = HashSet::new();
+        for _ in 0..self.usize()? {
+            exported.insert(self.string()?);
+        }
+        Ok(Module {
+            path,
+            name,
+            defined,
+            imported,
+            exported,
+        })
+    }
+
+    fn word_table(&mut self) -> Result<HashMap<String, usize>> {
+        let mut table = HashMap::new();
+        for _ in 0..self.usize()? {
+            let name = self.string()?;
+            table.insert(name, self.usize()?);
+        }
+        Ok(table)
+    }
+
+    fn lambda(&mut self) -> Result<Lambda> {
+        let name = self.option(|d| d.string())?;
+        let immediate = self.bool()?;
+        let defined = self.bool()?;
+        let callable = match self.u8()? {
+            TAG_BUILTIN => {
+                let module = self.string()?;
+                let word = self.string()?;
+                Callable::BuiltIn {
+                    function: self.builtin(&module, &word)?,
+                }
+            }
+            TAG_PRIMITIVE => {
+                let word = self.string()?;
+                self.primitive(&word)?
+            }
+            TAG_COMPILED => {
+                let mut words = Vec::new();
+                for _ in 0..self.usize()? {
+                    words.push(self.string()?);
+                }
+                Callable::Compiled {
+                    words,
+                    ip: self.usize()?,
+                    length: self.usize()?,
+                    is_loop: self.bool()?,
+                }
+            }
+            tag => return Err(invalid(format!("invalid lambda tag {}", tag))),
+        };
+        Ok(Lambda {
+            name,
+            immediate,
+            defined,
+            callable,
+        })
+    }
+
+    /// Finds a built-in by defining the internal module it's from again.
+    fn builtin(&mut self, module_name: &str, word: &str) -> Result<OpFn> {
+        if !self.internals.contains_key(module_name) {
+            if !self.module_manager.is_internal(module_name) {
+                return Err(invalid(format!("{} isn't an internal module", module_name)));
+            }
+            let mut op_table = Vec::new();
+            let module = define_module(self.module_manager, module_name, &mut op_table)?;
+            self.internals
+                .insert(module_name.to_string(), (module, op_table));
+        }
+
+        let (module, op_table) = &self.internals[module_name];
+        let lambda = module
+            .defined
+            .get(word)
+            .and_then(|index| op_table.get(*index))
+            .ok_or_else(|| invalid(format!("unknown built-in {}::{}", module_name, word)))?;
+        match lambda.borrow().callable {
+            Callable::BuiltIn { function } => Ok(function),
+            _ => Err(invalid(format!(
+                "{}::{} isn't a built-in",
+                module_name, word
+            ))),
+        }
+    }
+
+    /// Finds a primitive that the host program has registered.
+    fn primitive(&self, word: &str) -> Result<Callable> {
+        self.module_manager
+            .get(KERNEL)
+            .and_then(|kernel| kernel.defined.get(word))
+            .and_then(|index| self.op_table.get(*index))
+            .map(|lambda| lambda.borrow().callable.clone())
+            .filter(|callable| matches!(callable, Callable::Primitive { .. }))
+            .ok_or_else(|| invalid(format!("unregistered primitive {}", word)))
+    }
+
+    fn value(&mut self) -> Result<Value> {
+        let data = self.value_data()?;
+        let lexeme = self.option(|d| d.string())?;
+        let pos = self.option(|d| d.pos())?;
+        Ok(Value { data, lexeme, pos })
+    }
+
+    fn value_data(&mut self) -> Result<ValueData> {
+        let data = match self.u8()? {
+            TAG_INTEGER => ValueData::Integer(self.u64()? as i64),
+            TAG_FLOAT => ValueData::Float(f64::from_bits(self.u64()?)),
+            TAG_BOOLEAN => ValueData::Boolean(self.bool()?),
+            TAG_CHAR => {
+                let c = u32::try_from(self.u64()?)
+                    .ok()
+                    .and_then(char::from_u32)
+                    .ok_or_else(|| invalid("invalid character"))?;
+                ValueData::Char(c)
+            }
+            TAG_STRING => ValueData::String(self.string()?),
+            TAG_LIST => {
+                let mut items = Vec::new();
+                for _ in 0..self.usize()? {
+                    items.push(shared(self.value()?));
+                }
+                ValueData::List(items)
+            }
+            TAG_HASHMAP => {
+                let mut map = IndexMap::new();
+                for _ in 0..self.usize()? {
+                    let key = FrozenValueData::try_from(self.value_data()?)?;
+                    map.insert(key, shared(self.value()?));
+                }
+                ValueData::HashMap(map)
+            }
+            TAG_FUNCTION => ValueData::Function(self.lambda()?),
+            TAG_ADDRESS => ValueData::Address(self.usize()?),
+            TAG_WORD => ValueData::Word(self.string()?),
+            TAG_SYMBOL => ValueData::Symbol {
+                module: self.string()?,
+                word: self.string()?,
+            },
+            TAG_KEYWORD => {
+                let name = self.string()?;
+                let keyword = self
+                    .symbols
+                    .entry(name.clone())
+                    .or_insert_with(|| Keyword::new(&name))
+                    .clone();
+                ValueData::Keyword(keyword)
+            }
+            TAG_MACRO => ValueData::Macro,
+            TAG_LITERAL => ValueData::Literal(Box::new(self.value()?)),
+            TAG_RETURN => ValueData::Return(self.usize()?, self.bool()?),
+            TAG_END_OF_INPUT => ValueData::EndOfInput,
+            tag => return Err(invalid(format!("invalid value tag {}", tag))),
+        };
+        Ok(data)
+    }
+
+    fn pos(&mut self) -> Result<Pos> {
+        Ok(Pos {
+            line: self.usize()?,
+            column: self.usize()?,
+            offset: self.usize()?,
+            length: self.usize()?,
+        })
+    }
+
+    fn option<T, F>(&mut self, read: F) -> Result<Option<T>>
+    where
+        F: FnOnce(&mut Self) -> Result<T>,
+    {
+        if self.bool()? {
+            read(self).map(Some)
+        } else {
+            Ok(None)
+        }
+    }
+
+    fn path(&mut self) -> Result<PathBuf> {
+        self.string().map(PathBuf::from)
+    }
+
+    fn string(&mut self) -> Result<String> {
+        let len = self.usize()?;
+        let bytes = self.take(len)?;
+        String::from_utf8(bytes.to_vec()).map_err(|_| invalid("invalid string"))
+    }
+
+    fn usize(&mut self) -> Result<usize> {
+        usize::try_from(self.u64()?).map_err(|_| invalid("number too large"))
+    }
+
+    fn u64(&mut self) -> Result<u64> {
+        Ok(u64::from_le_bytes(self.take(8)?.try_into().unwrap()))
+    }
+
+    fn bool(&mut self) -> Result<bool> {
+        match self.u8()? {
+            0 => Ok(false),
+            1 => Ok(true),
+            b => Err(invalid(format!("invalid boolean {}", b))),
+        }
+    }
+
+    fn u8(&mut self) -> Result<u8> {
+        Ok(self.take(1)?[0])
+    }
+
+    fn take(&mut self, len: usize) -> Result<&'a [u8]> {
+        let end = self
+            .index
+            .checked_add(len)
+            .filter(|end| *end <= self.bytes.len())
+            .ok_or_else(|| invalid("unexpected end of cache"))?;
+        let bytes = &self.bytes[self.index..end];
+        self.index = end;
+        Ok(bytes)
+    }
+}
+
+#[cfg(test)]
+mod tests;
@@ -0,0 +1,136 @@
+use std::env;
+
+use pretty_assertions::assert_eq;
+
+use super::*;
+use crate::compiler::Compiler;
+use crate::core::Tardi;
+use crate::vm::VM;
+
+const SCRIPT: &str = "
+: square ( n -- n^2 ) dup * ;
+{ 1 2 3 } [ square ] map
+\"done\" 'c' 2.5 #t :done
+";
+
+fn temp_dir(name: &str) -> PathBuf {
+    let dir = env::temp_dir().join(format!("tardi-cache-{}-{}", name, std::process::id()));
+    fs::create_dir_all(&dir).unwrap();
+    dir
+}
+
+fn compile(dir: &Path) -> (PathBuf, PathBuf) {
+    let script = dir.join("script.tardi");
+    fs::write(&script, SCRIPT).unwrap();
+    let cache_file = cache_path(&script);
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi.compile_to_cache(&script, &cache_file).unwrap();
+    (script, cache_file)
+}
+
+#[test]
+fn test_cache_round_trip() {
+    let dir = temp_dir("round-trip");
+    let (script, cache_file) = compile(&dir);
+
+    let mut expected = Tardi::new(None).unwrap();
+    expected.execute_file(&script).unwrap();
+
+    let mut tardi = Tardi::default();
+    assert!(tardi.load_cache(&cache_file, true).unwrap());
+    tardi.execute().unwrap();
+
+    assert_eq!(tardi.stack(), expected.stack());
+    fs::remove_dir_all(dir).unwrap();
+}
+
+#[test]
+fn test_cache_missing() {
+    let dir = temp_dir("missing");
+    let mut tardi = Tardi::default();
+    assert!(!tardi.load_cache(&dir.join("nope.tardic"), true).unwrap());
+    fs::remove_dir_all(dir).unwrap();
+}
+
+#[test]
+fn test_cache_stale_when_source_changes() {
+    let dir = temp_dir("stale");
+    let (script, cache_file) = compile(&dir);
+    fs::write(&script, "42").unwrap();
+
+    let mut tardi = Tardi::default();
+    assert!(!tardi.load_cache(&cache_file, true).unwrap());
+    assert!(tardi.load_cache(&cache_file, false).unwrap());
+    fs::remove_dir_all(dir).unwrap();
+}
+
+#[test]
+fn test_cache_rejects_other_files() {
+    let dir = temp_dir("magic");
+    let cache_file = dir.join("script.tardic");
+    fs::write(&cache_file, "not a cache").unwrap();
+
+    let mut tardi = Tardi::default();
+    let result = tardi.load_cache(&cache_file, true);
+    assert!(matches!(result, Err(Error::InvalidCache(_))));
+    fs::remove_dir_all(dir).unwrap();
+}
+
+#[test]
+fn test_cache_rejects_other_versions() {
+    let dir = temp_dir("version");
+    let (_, cache_file) = compile(&dir);
+    let mut bytes = fs::read(&cache_file).unwrap();
+    bytes[MAGIC.len()] += 1;
+    fs::write(&cache_file, bytes).unwrap();
+
+    let mut tardi = Tardi::default();
+    let result = tardi.load_cache(&cache_file, true);
+    assert!(matches!(result, Err(Error::InvalidCache(_))));
+    fs::remove_dir_all(dir).unwrap();
+}
+
+#[test]
+fn test_cache_rejects_truncated_files() {
+    let dir = temp_dir("truncated");
+    let (_, cache_file) = compile(&dir);
+    let bytes = fs::read(&cache_file).unwrap();
+    fs::write(&cache_file, &bytes[..bytes.len() / 2]).unwrap();
+
+    let mut tardi = Tardi::default();
+    let op_table_size = tardi.environment.borrow().get_op_table_size();
+    let result = tardi.load_cache(&cache_file, true);
+    assert!(matches!(result, Err(Error::InvalidCache(_))));
+    assert_eq!(
+        tardi.environment.borrow().get_op_table_size(),
+        op_table_size
+    );
+    fs::remove_dir_all(dir).unwrap();
+}
+
+/// -- 42
+fn forty_two(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.push(shared(42.into()))
+}
+
+#[test]
+fn test_cache_primitives() {
+    let dir = temp_dir("primitives");
+    let script = dir.join("script.tardi");
+    fs::write(&script, "forty-two 1 +").unwrap();
+    let cache_file = cache_path(&script);
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi.register_primitive("forty-two", 0, forty_two);
+    tardi.compile_to_cache(&script, &cache_file).unwrap();
+
+    let mut tardi = Tardi::default();
+    tardi.register_primitive("forty-two", 0, forty_two);
+    assert!(tardi.load_cache(&cache_file, true).unwrap());
+    tardi.execute().unwrap();
+    assert_eq!(tardi.stack(), vec![ValueData::Integer(43).into()]);
+
+    let mut tardi = Tardi::default();
+    let result = tardi.load_cache(&cache_file, true);
+    assert!(matches!(result, Err(Error::InvalidCache(_))));
+    fs::remove_dir_all(dir).unwrap();
+}
@@ -0,0 +1,499 @@
+//! Checking declared stack effects.
+//!
+//! A definition can declare its stack effect, `: sq ( n -- n' ) dup * ;`.
+//! The checker walks the body, tracking how many values it takes from below
+//! the stack it started with and how many it leaves, and compares that with
+//! the declaration. Quotations are checked the same way, so combinators
+//! like `if` and `dip` can use their effects.
+//!
+//! Only the number of values is checked, not their types. Declarations with
+//! row variables, like `( ..a quot -- ..b )`, can't be checked this way, so
+//! they're skipped.
+
+use std::fmt;
+
+/// The number of values that something takes from the stack and leaves on it.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct StackEffect {
+    pub inputs: usize,
+    pub outputs: usize,
+}
+
+impl StackEffect {
+    pub fn new(inputs: usize, outputs: usize) -> Self {
+        StackEffect { inputs, outputs }
+    }
+
+    /// Parses the words of a declared stack effect, without its parentheses.
+    /// This returns `None` if it has row variables or no `--`.
+    ///
+    /// Nested effects, like `quot: ( a -- b )`, describe a single value.
+    pub fn parse(words: &[String]) -> Option<StackEffect> {
+        let mut inputs = 0;
+        let mut outputs = 0;
+        let mut seen_separator = false;
+        let mut depth = 0;
+        let mut named = false;
+
+        for word in words {
+            if depth > 0 {
+                match word.as_str() {
+                    "(" => depth += 1,
+                    ")" => depth -= 1,
+                    _ => {}
+                }
+                continue;
+            }
+
+            let counts = match word.as_str() {
+                "(" => {
+                    depth = 1;
+                    !named
+                }
+                "--" if !seen_separator => {
+                    seen_separator = true;
+                    false
+                }
+                _ if word.starts_with("..") => return None,
+                _ => true,
+            };
+            if counts && seen_separator {
+                outputs += 1;
+            } else if counts {
+                inputs += 1;
+            }
+            named = word.ends_with(':');
+        }
+
+        if seen_separator {
+            Some(StackEffect { inputs, outputs })
+        } else {
+            None
+        }
+    }
+
+    fn net(&self) -> isize {
+        self.outputs as isize - self.inputs as isize
+    }
+
+    /// Whether a body with this effect satisfies the `declared` one. The
+    /// body can leave values it doesn't touch alone, so `drop` satisfies
+    /// `( x y -- y )`.
+    pub fn satisfies(&self, declared: &StackEffect) -> bool {
+        self.net() == declared.net() && self.inputs <= declared.inputs
+    }
+
+    /// The effect of running one of two branches with these effects. They
+    /// need to change the stack by the same amount.
+    fn unify(&self, other: &StackEffect) -> Option<StackEffect> {
+        if self.net() == other.net() {
+            let inputs = self.inputs.max(other.inputs);
+            let outputs = (inputs as isize + self.net()) as usize;
+            Some(StackEffect { inputs, outputs })
+        } else {
+            None
+        }
+    }
+}
+
+impl fmt::Display for StackEffect {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(
+            f,
+            "it takes {} and leaves {}",
+            values(self.inputs),
+            values(self.outputs)
+        )
+    }
+}
+
+fn values(count: usize) -> String {
+    if count == 1 {
+        "1 value".to_string()
+    } else {
+        format!("{} values", count)
+    }
+}
+
+/// Why a stack effect couldn't be worked out.
+#[derive(Debug, Clone, PartialEq)]
+pub enum EffectError {
+    /// Something doesn't have a known effect, so nothing can be proven.
+    Unknown(String),
+    /// The effect can't be right, whatever the unknowns are.
+    Mismatch(String),
+}
+
+pub type EffectResult = Result<StackEffect, EffectError>;
+
+/// What a word in a body does to the stack, as far as the checker knows.
+#[derive(Debug, Clone, PartialEq)]
+pub enum Step {
+    /// Pushes a value.
+    Value,
+    /// Pushes a quotation that has this effect.
+    Quotation(EffectResult),
+    /// Calls something with a fixed effect.
+    Effect(StackEffect),
+    /// `apply` or `call`.
+    Apply,
+    /// `dip`, `2dip`, and `3dip`, with how many values they set aside.
+    Dip(usize),
+    /// `keep`, `2keep`, and `3keep`, with how many values they keep.
+    Keep(usize),
+    /// `bi` and `tri`, with how many quotations they apply to one value.
+    Cleave(usize),
+    /// `bi*` and `tri*`, with how many values they each apply a quotation to.
+    Spread(usize),
+    /// `bi@` and `tri@`, with how many values they apply one quotation to.
+    ApplyEach(usize),
+    /// `if`.
+    If,
+    /// `when` and `unless`.
+    When(&'static str),
+    /// Pushes a vector literal, with the effects of the quotations in it.
+    /// Anything else in it is `None`.
+    Vector(Vec<Option<EffectResult>>),
+    /// `cond`.
+    Cond,
+    /// `case`.
+    Case,
+    /// `cleave`, which takes a vector of quotations.
+    CleaveVector,
+    /// A word that can't be checked, with the reason why.
+    Unknown(String),
+}
+
+/// The effects of the words defined in the kernel that the checker handles
+/// itself. These take quotations, so their effects depend on them.
+pub fn combinator_step(word: &str) -> Option<Step> {
+    let step = match word {
+        "dip" => Step::Dip(1),
+        "2dip" => Step::Dip(2),
+        "3dip" => Step::Dip(3),
+        "keep" => Step::Keep(1),
+        "2keep" => Step::Keep(2),
+        "3keep" => Step::Keep(3),
+        "bi" => Step::Cleave(2),
+        "tri" => Step::Cleave(3),
+        "bi*" => Step::Spread(2),
+        "tri*" => Step::Spread(3),
+        "bi@" => Step::ApplyEach(2),
+        "tri@" => Step::ApplyEach(3),
+        "cleave" => Step::CleaveVector,
+        "if" => Step::If,
+        "when" => Step::When("when"),
+        "unless" => Step::When("unless"),
+        "cond" => Step::Cond,
+        "case" => Step::Case,
+        _ => return None,
+    };
+    Some(step)
+}
+
+/// The effects of the words that compile to opcodes.
+pub fn opcode_step(word: &str) -> Option<Step> {
+    let effect = match word {
+        "<nop>" => StackEffect::new(0, 0),
+        "dup" => StackEffect::new(1, 2),
+        "swap" => StackEffect::new(2, 2),
+        "rot" => StackEffect::new(3, 3),
+        "drop" => StackEffect::new(1, 0),
+        "+" | "-" | "*" | "/" | "==" | "!=" | "<" | ">" | "<=" | ">=" => StackEffect::new(2, 1),
+        "!" => StackEffect::new(1, 1),
+        "?" => StackEffect::new(3, 1),
+        ">r" => StackEffect::new(1, 0),
+        "r>" | "r@" => StackEffect::new(0, 1),
+        "apply" | "call" => return Some(Step::Apply),
+        "clear" | "stack-size" | "depth" | "lit" | "compile" | "break" | "continue" | "stop"
+        | "bye" => {
+            return Some(Step::Unknown(format!(
+                "`{}` doesn't have a fixed effect",
+                word
+            )))
+        }
+        _ => return None,
+    };
+    Some(Step::Effect(effect))
+}
+
+/// The effects of the built-in words in the internal modules. Words with
+/// the same name in different modules take and leave the same number of
+/// values.
+pub fn builtin_effect(name: &str) -> Option<StackEffect> {
+    let (inputs, outputs) = match name {
+        "<vector>" | "<string>" | "<hashmap>" | "argv" | "now" | "ticks" | "pi" | "e" => (0, 1),
+        "nl" | "enl" | "breakpoint" => (0, 0),
+        "stdin-line" => (0, 2),
+        "print" | "println" | "eprint" | "eprintln" | "." | "sort!" | "assert" | "yield"
+        | "sleep" | "pprint" | "see" => (1, 0),
+        "pop!" | "pop-left!" | "sort" | "length" | ">string" | ">hex" | "utf8>string" | ">utf8"
+        | "empty?" | "split-whitespace" | "lines" | ">lowercase" | ">uppercase" | ">float"
+        | ">int" | ">hashmap" | ">vector" | "is-hashmap?" | "exists?" | "file-exists?"
+        | "read-file" | "file-lines" | "getenv" | "char->int" | "int->char" | "string>chars"
+        | "chars>string" | "symbol>string" | "string>symbol" | "json-parse" | "json-serialize"
+        | "done?" | "<regex>" | "sqrt" | "floor" | "ceil" | "round" | "sin" | "cos" | "tan"
+        | "log" | "exp" | "string-length" | "trim" | "upcase" | "downcase" | "bit-not" => (1, 1),
+        "push!" | "push-left!" | "write-file" | "setenv" | "assert=" | "pprint-with" => (2, 0),
+        "concat" | "nth" | "in?" | "index-of?" | "join" | "starts-with?" | "ends-with?"
+        | "split-all" | "strip-start" | "strip-end" | "regex-match?" | "regex-find"
+        | "regex-captures" | "format-time" | "pow" | "mod" | "gcd" | "**" | "compare"
+        | "index-of" | "bit-and" | "bit-or" | "bit-xor" | "shift-left" | "shift-right" => (2, 1),
+        "split" | "split-at" | "divmod" => (2, 2),
+        "set-nth!" => (3, 0),
+        "subvector" | "replace-all" | "replace" | "substring" | "regex-replace" => (3, 1),
+        _ => return None,
+    };
+    Some(StackEffect::new(inputs, outputs))
+}
+
+#[derive(Debug, Clone)]
+enum Item {
+    Value,
+    Quotation(EffectResult),
+    Vector(Vec<Option<EffectResult>>),
+}
+
+/// Works out the effect of a sequence of steps.
+#[derive(Debug, Default)]
+pub struct Checker {
+    stack: Vec<Item>,
+    inputs: usize,
+}
+
+impl Checker {
+    /// Works out the effect of `steps`.
+    pub fn infer<I>(steps: I) -> EffectResult
+    where
+        I: IntoIterator<Item = Step>,
+    {
+        let mut checker = Checker::default();
+        for step in steps {
+            checker.step(step)?;
+        }
+        Ok(StackEffect::new(checker.inputs, checker.stack.len()))
+    }
+
+    fn pop(&mut self) -> Item {
+        self.stack.pop().unwrap_or_else(|| {
+            self.inputs += 1;
+            Item::Value
+        })
+    }
+
+    fn pop_quotation(&mut self, word: &str) -> EffectResult {
+        match self.pop() {
+            Item::Quotation(effect) => effect,
+            _ => Err(EffectError::Unknown(format!(
+                "`{}` is called on a quotation that isn't known",
+                word
+            ))),
+        }
+    }
+
+    /// Pops `count` quotations, and returns them in the order they were
+    /// pushed.
+    fn pop_quotations(
+        &mut self,
+        word: &str,
+        count: usize,
+    ) -> Result<Vec<StackEffect>, EffectError> {
+        let mut effects = (0..count)
+            .map(|_| self.pop_quotation(word))
+            .collect::<Result<Vec<_>, _>>()?;
+        effects.reverse();
+        Ok(effects)
+    }
+
+    /// Pops `count` values, and returns them in the order they were pushed.
+    fn pop_values(&mut self, count: usize) -> Vec<Item> {
+        let mut values: Vec<Item> = (0..count).map(|_| self.pop()).collect();
+        values.reverse();
+        values
+    }
+
+    /// Applies each of the effects to a copy of the top value.
+    fn cleave(&mut self, effects: Vec<StackEffect>) {
+        let value = self.pop();
+        for effect in effects {
+            self.stack.push(value.clone());
+            self.apply(&effect);
+        }
+    }
+
+    fn pop_branches(&mut self, word: &str) -> Result<Vec<EffectResult>, EffectError> {
+        match self.pop() {
+            Item::Vector(items) => Ok(items
+                .into_iter()
+                .map(|item| {
+                    item.unwrap_or_else(|| {
+                        Err(EffectError::Unknown(format!(
+                            "`{}` has a branch that isn't a quotation",
+                            word
+                        )))
+                    })
+                })
+                .collect()),
+            _ => Err(EffectError::Unknown(format!(
+                "`{}` is called on branches that aren't known",
+                word
+            ))),
+        }
+    }
+
+    fn apply(&mut self, effect: &StackEffect) {
+        for _ in 0..effect.inputs {
+            self.pop();
+        }
+        for _ in 0..effect.outputs {
+            self.stack.push(Item::Value);
+        }
+    }
+
+    fn step(&mut self, step: Step) -> Result<(), EffectError> {
+        match step {
+            Step::Value => self.stack.push(Item::Value),
+            Step::Quotation(effect) => self.stack.push(Item::Quotation(effect)),
+            Step::Effect(effect) => self.apply(&effect),
+            Step::Apply => {
+                let effect = self.pop_quotation("apply")?;
+                self.apply(&effect);
+            }
+            Step::Dip(count) => {
+                let effect = self.pop_quotation("dip")?;
+                let kept: Vec<Item> = (0..count).map(|_| self.pop()).collect();
+                self.apply(&effect);
+                self.stack.extend(kept.into_iter().rev());
+            }
+            Step::Keep(count) => {
+                let effect = self.pop_quotation("keep")?;
+                let kept: Vec<Item> = (0..count).map(|_| self.pop()).collect();
+                self.stack.extend(kept.iter().rev().cloned());
+                self.apply(&effect);
+                self.stack.extend(kept.into_iter().rev());
+            }
+            Step::Cleave(count) => {
+                let effects = self.pop_quotations("bi", count)?;
+                self.cleave(effects);
+            }
+            Step::Spread(count) => {
+                let effects = self.pop_quotations("bi*", count)?;
+                let values = self.pop_values(count);
+                for (value, effect) in values.into_iter().zip(effects) {
+                    self.stack.push(value);
+                    self.apply(&effect);
+                }
+            }
+            Step::ApplyEach(count) => {
+                let effect = self.pop_quotation("bi@")?;
+                let values = self.pop_values(count);
+                for value in values {
+                    self.stack.push(value);
+                    self.apply(&effect);
+                }
+            }
+            Step::If => {
+                let on_false = self.pop_quotation("if")?;
+                let on_true = self.pop_quotation("if")?;
+                self.pop();
+                let effect = on_true.unify(&on_false).ok_or_else(|| {
+                    EffectError::Mismatch(format!(
+                        "the branches of `if` don't agree: when true, {}, but when false, {}",
+                        on_true, on_false
+                    ))
+                })?;
+                self.apply(&effect);
+            }
+            Step::When(word) => {
+                let effect = self.pop_quotation(word)?;
+                if effect.inputs != effect.outputs {
+                    return Err(EffectError::Mismatch(format!(
+                        "the quotation for `{}` has to leave the stack as deep as it was, but {}",
+                        word, effect
+                    )));
+                }
+                self.pop();
+                self.apply(&effect);
+            }
+            Step::Vector(items) => self.stack.push(Item::Vector(items)),
+            Step::Cond => {
+                let branches = self.pop_branches("cond")?;
+                let effect = cond_effect(branches)?;
+                self.apply(&effect);
+            }
+            Step::Case => {
+                let branches = self.pop_branches("case")?;
+                let effect = case_effect(branches)?;
+                self.apply(&effect);
+            }
+            Step::CleaveVector => {
+                let effects = self.pop_branches("cleave")?;
+                let effects = effects.into_iter().collect::<Result<Vec<_>, _>>()?;
+                self.cleave(effects);
+            }
+            Step::Unknown(reason) => return Err(EffectError::Unknown(reason)),
+        }
+        Ok(())
+    }
+}
+
+/// The effect of `cond`. Taking a branch runs the tests before it, and
+/// drops what they leave.
+fn cond_effect(branches: Vec<EffectResult>) -> EffectResult {
+    let mut tests = Vec::new();
+    let mut effects = Vec::new();
+    for pair in branches.chunks(2) {
+        let mut steps = tests.clone();
+        match pair {
+            [test, body] => {
+                steps.push(Step::Effect(test.clone()?));
+                steps.push(Step::Effect(StackEffect::new(1, 0)));
+                tests = steps.clone();
+                steps.push(Step::Effect(body.clone()?));
+            }
+            [default] => steps.push(Step::Effect(default.clone()?)),
+            _ => unreachable!(),
+        }
+        effects.push(Checker::infer(steps)?);
+    }
+    unify_branches("cond", effects)
+}
+
+/// The effect of `case`, which takes the value it's matching. The bodies
+/// run without it, and the default runs with it.
+fn case_effect(branches: Vec<EffectResult>) -> EffectResult {
+    let mut effects = Vec::new();
+    for pair in branches.chunks(2) {
+        let effect = match pair {
+            [_, body] => Checker::infer(vec![
+                Step::Effect(StackEffect::new(1, 0)),
+                Step::Effect(body.clone()?),
+            ])?,
+            [default] => default.clone()?,
+            _ => unreachable!(),
+        };
+        effects.push(effect);
+    }
+    let effect = unify_branches("case", effects)?;
+    // `case` takes the value even if no branch uses it.
+    let inputs = effect.inputs.max(1);
+    Ok(StackEffect::new(
+        inputs,
+        (inputs as isize + effect.net()) as usize,
+    ))
+}
+
+fn unify_branches(word: &str, effects: Vec<StackEffect>) -> EffectResult {
+    let mut effects = effects.into_iter();
+    let first = effects
+        .next()
+        .ok_or_else(|| EffectError::Unknown(format!("`{}` doesn't have any branches", word)))?;
+    effects.try_fold(first, |unified, effect| {
+        unified.unify(&effect).ok_or_else(|| {
+            EffectError::Mismatch(format!(
+                "the branches of `{}` don't agree: one {}, but another {}",
+                word, first, effect
+            ))
+        })
+    })
+}
@@ -26,6 +26,9 @@ pub enum CompilerError {
     InvalidState(String),
     ScannerError(ScannerError),
     TypeMismatch(String),
+    LocalInQuotation(String),
+    StackEffectMismatch(String),
+    UncheckedStackEffect(String),
     Infallible,
 }
 
@@ -48,6 +51,15 @@ impl fmt::Display for CompilerError {
             CompilerError::InvalidState(s) => write!(f, "Invalid compiler state: {}", s),
             CompilerError::ScannerError(err) => err.fmt(f),
             CompilerError::TypeMismatch(s) => write!(f, "Type mismatch: {}", s),
+            CompilerError::LocalInQuotation(name) => write!(
+                f,
+                "Local '{}' can't be used in a nested quotation; closures aren't supported yet",
+                name
+            ),
+            CompilerError::StackEffectMismatch(s) => write!(f, "Stack effect mismatch: {}", s),
+            CompilerError::UncheckedStackEffect(s) => {
+                write!(f, "Can't check stack effect of {}", s)
+            }
             CompilerError::Infallible => write!(f, "this shouldn't happen"),
         }
     }
@@ -1,37 +1,49 @@
+use std::collections::HashMap;
 use std::convert::{TryFrom, TryInto};
 use std::fmt::Debug;
-use std::fs;
+use std::fs::File;
 use std::mem;
 use std::path::Path;
 use std::result;
 
+use crate::module::internal::kernel::KERNEL;
 use crate::module::internal::sandbox::SANDBOX;
 use crate::module::Module;
 use log::Level;
 
+pub mod effects;
 pub mod error;
 
+use crate::compiler::effects::{
+    builtin_effect, combinator_step, opcode_step, Checker, EffectError, EffectResult, StackEffect,
+    Step,
+};
 use crate::compiler::error::{CompilerError, CompilerResult};
 use crate::core::Execute;
-use crate::env::Environment;
-use crate::error::{Error, Result};
+use crate::env::{Environment, SourceLoc};
+use crate::error::Result;
 use crate::scanner::error::ScannerError;
 use crate::scanner::Source;
 use crate::shared::{shared, unshare_clone, Shared};
 use crate::value::data::ValueData;
 use crate::value::lambda::{Callable, Lambda};
-use crate::value::{Value, ValueVec};
+use crate::value::{Pos, Value, ValueVec};
 use crate::vm::OpCode;
 use crate::{Scanner, VM};
 
 // TODO: TCO
 // TODO: Jump optimization
-// TODO: warning or error on unrecognized symbols/words
 
 #[derive(Default)]
 struct LambdaCompiler {
     words: Vec<String>,
     instructions: Vec<usize>,
+    /// Source locations, keyed by offset into `instructions`.
+    positions: Vec<(usize, SourceLoc)>,
+    /// Whether calls to words that aren't defined yet are bound to words
+    /// defined later. This is set for function and quotation bodies, but not
+    /// macro bodies, which use unknown words like `]` as symbols.
+    late_bound: bool,
 }
 
 // TODO: is there a faster hashmap I should use here?
@@ -42,6 +54,51 @@ pub struct ModuleCompiler {
 
     /// The name of the module.
     name: String,
+
+    /// The locals for the `::` definition being compiled, if there is one.
+    locals: Option<LocalsFrame>,
+
+    /// The stack effect declared by the definition being compiled, if it
+    /// has one that can be checked.
+    declared: Option<DeclaredEffect>,
+
+    /// Calls to words that weren't defined when they were compiled. Each
+    /// one has to be defined by the end of the module.
+    forward_refs: Vec<ForwardRef>,
+}
+
+/// A call to a word that's defined later in the module. The call goes
+/// through a predeclared op table slot, which the definition fills in.
+struct ForwardRef {
+    word: String,
+    op_index: usize,
+    /// Where the first call is, for the error if it's never defined.
+    loc: Option<SourceLoc>,
+}
+
+/// A stack effect declared in a definition, `: name ( a -- b ) ... ;`.
+struct DeclaredEffect {
+    name: String,
+    /// The effect as it's written, for error messages.
+    text: String,
+    /// The op table index of the word being defined.
+    op_index: Option<usize>,
+    effect: StackEffect,
+}
+
+/// The locals for a `::` definition.
+struct LocalsFrame {
+    /// The local names. Each one's index is how far down the return stack it is.
+    names: Vec<String>,
+
+    /// The lambda stack depth of the definition's body. This is `None` while
+    /// the body is being scanned, when the only things compiled are nested
+    /// quotations.
+    body_depth: Option<usize>,
+
+    /// How many values the body has pushed onto the return stack on top of
+    /// the locals.
+    return_depth: usize,
 }
 
 impl TryFrom<&Module> for ModuleCompiler {
@@ -59,6 +116,9 @@ impl TryFrom<&Module> for ModuleCompiler {
         Ok(ModuleCompiler {
             scanner,
             name: module.get_key(),
+            locals: None,
+            declared: None,
+            forward_refs: Vec::new(),
         })
     }
 }
@@ -68,6 +128,17 @@ pub struct Compiler {
     environment: Option<Shared<Environment>>,
     module_stack: Vec<ModuleCompiler>,
     lambda_stack: Vec<LambdaCompiler>,
+    /// The location of the value currently being compiled.
+    current_loc: Option<SourceLoc>,
+    /// The location of the value that the first compile error came from.
+    error_loc: Option<SourceLoc>,
+    /// The stack effects of the compiled functions and quotations, keyed by
+    /// their instruction pointers.
+    effects: HashMap<usize, EffectResult>,
+    /// The declared stack effects of words, keyed by op table index.
+    word_effects: HashMap<usize, StackEffect>,
+    /// Whether stack effects that can't be checked are errors.
+    strict_effects: bool,
 }
 
 impl Compiler {
@@ -78,6 +149,18 @@ impl Compiler {
             .cloned()
     }
 
+    pub fn set_environment(&mut self, env: Shared<Environment>) {
+        self.environment = Some(env);
+        self.effects.clear();
+        self.word_effects.clear();
+    }
+
+    /// When this is set, definitions whose declared stack effects can't be
+    /// checked fail to compile. Otherwise, they're logged as warnings.
+    pub fn set_strict_effects(&mut self, strict: bool) {
+        self.strict_effects = strict;
+    }
+
     pub fn current_module_compiler(&self) -> Option<&ModuleCompiler> {
         self.module_stack.last()
     }
@@ -100,23 +183,60 @@ impl Compiler {
 
     fn start_module_compiler(&mut self, name: &str, scanner: Scanner) {
         let name = name.to_string();
-        let mc = ModuleCompiler { scanner, name };
+        let mc = ModuleCompiler {
+            scanner,
+            name,
+            locals: None,
+            declared: None,
+            forward_refs: Vec::new(),
+        };
         self.module_stack.push(mc);
     }
 
     fn finish_module_compiler(&mut self) -> Result<()> {
-        self.module_stack.pop().ok_or_else(|| {
+        let module_compiler = self.module_stack.pop().ok_or_else(|| {
             CompilerError::InvalidState("no current module being compiled".to_string())
         })?;
+        self.check_forward_refs(&module_compiler.forward_refs)?;
         Ok(())
     }
 
+    /// Makes sure that every word called before it was defined was defined
+    /// by the end of the module.
+    fn check_forward_refs(&mut self, forward_refs: &[ForwardRef]) -> CompilerResult<()> {
+        let env = match self.environment.as_ref() {
+            Some(env) => env.clone(),
+            None => return Ok(()),
+        };
+        let env = env.borrow();
+        let undefined = forward_refs.iter().find(|forward_ref| {
+            env.get_callable(forward_ref.op_index)
+                .is_some_and(|lambda| !lambda.borrow().defined)
+        });
+        match undefined {
+            Some(ForwardRef {
+                word,
+                loc: Some(loc),
+                ..
+            }) => {
+                if self.error_loc.is_none() {
+                    self.error_loc = Some(loc.clone());
+                }
+                Err(CompilerError::UndefinedWord(format!("{} at {}", word, loc)))
+            }
+            Some(forward_ref) => Err(CompilerError::UndefinedWord(forward_ref.word.clone())),
+            None => Ok(()),
+        }
+    }
+
+    /// The module that definitions are going into. This is the innermost
+    /// vocabulary, if we're in one, or the module being compiled.
+    pub fn current_module_key(&self) -> Option<String> {
+        self.current_scanner().map(|s| s.module_key())
+    }
+
     fn pass1<E: Execute>(&mut self, executor: &mut E) -> Result<Vec<Value>> {
         let mut buffer = Vec::new();
-        let module_name = self
-            .current_module_compiler()
-            .map(|m| m.name.clone())
-            .ok_or_else(|| CompilerError::InvalidState("no current module".to_string()))?;
 
         while let Some(result) = self.scan_value() {
             let value = result?;
@@ -124,6 +244,9 @@ impl Compiler {
             log::trace!("Compiler::pass1 buffer {}", ValueVec(&buffer));
             // TODO: also compile functions here? there'd be fewer constants hanging around.
             if value.data == ValueData::Macro {
+                let module_name = self
+                    .current_module_key()
+                    .ok_or_else(|| CompilerError::InvalidState("no current module".to_string()))?;
                 let lambda = self.compile_macro(executor)?;
                 self.environment
                     .as_ref()
@@ -150,13 +273,10 @@ impl Compiler {
 
     fn compile_value(&mut self, value: Value) -> CompilerResult<()> {
         log::trace!("Compiler::compile_value {:?}", value.lexeme);
-        // If we're collecting words for a function/lambda, add to the current word list
-        if let Some(closure) = self.lambda_stack.last_mut() {
-            if let Some(ref lexeme) = value.lexeme {
-                closure.words.push(lexeme.clone());
-            }
-        }
-        match value.data {
+        self.push_lexeme(&value);
+        let loc = self.get_source_loc(&value);
+        let previous_loc = mem::replace(&mut self.current_loc, loc);
+        let result = match value.data {
             ValueData::Integer(_)
             | ValueData::Float(_)
             | ValueData::Boolean(_)
@@ -168,6 +288,10 @@ impl Compiler {
             | ValueData::Literal(_)
             | ValueData::Writer(_)
             | ValueData::Reader(_)
+            | ValueData::Coroutine(_)
+            | ValueData::Regex(_)
+            | ValueData::Keyword(_)
+            | ValueData::Record(_)
             | ValueData::Return(_, _) => self.compile_constant(value),
             ValueData::Function(ref lambda) if lambda.name.is_none() => {
                 self.compile_constant(value)
@@ -182,6 +306,96 @@ impl Compiler {
                 Ok(())
             }
             ValueData::Word(_) => unreachable!("ValueData::Word should not be compiled"),
+        };
+        if result.is_err() && self.error_loc.is_none() {
+            self.error_loc = self.current_loc.clone();
+        }
+        self.current_loc = previous_loc;
+        result
+    }
+
+    /// Where the value that caused the first compile error is, if an error
+    /// came from compiling a value and it has a location.
+    pub fn error_loc(&self) -> Option<&SourceLoc> {
+        self.error_loc.as_ref()
+    }
+
+    /// How far the scanner for the file at `path` has read, if the file is
+    /// being compiled. After a compile error, this is where it stopped.
+    pub fn scanner_pos(&self, path: &Path) -> Option<Pos> {
+        self.module_stack
+            .iter()
+            .rev()
+            .find(|m| m.scanner.source.get_path() == Some(path))
+            .map(|m| m.scanner.pos())
+    }
+
+    /// Where `value` came from, if it was read from the current scanner.
+    fn get_source_loc(&self, value: &Value) -> Option<SourceLoc> {
+        let pos = value.pos.clone()?;
+        let source = self
+            .current_scanner()
+            .map(|scanner| match scanner.source {
+                Source::ScriptFile { ref path } => path
+                    .file_name()
+                    .map(|name| name.to_string_lossy().to_string())
+                    .unwrap_or_else(|| scanner.source.get_key()),
+                ref source => source.get_key(),
+            })?;
+        Some(SourceLoc { source, pos })
+    }
+
+    /// Compiles the last value in a function. Calls to compiled words and
+    /// lambdas here reuse the caller's return frame instead of pushing a new one.
+    fn compile_tail_value(&mut self, value: Value) -> CompilerResult<()> {
+        log::trace!("Compiler::compile_tail_value {:?}", value.lexeme);
+        let loc = self.get_source_loc(&value);
+        let previous_loc = mem::replace(&mut self.current_loc, loc);
+        let result = self.compile_tail_call(value);
+        self.current_loc = previous_loc;
+        result
+    }
+
+    fn compile_tail_call(&mut self, value: Value) -> CompilerResult<()> {
+        if let ValueData::Symbol { ref word, .. } = value.data {
+            if word == "apply" || word == "call" {
+                self.push_lexeme(&value);
+                return self.compile_op(OpCode::TailApply);
+            }
+        }
+
+        let op_table_index = match self.get_compiled_op_index(&value) {
+            Some(op_table_index) => Some(op_table_index),
+            None => self.declare_forward_ref(&value)?,
+        };
+        if let Some(op_table_index) = op_table_index {
+            self.push_lexeme(&value);
+            self.compile_op_arg(OpCode::TailCall, op_table_index)
+        } else {
+            self.compile_value(value)
+        }
+    }
+
+    /// If we're collecting words for a function/lambda, add to the current word list
+    fn push_lexeme(&mut self, value: &Value) {
+        if let Some(closure) = self.lambda_stack.last_mut() {
+            if let Some(ref lexeme) = value.lexeme {
+                closure.words.push(lexeme.clone());
+            }
+        }
+    }
+
+    /// The op table index for a symbol, if it refers to a compiled (or predeclared) word.
+    fn get_compiled_op_index(&self, value: &Value) -> Option<usize> {
+        let (module, word) = value.as_symbol()?;
+        let env = self.environment.as_ref()?.borrow();
+        let op_table_index = env.get_op_index(module, word)?;
+        let lambda = env.get_callable(op_table_index)?;
+        let is_compiled = lambda.borrow().is_compiled();
+        if is_compiled {
+            Some(op_table_index)
+        } else {
+            None
         }
     }
 
@@ -192,6 +406,10 @@ impl Compiler {
             .get_word()
             .ok_or_else(|| CompilerError::UnsupportedToken(format!("{:?}", value)))?;
 
+        if let Some((op, depth)) = self.get_local_op(word)? {
+            return self.compile_op_arg(op, depth);
+        }
+
         match word {
             "<nop>" => self.compile_op(OpCode::Nop),
             "dup" => self.compile_op(OpCode::Dup),
@@ -199,9 +417,15 @@ impl Compiler {
             "rot" => self.compile_op(OpCode::Rot),
             "drop" => self.compile_op(OpCode::Drop),
             "clear" => self.compile_op(OpCode::Clear),
-            "stack-size" => self.compile_op(OpCode::StackSize),
-            ">r" => self.compile_op(OpCode::ToR),
-            "r>" => self.compile_op(OpCode::RFrom),
+            "stack-size" | "depth" => self.compile_op(OpCode::StackSize),
+            ">r" => {
+                self.shift_locals(true);
+                self.compile_op(OpCode::ToR)
+            }
+            "r>" => {
+                self.shift_locals(false);
+                self.compile_op(OpCode::RFrom)
+            }
             "r@" => self.compile_op(OpCode::RFetch),
             "+" => self.compile_op(OpCode::Add),
             "-" => self.compile_op(OpCode::Subtract),
@@ -224,7 +448,7 @@ impl Compiler {
             }
             "!" => self.compile_op(OpCode::Not),
             "?" => self.compile_op(OpCode::Question),
-            "apply" => self.compile_op(OpCode::Apply),
+            "apply" | "call" => self.compile_op(OpCode::Apply),
             "lit" => self.compile_op(OpCode::LitStack),
             "compile" => self.compile_op(OpCode::Compile),
             "break" => {
@@ -254,6 +478,13 @@ impl Compiler {
         }
 
         self.start_function();
+        if let Some(lambda) = self.lambda_stack.last_mut() {
+            lambda.late_bound = true;
+        }
+        let locals = self.get_body_locals();
+        for _ in 0..locals {
+            self.compile_op(OpCode::ToR)?;
+        }
         let mut buffer = Vec::new();
 
         for word in words {
@@ -265,12 +496,339 @@ impl Compiler {
             }
         }
 
+        let effect = self
+            .infer_effect(&buffer)
+            .map(|e| StackEffect::new(e.inputs + locals, e.outputs));
+        let tail = buffer.pop();
         self.pass2(buffer)?;
+        match tail {
+            Some(tail) if locals > 0 && self.uses_locals_frame(&tail) => {
+                self.compile_value(tail)?;
+                self.drop_locals(locals)?;
+            }
+            Some(tail) => {
+                self.drop_locals(locals)?;
+                self.compile_tail_value(tail)?;
+            }
+            None => self.drop_locals(locals)?,
+        }
         // On loops these get replaced with a jump to the beginning.
         self.compile_op(OpCode::Nop)?;
         self.compile_op(OpCode::Nop)?;
         self.compile_op(OpCode::Return)?;
-        self.end_function().map_err(Error::from)
+        let lambda = self.end_function()?;
+        if let Some(ip) = lambda.get_ip() {
+            self.effects.insert(ip, effect);
+        }
+        Ok(lambda)
+    }
+
+    /// Reads the stack effect of a `::` definition, `( a b -- c )`. The
+    /// inputs become locals for the definition.
+    pub fn scan_locals(&mut self, name: &Value) -> Result<()> {
+        let effect = self
+            .current_scanner_mut()
+            .ok_or(ScannerError::NotInitialized)?
+            .scan_stack_effect()?;
+        self.declare_effect(name, Some(&effect))?;
+        let mut names = Vec::new();
+        let mut depth = 0;
+        // Nested effects, like `quot: ( a -- b )`, describe the input named
+        // `quot`, but they aren't names themselves.
+        for word in effect {
+            match word.as_str() {
+                "(" => depth += 1,
+                ")" => depth -= 1,
+                "--" if depth == 0 => break,
+                _ if depth == 0 => names.push(word.strip_suffix(':').unwrap_or(&word).to_string()),
+                _ => {}
+            }
+        }
+
+        let module_compiler = self
+            .current_module_compiler_mut()
+            .ok_or_else(|| CompilerError::InvalidState("no current module".to_string()))?;
+        module_compiler.locals = Some(LocalsFrame {
+            names,
+            body_depth: None,
+            return_depth: 0,
+        });
+        Ok(())
+    }
+
+    /// Reads the stack effect of a `:` definition, if it has one, so that
+    /// `check_effect` can compare it with the body.
+    pub fn scan_effect(&mut self, name: &Value) -> Result<()> {
+        let effect = self
+            .current_scanner_mut()
+            .ok_or(ScannerError::NotInitialized)?
+            .scan_optional_stack_effect()?;
+        self.declare_effect(name, effect.as_deref())
+    }
+
+    /// Declares the stack effect of a word that a macro defined without a
+    /// stack effect comment, like the words `record:` makes.
+    pub fn declare_word_effect(&mut self, op_index: usize, effect: StackEffect) {
+        self.word_effects.insert(op_index, effect);
+    }
+
+    fn declare_effect(&mut self, name: &Value, words: Option<&[String]>) -> Result<()> {
+        let declared = match (name.as_symbol(), words) {
+            (Some((module, word)), Some(words)) => StackEffect::parse(words).map(|effect| {
+                let op_index = self
+                    .environment
+                    .as_ref()
+                    .and_then(|e| e.borrow().get_op_index(module, word));
+                if let Some(op_index) = op_index {
+                    self.word_effects.insert(op_index, effect);
+                }
+                DeclaredEffect {
+                    name: word.to_string(),
+                    text: format!("( {} )", words.join(" ")),
+                    op_index,
+                    effect,
+                }
+            }),
+            _ => None,
+        };
+        let module_compiler = self
+            .current_module_compiler_mut()
+            .ok_or_else(|| CompilerError::InvalidState("no current module".to_string()))?;
+        module_compiler.declared = declared;
+        Ok(())
+    }
+
+    /// Compares the stack effect that the definition being compiled declared
+    /// with the effect of its body, `lambda`.
+    pub fn check_effect(&mut self, lambda: &Value) -> Result<()> {
+        let declared = match self
+            .current_module_compiler_mut()
+            .and_then(|m| m.declared.take())
+        {
+            Some(declared) => declared,
+            None => return Ok(()),
+        };
+        let inferred = lambda
+            .as_function()
+            .and_then(|f| f.get_ip())
+            .and_then(|ip| self.effects.get(&ip))
+            .cloned()
+            .unwrap_or_else(|| Err(EffectError::Unknown("its body isn't known".to_string())));
+
+        match inferred {
+            Ok(effect) if effect.satisfies(&declared.effect) => Ok(()),
+            Ok(effect) => Err(CompilerError::StackEffectMismatch(format!(
+                "`{}` is declared {}, but {}",
+                declared.name, declared.text, effect
+            ))
+            .into()),
+            Err(EffectError::Mismatch(reason)) => Err(CompilerError::StackEffectMismatch(format!(
+                "in `{}`, {}",
+                declared.name, reason
+            ))
+            .into()),
+            Err(EffectError::Unknown(reason)) if self.strict_effects => Err(
+                CompilerError::UncheckedStackEffect(format!("`{}`: {}", declared.name, reason))
+                    .into(),
+            ),
+            Err(EffectError::Unknown(reason)) => {
+                log::warn!(
+                    "can't check the stack effect of `{}`: {}",
+                    declared.name,
+                    reason
+                );
+                Ok(())
+            }
+        }
+    }
+
+    /// Works out the stack effect of a macro-expanded body.
+    fn infer_effect(&self, values: &[Value]) -> EffectResult {
+        Checker::infer(values.iter().map(|value| self.effect_step(value)))
+    }
+
+    fn effect_step(&self, value: &Value) -> Step {
+        match value.data {
+            ValueData::Function(ref lambda) if lambda.name.is_none() => {
+                Step::Quotation(self.quotation_effect(lambda))
+            }
+            ValueData::Function(_) => Step::Effect(StackEffect::new(0, 0)),
+            // These are what `cond` and `case` need to know about.
+            ValueData::List(ref items) => Step::Vector(
+                items
+                    .iter()
+                    .map(|item| match item.borrow().data {
+                        ValueData::Function(ref lambda) if lambda.name.is_none() => {
+                            Some(self.quotation_effect(lambda))
+                        }
+                        _ => None,
+                    })
+                    .collect(),
+            ),
+            ValueData::Symbol {
+                ref module,
+                ref word,
+            } => self.symbol_step(module, word),
+            ValueData::EndOfInput | ValueData::Macro | ValueData::Word(_) => {
+                Step::Unknown(format!("`{}` isn't a value", value))
+            }
+            _ => Step::Value,
+        }
+    }
+
+    fn quotation_effect(&self, lambda: &Lambda) -> EffectResult {
+        lambda
+            .get_ip()
+            .and_then(|ip| self.effects.get(&ip))
+            .cloned()
+            .unwrap_or_else(|| Err(EffectError::Unknown("a quotation isn't known".to_string())))
+    }
+
+    fn symbol_step(&self, module: &str, word: &str) -> Step {
+        if let Some(frame) = self.current_locals() {
+            let is_local = |word: &str| frame.names.iter().any(|name| name == word);
+            if is_local(word) {
+                return Step::Value;
+            }
+            if word.strip_suffix('!').is_some_and(is_local) {
+                return Step::Effect(StackEffect::new(1, 0));
+            }
+        }
+        if let Some(step) = opcode_step(word) {
+            return step;
+        }
+
+        let env = match self.environment.as_ref() {
+            Some(env) => env.borrow(),
+            None => return Step::Unknown("there's no environment".to_string()),
+        };
+        let op_index = match env.get_op_index(module, word) {
+            Some(op_index) => op_index,
+            None => return Step::Unknown(format!("`{}` isn't defined yet", word)),
+        };
+        let declared_index = self
+            .current_module_compiler()
+            .and_then(|m| m.declared.as_ref())
+            .and_then(|d| d.op_index);
+        if declared_index == Some(op_index) {
+            return Step::Unknown(format!("`{}` is recursive", word));
+        }
+        if env.get_op_index(KERNEL, word) == Some(op_index) {
+            if let Some(step) = combinator_step(word) {
+                return step;
+            }
+        }
+        if let Some(effect) = self.word_effects.get(&op_index) {
+            return Step::Effect(*effect);
+        }
+
+        let builtin = env.get_callable(op_index).and_then(|lambda| {
+            let lambda = lambda.borrow();
+            match lambda.callable {
+                Callable::BuiltIn { .. } => lambda.name.as_deref().and_then(builtin_effect),
+                _ => None,
+            }
+        });
+        match builtin {
+            Some(effect) => Step::Effect(effect),
+            None => Step::Unknown(format!("`{}` doesn't declare its stack effect", word)),
+        }
+    }
+
+    /// Compiles the body of a `::` definition with its locals in scope.
+    pub fn compile_locals<E: Execute>(
+        &mut self,
+        executor: &mut E,
+        words: &[Value],
+    ) -> Result<Lambda> {
+        let body_depth = self.lambda_stack.len() + 1;
+        match self.current_locals_mut() {
+            Some(frame) => frame.body_depth = Some(body_depth),
+            None => {
+                return Err(CompilerError::InvalidState(
+                    "compiling locals without a stack effect".to_string(),
+                )
+                .into())
+            }
+        }
+        let lambda = self.compile_list(executor, words);
+        if let Some(module_compiler) = self.current_module_compiler_mut() {
+            module_compiler.locals = None;
+        }
+        lambda
+    }
+
+    fn current_locals(&self) -> Option<&LocalsFrame> {
+        self.current_module_compiler()
+            .and_then(|m| m.locals.as_ref())
+    }
+
+    fn current_locals_mut(&mut self) -> Option<&mut LocalsFrame> {
+        self.current_module_compiler_mut()
+            .and_then(|m| m.locals.as_mut())
+    }
+
+    /// How many locals the function being compiled has. This is only non-zero
+    /// for the body of a `::` definition.
+    fn get_body_locals(&self) -> usize {
+        self.current_locals()
+            .filter(|frame| frame.body_depth == Some(self.lambda_stack.len()))
+            .map(|frame| frame.names.len())
+            .unwrap_or_default()
+    }
+
+    /// The op and operand to compile `word` as a load from or store to a
+    /// local, if it names one.
+    fn get_local_op(&self, word: &str) -> CompilerResult<Option<(OpCode, usize)>> {
+        let frame = match self.current_locals() {
+            Some(frame) => frame,
+            None => return Ok(None),
+        };
+        let (op, name) = match word.strip_suffix('!') {
+            Some(name) if frame.names.iter().any(|n| n == name) => (OpCode::StoreLocal, name),
+            _ => (OpCode::LoadLocal, word),
+        };
+        let slot = match frame.names.iter().position(|n| n == name) {
+            Some(slot) => slot,
+            None => return Ok(None),
+        };
+        if frame.body_depth != Some(self.lambda_stack.len()) {
+            return Err(CompilerError::LocalInQuotation(name.to_string()));
+        }
+        Ok(Some((op, slot + frame.return_depth)))
+    }
+
+    /// Track values that the body of a `::` definition moves on or off the
+    /// return stack, since the locals are under them.
+    fn shift_locals(&mut self, to_return_stack: bool) {
+        let lambda_depth = self.lambda_stack.len();
+        if let Some(frame) = self.current_locals_mut() {
+            if frame.body_depth == Some(lambda_depth) {
+                frame.return_depth = if to_return_stack {
+                    frame.return_depth + 1
+                } else {
+                    frame.return_depth.saturating_sub(1)
+                };
+            }
+        }
+    }
+
+    /// Whether compiling `value` needs the locals to still be on the return stack.
+    fn uses_locals_frame(&self, value: &Value) -> bool {
+        match value.data.get_word() {
+            Some(">r") | Some("r>") | Some("r@") => true,
+            Some(word) => matches!(self.get_local_op(word), Ok(Some(_))),
+            None => false,
+        }
+    }
+
+    /// Removes the locals from the return stack before returning.
+    fn drop_locals(&mut self, count: usize) -> CompilerResult<()> {
+        for _ in 0..count {
+            self.compile_op(OpCode::RFrom)?;
+            self.compile_op(OpCode::Drop)?;
+        }
+        Ok(())
     }
 
     fn execute_macro<E: Execute>(
@@ -327,10 +885,20 @@ impl Compiler {
                 arg,
                 closure.instructions.len()
             );
+            if let Some(loc) = self.current_loc.as_ref() {
+                closure
+                    .positions
+                    .push((closure.instructions.len(), loc.clone()));
+            }
             closure.instructions.push(arg);
         } else if let Some(e) = self.environment.as_ref() {
             log::trace!("Compiler::compile_instruction {}", arg);
-            e.borrow_mut().add_instruction(arg)
+            let mut e = e.borrow_mut();
+            if let Some(loc) = self.current_loc.as_ref() {
+                let ip = e.instructions_len();
+                e.add_position(ip, loc.clone());
+            }
+            e.add_instruction(arg)
         }
     }
 
@@ -386,6 +954,12 @@ impl Compiler {
             .as_ref()
             .map(|e| e.borrow_mut().extend_instructions(instructions))
             .unwrap_or_default();
+        if let Some(e) = self.environment.as_ref() {
+            let mut e = e.borrow_mut();
+            for (offset, loc) in lambda.positions.drain(..) {
+                e.add_position(ip + offset, loc);
+            }
+        }
 
         while lambda.words.last().map(|w| w == "]").unwrap_or(false) {
             lambda.words.pop();
@@ -430,6 +1004,10 @@ impl Compiler {
             word,
             op_table_index
         );
+        let op_table_index = match op_table_index {
+            Some(op_table_index) => Some(op_table_index),
+            None => self.declare_forward_ref(value)?,
+        };
         if let Some(op_table_index) = op_table_index {
             self.compile_instruction(op_table_index);
             Ok(())
@@ -445,6 +1023,50 @@ impl Compiler {
         }
     }
 
+    /// Predeclares a word that a function or quotation body calls before
+    /// it's defined, so the call is bound to the definition when it comes
+    /// later in the module. This returns the slot's op table index, or
+    /// `None` if the value isn't a call to an unknown word that can be bound
+    /// late.
+    fn declare_forward_ref(&mut self, value: &Value) -> CompilerResult<Option<usize>> {
+        let (module, word) = match value.as_symbol() {
+            Some(symbol) => symbol,
+            None => return Ok(None),
+        };
+        let late_bound = self.lambda_stack.last().is_some_and(|l| l.late_bound);
+        if !late_bound
+            || self.current_module_compiler().is_none()
+            || opcode_step(word).is_some()
+            || self.get_local_op(word)?.is_some()
+        {
+            return Ok(None);
+        }
+        let env = self
+            .environment
+            .as_ref()
+            .ok_or(CompilerError::MissingEnvironment)?
+            .clone();
+        if env.borrow().get_op_index(module, word).is_some() {
+            return Ok(None);
+        }
+
+        log::trace!("Compiler::declare_forward_ref {}::{}", module, word);
+        env.borrow_mut()
+            .add_to_op_table(module, shared(Lambda::new_undefined(word)))?;
+        let op_index = env.borrow().get_op_index(module, word).ok_or_else(|| {
+            CompilerError::InvalidState(format!("forward reference to {} wasn't added", word))
+        })?;
+        let loc = self.current_loc.clone();
+        if let Some(module_compiler) = self.current_module_compiler_mut() {
+            module_compiler.forward_refs.push(ForwardRef {
+                word: word.to_string(),
+                op_index,
+                loc,
+            });
+        }
+        Ok(Some(op_index))
+    }
+
     /// Compiles a lambda expression
     pub fn compile_lambda(&mut self) -> CompilerResult<()> {
         log::trace!("Compiler::compile_lambda -- emitting Return");
@@ -467,8 +1089,7 @@ impl Compiler {
     fn add_function(&mut self, lambda: Lambda) -> CompilerResult<()> {
         log::trace!("Compiler::add_function {:?}", lambda.name);
         let module_name = self
-            .current_scanner()
-            .map(|s| s.source.get_key())
+            .current_module_key()
             .ok_or_else(|| CompilerError::InvalidState("unknown path for module".to_string()))?;
         if let Some(env) = self.environment.as_ref() {
             env.borrow_mut()
@@ -504,11 +1125,23 @@ impl Compiler {
     pub fn scan_value(&mut self) -> Option<CompilerResult<Value>> {
         let value = self
             .current_scanner_mut()
-            .and_then(|s| s.scan_value().map(|r| r.map_err(CompilerError::from)));
+            .and_then(|s| s.scan_value().map(|r| r.map_err(CompilerError::from)))
+            .map(|r| r.map(|value| self.intern(value)));
         log::trace!("Compiler::scan_value {:?}", value);
         value
     }
 
+    /// Swaps a symbol that the scanner read for the environment's interned
+    /// one.
+    fn intern(&self, mut value: Value) -> Value {
+        let keyword = match (&value.data, &self.environment) {
+            (ValueData::Keyword(keyword), Some(env)) => env.borrow_mut().intern(keyword.name()),
+            _ => return value,
+        };
+        value.data = ValueData::Keyword(keyword);
+        value
+    }
+
     pub fn scan_word(&mut self) -> Result<Value> {
         let word = self
             .scan_value()
@@ -527,7 +1160,8 @@ impl Compiler {
                 list.into_iter()
                     .collect::<result::Result<Vec<_>, _>>()
                     .map_err(CompilerError::from)
-            });
+            })
+            .map(|list| list.into_iter().map(|value| self.intern(value)).collect());
         log::trace!("Compiler::scan_value_list {:?}", value_list);
         value_list
     }
@@ -603,11 +1237,13 @@ impl Compiler {
         log::trace!("Compiler::use_module {} {:?}", module_spec, context);
         let mut module_spec = module_spec;
 
-        if !env.borrow_mut().handle_internal_module(&module_spec)? {
-            let (module_name, module_file) = env
-                .borrow()
-                .find_module(&module_spec, context)?
-                .ok_or_else(|| CompilerError::ModuleNotFound(module_spec.clone()))?;
+        let is_internal = env.borrow_mut().handle_internal_module(&module_spec)?;
+        let found = if is_internal {
+            None
+        } else {
+            env.borrow().find_module(&module_spec, context)?
+        };
+        if let Some((module_name, module_file)) = found {
             log::trace!("Compiler::use_module {} => {:?}", module_name, module_file);
             module_spec = module_name.clone();
 
@@ -621,6 +1257,9 @@ impl Compiler {
             } else {
                 self.create_and_compile_module(vm, &module_name, &module_file)?;
             }
+        } else if !is_internal && env.borrow().get_module(&module_spec).is_none() {
+            // Vocabularies are already loaded, but they aren't in a file.
+            return Err(CompilerError::ModuleNotFound(module_spec).into());
         }
 
         self.use_into_current_module(&env, &module_spec)?;
@@ -628,6 +1267,34 @@ impl Compiler {
         Ok(())
     }
 
+    /// Starts a vocabulary, `vocab: name`. Words until the matching `;vocab`
+    /// are defined in the module `name` instead of the current one.
+    pub fn begin_vocab(&mut self) -> Result<()> {
+        let name = self.scan_word()?;
+        let name = name
+            .as_word()
+            .ok_or_else(|| CompilerError::UnsupportedToken(format!("vocabulary name {}", name)))?
+            .to_string();
+        log::trace!("Compiler::begin_vocab {}", name);
+        self.environment()?
+            .borrow_mut()
+            .get_or_create_module_mut(&name);
+        self.current_scanner_mut()
+            .ok_or_else(|| CompilerError::InvalidState("missing scanner".to_string()))?
+            .push_vocab(&name);
+        Ok(())
+    }
+
+    /// Finishes the innermost vocabulary.
+    pub fn end_vocab(&mut self) -> Result<()> {
+        let name = self
+            .current_scanner_mut()
+            .and_then(|s| s.pop_vocab())
+            .ok_or_else(|| CompilerError::InvalidState(";vocab without vocab:".to_string()))?;
+        log::trace!("Compiler::end_vocab {}", name);
+        Ok(())
+    }
+
     fn create_and_compile_module(
         &mut self,
         vm: &mut VM,
@@ -635,8 +1302,11 @@ impl Compiler {
         module_file: &Path,
     ) -> Result<()> {
         let env = self.environment.as_ref().unwrap().clone();
-        let input = fs::read_to_string(module_file)?;
-        let scanner = Scanner::from_module(module_name, module_file, &input);
+        let source = Source::Module {
+            name: module_name.to_string(),
+            path: module_file.to_path_buf(),
+        };
+        let scanner = Scanner::from_reader(source, File::open(module_file)?);
         env.borrow_mut().get_or_create_module_mut(module_name);
         self.compile_module_passes(vm, module_name, scanner)?;
         Ok(())
@@ -674,8 +1344,7 @@ impl Compiler {
         source_name: &str,
     ) -> Result<()> {
         log::trace!("Compiler::use_into_current_module {}", source_name);
-        if let Some(Scanner { source, .. }) = self.current_scanner_mut() {
-            let key = source.get_key();
+        if let Some(key) = self.current_module_key() {
             let env = env.clone();
             env.borrow_mut().use_module(source_name, &key)?;
             Ok(())
@@ -688,7 +1357,7 @@ impl Compiler {
         log::trace!("Compiler::get_macro {}", trigger);
         if let Some(word) = trigger.get_word() {
             // log::trace!("Compiler::get_macro word {}", word);
-            if let Some(module_name) = self.current_module_compiler().as_ref().map(|m| &m.name) {
+            if let Some(module_name) = self.current_module_key().as_ref() {
                 // log::trace!("Compiler::get_macro module {}", module_name);
                 return self
                     .environment
@@ -731,8 +1400,11 @@ impl Compiler {
         env: Shared<Environment>,
         file: &Path,
     ) -> Result<()> {
-        let input = fs::read_to_string(file)?;
-        self.compile_scanner(vm, env, Scanner::from_script(file, &input))
+        let source = Source::ScriptFile {
+            path: file.to_path_buf(),
+        };
+        let scanner = Scanner::from_reader(source, File::open(file)?);
+        self.compile_scanner(vm, env, scanner)
     }
 
     pub fn compile_module(
@@ -742,8 +1414,12 @@ impl Compiler {
         name: &str,
         file: &Path,
     ) -> Result<()> {
-        let input = fs::read_to_string(file)?;
-        self.compile_scanner(vm, env, Scanner::from_module(name, file, &input))
+        let source = Source::Module {
+            name: name.to_string(),
+            path: file.to_path_buf(),
+        };
+        let scanner = Scanner::from_reader(source, File::open(file)?);
+        self.compile_scanner(vm, env, scanner)
     }
 
     pub fn compile_internal(
@@ -764,6 +1440,9 @@ impl Compiler {
     ) -> Result<()> {
         log::trace!("Compiler::compile_scanner {:?}", scanner.source);
         self.environment = Some(env.clone());
+        if self.module_stack.is_empty() {
+            self.error_loc = None;
+        }
         log::trace!(
             "Compiler::compile_scanner -- env set {}",
             self.environment.is_some()
@@ -3,6 +3,7 @@ use std::convert::TryFrom;
 use super::*;
 use crate::core::Tardi;
 use crate::env::Environment;
+use crate::error::Error;
 use crate::shared::unshare_clone;
 use crate::value::{Pos, Value};
 
@@ -297,6 +298,45 @@ fn test_compile_macro_scan_value_list() {
     );
 }
 
+#[test]
+fn test_compile_macro_scan_values() {
+    // env_logger::init();
+    let mut tardi = Tardi::new(None).unwrap();
+
+    let result = tardi.execute_str(
+        r#"
+            uses: std/scanning
+            uses: std/vectors
+            MACRO: pair
+                dup >r
+                2 scan-values
+                r> push! ;
+        "#,
+    );
+    assert!(result.is_ok(), "ERROR MACRO definition: {:?}", result);
+
+    let result = tardi.execute_str(r#"40 pair 41 \ 42"#);
+    assert!(result.is_ok(), "ERROR MACRO execution: {:?}", result);
+    let stack = tardi.stack();
+    assert_eq!(stack.len(), 3, "stack = {}", ValueVec(&stack));
+    assert_eq!(stack[0], 40.into());
+    assert_eq!(stack[2], 42.into());
+
+    let list = stack[1].as_list().unwrap();
+    assert_eq!(2, list.len());
+    assert_eq!(
+        ValueData::Integer(41),
+        unshare_clone(list.first().cloned().unwrap()).data,
+    );
+    assert_eq!(
+        ValueData::Word("\\".to_string()),
+        unshare_clone(list.get(1).cloned().unwrap()).data,
+    );
+
+    let result = tardi.execute_str("pair 43");
+    assert!(result.is_err(), "pair with one token: {:?}", result);
+}
+
 #[test]
 fn test_compile_macro_scan_object_list_handles_flat_structures() {
     // env_logger::init();
@@ -452,3 +492,165 @@ fn test_compile_macro_scan_object_list_allows_heterogeneous_embedded_structures(
         unshare_clone(list.get(1).cloned().unwrap()).data,
     );
 }
+
+fn words(effect: &str) -> Vec<String> {
+    effect.split_whitespace().map(String::from).collect()
+}
+
+#[test]
+fn test_parse_stack_effect() {
+    assert_eq!(
+        StackEffect::parse(&words("a b -- c")),
+        Some(StackEffect::new(2, 1))
+    );
+    assert_eq!(
+        StackEffect::parse(&words("x quot: ( x -- y ) -- y")),
+        Some(StackEffect::new(2, 1))
+    );
+    assert_eq!(
+        StackEffect::parse(&words("( -- ) --")),
+        Some(StackEffect::new(1, 0))
+    );
+    assert_eq!(StackEffect::parse(&words("..a quot -- ..b")), None);
+    assert_eq!(StackEffect::parse(&words("a b")), None);
+}
+
+#[test]
+fn test_compile_checks_stack_effects() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str(
+        r#"
+        : sq ( n -- n' ) dup * ;
+        : sign ( n -- s ) 0 < [ -1 ] [ 1 ] if ;
+        : second ( x y -- y ) nip ;
+        : bump ( n -- n' ) dup 0 < [ 1 + ] when ;
+        :: hyp ( a b -- c ) a sq b sq + ;
+        3 sq -2 sign 1 2 second -1 bump 3 4 hyp
+        "#,
+    );
+    assert!(result.is_ok(), "ERROR: {:?}", result);
+    assert_eq!(
+        tardi.stack(),
+        vec![
+            ValueData::Integer(9).into(),
+            ValueData::Integer(-1).into(),
+            ValueData::Integer(2).into(),
+            ValueData::Integer(0).into(),
+            ValueData::Integer(25).into(),
+        ]
+    );
+}
+
+#[test]
+fn test_compile_stack_effect_mismatch() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str(": bad ( a b -- c ) dup ;");
+    assert!(
+        matches!(
+            result,
+            Err(Error::CompilerError(CompilerError::StackEffectMismatch(_)))
+        ),
+        "result = {:?}",
+        result
+    );
+
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str(": branches ( n -- m ) 0 < [ 1 ] [ ] if ;");
+    assert!(
+        matches!(
+            result,
+            Err(Error::CompilerError(CompilerError::StackEffectMismatch(_)))
+        ),
+        "result = {:?}",
+        result
+    );
+}
+
+#[test]
+fn test_compile_unchecked_stack_effects() {
+    let input = ": countdown ( n -- ) dup 0 > [ 1 - countdown ] [ drop ] if ; 3 countdown";
+
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str(input);
+    assert!(result.is_ok(), "ERROR: {:?}", result);
+
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi.compiler.set_strict_effects(true);
+    let result = tardi.execute_str(input);
+    assert!(
+        matches!(
+            result,
+            Err(Error::CompilerError(CompilerError::UncheckedStackEffect(_)))
+        ),
+        "result = {:?}",
+        result
+    );
+}
+
+#[test]
+fn test_compile_checks_cond_and_case_effects() {
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi.compiler.set_strict_effects(true);
+    let result = tardi.execute_str(
+        r#"
+        : sign ( n -- s )
+            { [ dup 0 < ] [ drop -1 ] [ dup 0 == ] [ drop 0 ] [ drop 1 ] } cond ;
+        : name ( n -- s ) { 1 [ "one" ] 2 [ "two" ] [ drop "many" ] } case ;
+        -5 sign 2 name 3 name
+        "#,
+    );
+    assert!(result.is_ok(), "ERROR: {:?}", result);
+    assert_eq!(
+        tardi.stack(),
+        vec![
+            ValueData::Integer(-1).into(),
+            ValueData::String("two".to_string()).into(),
+            ValueData::String("many".to_string()).into(),
+        ]
+    );
+
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str(": bad ( n -- s ) { 1 [ \"one\" ] [ drop ] } case ;");
+    assert!(
+        matches!(
+            result,
+            Err(Error::CompilerError(CompilerError::StackEffectMismatch(_)))
+        ),
+        "result = {:?}",
+        result
+    );
+}
+
+#[test]
+fn test_compile_checks_combinator_effects() {
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi.compiler.set_strict_effects(true);
+    let result = tardi.execute_str(
+        r#"
+        : pair ( x -- y z ) [ 2 / ] [ 2 * ] bi ;
+        : bumps ( x y -- x' y' ) [ 1 + ] [ 1 - ] bi* ;
+        : squares ( x y z -- a b c ) [ dup * ] tri@ ;
+        : parts ( x -- a b c ) { [ 1 + ] [ [ 2 * ] keep + ] [ ] } cleave ;
+        7 pair 1 1 bumps 1 2 3 squares 4 parts
+        "#,
+    );
+    assert!(result.is_ok(), "ERROR: {:?}", result);
+    assert_eq!(
+        tardi.stack(),
+        [3, 14, 2, 0, 1, 4, 9, 5, 12, 4]
+            .iter()
+            .map(|n| ValueData::Integer(*n).into())
+            .collect::<Vec<_>>()
+    );
+
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str(": bad ( x -- y ) [ 1 + ] [ 2 * ] bi ;");
+    assert!(
+        matches!(
+            result,
+            Err(Error::CompilerError(CompilerError::StackEffectMismatch(_)))
+        ),
+        "result = {:?}",
+        result
+    );
+}
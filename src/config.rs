@@ -1,18 +1,38 @@
 use std::path::{Path, PathBuf};
 use std::{env, fs};
 
-use directories::ProjectDirs;
+use directories::{BaseDirs, ProjectDirs};
 use figment::providers::{Env, Format, Serialized, Toml};
 use figment::value::{Dict, Map};
 use figment::{Figment, Metadata, Profile, Provider};
 use serde::{Deserialize, Serialize};
 
 use crate::error::{Error, Result};
+use crate::vm::{DEFAULT_MAX_DEPTH, DEFAULT_MAX_STACK};
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct Config {
     pub repl: ReplConfig,
     pub module_path: Vec<PathBuf>,
+    /// Whether definitions whose declared stack effects can't be checked
+    /// fail to compile, instead of just logging a warning.
+    #[serde(default)]
+    pub strict_effects: bool,
+    /// How many values the data stack can hold.
+    #[serde(default = "default_max_stack")]
+    pub max_stack: usize,
+    /// How deep the return stack can get, which limits how deep calls can
+    /// nest.
+    #[serde(default = "default_max_depth")]
+    pub max_depth: usize,
+}
+
+fn default_max_stack() -> usize {
+    DEFAULT_MAX_STACK
+}
+
+fn default_max_depth() -> usize {
+    DEFAULT_MAX_DEPTH
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -59,6 +79,9 @@ impl Default for Config {
         Config {
             repl: ReplConfig::default(),
             module_path: paths,
+            strict_effects: false,
+            max_stack: DEFAULT_MAX_STACK,
+            max_depth: DEFAULT_MAX_DEPTH,
         }
     }
 }
@@ -104,6 +127,23 @@ fn default_config_file() -> Option<PathBuf> {
         .map(|pd| pd.config_dir().join("tardi.toml").to_owned())
 }
 
+/// The REPL history file, if one isn't configured.
+fn default_history_file() -> Option<PathBuf> {
+    BaseDirs::new().map(|bd| bd.home_dir().join(".tardi_history"))
+}
+
+/// Where the REPL history was kept before it defaulted to `~/.tardi_history`.
+/// This is only given if `history_file` is the default and there's a file at
+/// the old location.
+pub fn old_history_file(history_file: &Path) -> Option<PathBuf> {
+    if default_history_file().as_deref() != Some(history_file) {
+        return None;
+    }
+    ProjectDirs::from("", "", "Tardi")
+        .map(|pd| pd.data_local_dir().join("repl-history.txt"))
+        .filter(|file| file.exists())
+}
+
 // TODO: can I use the Cli struct as a provider?
 // TODO: different qualifier and organization
 /// This reads the configuration from a file and runs it through a standard
@@ -139,12 +179,7 @@ pub fn read_config_sources(config_file: &Option<&Path>) -> Result<Config> {
     log::debug!("configuration read: {:#?}", config);
 
     // patch the history_file
-    let project_dirs = ProjectDirs::from("", "", "Tardi");
-    config.repl.history_file = config.repl.history_file.or_else(|| {
-        project_dirs
-            .as_ref()
-            .map(|pd| pd.data_local_dir().join("repl-history.txt").to_path_buf())
-    });
+    config.repl.history_file = config.repl.history_file.or_else(default_history_file);
 
     Ok(config)
 }
@@ -1,6 +1,7 @@
 use std::fs;
 use std::path::{Path, PathBuf};
 
+use crate::cache;
 use crate::compiler::Compiler;
 use crate::config::Config;
 use crate::env::Environment;
@@ -9,9 +10,16 @@ use crate::module::internal::kernel::KERNEL;
 use crate::module::internal::sandbox::SANDBOX;
 use crate::shared::{shared, Shared};
 use crate::value::data::ValueData;
-use crate::value::lambda::Lambda;
+use crate::value::lambda::{Lambda, OpFn};
 use crate::value::Value;
-use crate::vm::VM;
+use crate::vm::{Hook, TestResult, TraceFrame, VM};
+
+/// The kernel's bootstrap scripts, in the order they run.
+pub const BOOTSTRAP_SOURCES: &[&str] = &[
+    include_str!("../bootstrap/00-core-macros.tardi"),
+    include_str!("../bootstrap/01-stack-ops.tardi"),
+    include_str!("../bootstrap/02-core-ops.tardi"),
+];
 
 pub trait Execute {
     fn run(&mut self, env: Shared<Environment>, compiler: &mut Compiler) -> Result<()>;
@@ -73,9 +81,9 @@ impl Tardi {
             }
         } else {
             log::trace!("Tardi::bootstrap internal modules");
-            self.execute_module_str(KERNEL, include_str!("../bootstrap/00-core-macros.tardi"))?;
-            self.execute_module_str(KERNEL, include_str!("../bootstrap/01-stack-ops.tardi"))?;
-            self.execute_module_str(KERNEL, include_str!("../bootstrap/02-core-ops.tardi"))?;
+            for source in BOOTSTRAP_SOURCES {
+                self.execute_module_str(KERNEL, source)?;
+            }
             let env = self.environment.borrow();
             let kernel = env.get_module(KERNEL).unwrap();
             log::trace!("Tardi::bootstrap {} {:?}", KERNEL, kernel);
@@ -84,8 +92,48 @@ impl Tardi {
         Ok(())
     }
 
+    /// Adds a word implemented by the program that's embedding Tardi. When
+    /// the word is called, `function` gets the VM, so it can pop its
+    /// arguments off the stack and push its results. If there are fewer
+    /// than `arity` items on the stack, the call fails with a stack
+    /// underflow before `function` runs.
+    ///
+    /// Primitives are defined in `std/kernel`, so they're available
+    /// everywhere without a `uses:`. Words defined in a module, or imported
+    /// into it explicitly, shadow them.
+    pub fn register_primitive(&mut self, name: &str, arity: usize, function: OpFn) {
+        self.environment
+            .borrow_mut()
+            .register_primitive(name, arity, function);
+    }
+
+    /// Sets how many values the data stack can hold. Pushing past this is a
+    /// stack overflow error.
+    pub fn set_max_stack(&mut self, max_stack: usize) {
+        self.executor.max_stack = max_stack;
+    }
+
+    /// Sets how deep the return stack can get, which is how deep calls can
+    /// nest. Going past this is a "call stack exhausted" error, which can be
+    /// caught like any other.
+    pub fn set_max_depth(&mut self, max_depth: usize) {
+        self.executor.max_depth = max_depth;
+    }
+
+    /// Sets the arguments that `argv` returns.
+    pub fn set_args(&mut self, args: Vec<String>) {
+        self.executor.args = args;
+    }
+
+    /// Sets a hook that's called before each instruction runs, like a
+    /// debugger.
+    pub fn set_hook(&mut self, hook: Box<dyn Hook>) {
+        self.executor.hook = Some(hook);
+    }
+
     pub fn reset(&mut self) {
         self.input = None;
+        self.executor.trace.clear();
     }
 
     pub fn compile_str(&mut self, module_name: &str, input: &str) -> Result<Shared<Environment>> {
@@ -132,10 +180,55 @@ impl Tardi {
         self.execute()
     }
 
+    /// Compiles a script and writes it to a cache, without running it.
+    pub fn compile_to_cache(&mut self, path: &Path, cache_file: &Path) -> Result<()> {
+        log::trace!("Tardi::compile_to_cache {:?} => {:?}", path, cache_file);
+        self.reset();
+        let start_ip = self.executor.ip;
+        self.compile_script(path)?;
+        cache::write_cache(&self.environment.borrow(), path, start_ip, cache_file)
+    }
+
+    /// Loads a compiled script from a cache so that `execute` runs it. This
+    /// replaces everything that's been compiled so far, so it doesn't need
+    /// bootstrapping first.
+    ///
+    /// This returns `false` if there isn't a cache or, with `check_sources`,
+    /// if it's stale.
+    pub fn load_cache(&mut self, cache_file: &Path, check_sources: bool) -> Result<bool> {
+        log::trace!("Tardi::load_cache {:?}", cache_file);
+        self.reset();
+        let start_ip = cache::read_cache(
+            &mut self.environment.borrow_mut(),
+            cache_file,
+            check_sources,
+        )?;
+        if let Some(start_ip) = start_ip {
+            self.executor.ip = start_ip;
+            self.compiler.set_environment(self.environment.clone());
+            Ok(true)
+        } else {
+            Ok(false)
+        }
+    }
+
     pub fn stack(&self) -> Vec<Value> {
         self.executor.stack()
     }
 
+    /// The call trace for the last uncaught error, innermost frame first.
+    pub fn trace(&self) -> &[TraceFrame] {
+        &self.executor.trace
+    }
+
+    /// The test cases and assertions outside of them that have run so far,
+    /// including a test case that hasn't finished.
+    pub fn test_results(&self) -> Vec<TestResult> {
+        let mut results = self.executor.test_results.clone();
+        results.extend(self.executor.current_test.clone());
+        results
+    }
+
     // allowing because this is used in tests
     // TODO: can i move this into the test module?
     #[allow(dead_code)]
@@ -163,8 +256,11 @@ impl Default for Tardi {
 impl From<&Config> for Tardi {
     fn from(config: &Config) -> Self {
         let environment = Environment::with_builtins(Some(config));
-        let compiler = Compiler::default();
-        let executor = VM::new();
+        let mut compiler = Compiler::default();
+        compiler.set_strict_effects(config.strict_effects);
+        let mut executor = VM::new();
+        executor.max_stack = config.max_stack;
+        executor.max_depth = config.max_depth;
         Tardi::assemble(environment, compiler, executor)
     }
 }
@@ -1,5 +1,7 @@
 use super::*;
 
+use crate::compiler::error::CompilerError;
+use crate::error::{Error, VMError};
 use crate::value::ValueData;
 
 use pretty_assertions::assert_eq;
@@ -168,3 +170,822 @@ fn test_if() {
     test_word("#t [ 13 ] [ 42 ] if", &[13i64]);
     test_word("#f [ 13 ] [ 42 ] if", &[42i64]);
 }
+
+#[test]
+fn test_call() {
+    test_word("2 [ 3 * ] call", &[6i64]);
+    test_word("[ [ 1 2 + ] call 3 * ] call", &[9i64]);
+}
+
+#[test]
+fn test_call_nested_quotation_left_on_stack() {
+    test_word("[ [ 4 ] ] call call 1 +", &[5i64]);
+    test_word("[ ] call 7", &[7i64]);
+}
+
+#[test]
+fn test_tail_call_runs_in_constant_return_stack() {
+    test_word(
+        ": countdown   dup 0 > [ 1 - countdown ] when ; 1000000 countdown",
+        &[0i64],
+    );
+}
+
+#[test]
+fn test_tail_call_mutual_recursion() {
+    test_word(
+        r#"
+        : pong   over 0 > [ [ 1 - ] dip apply ] [ drop ] if ;
+        : ping   [ ping ] pong ;
+        100001 ping
+        "#,
+        &[0i64],
+    );
+}
+
+#[test]
+fn test_forward_reference() {
+    test_word(": answer   half 2 * ; : half   21 ; answer", &[42i64]);
+}
+
+#[test]
+fn test_forward_reference_mutual_recursion() {
+    test_word(
+        r#"
+        : even?   dup 0 == [ drop #t ] [ 1 - odd? ] if ;
+        : odd?    dup 0 == [ drop #f ] [ 1 - even? ] if ;
+        100000 even? 7 even?
+        "#,
+        &[true, false],
+    );
+}
+
+#[test]
+fn test_forward_reference_undefined_word_error() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str(": main   1 helper ;\n: other   2 ;");
+    assert!(
+        matches!(
+            result,
+            Err(Error::CompilerError(CompilerError::UndefinedWord(ref message)))
+                if message.starts_with("helper at ") && message.ends_with(":1:12")
+        ),
+        "Expected UndefinedWord, got {:?}",
+        result
+    );
+}
+
+#[test]
+fn test_tail_call_at_end_of_loop_body() {
+    test_word(
+        ": inc   1 + ; 0 [ dup 5 == [ break ] when inc ] loop",
+        &[5i64],
+    );
+}
+
+#[test]
+fn test_max_depth_error_can_be_caught() {
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi.set_max_depth(100);
+    let result = tardi.execute_str(": forever   1 forever drop ; [ forever ] [ ] catch");
+    assert!(result.is_ok(), "Expected Ok, got {:?}", result);
+    let stack = tardi.stack();
+    assert_eq!(
+        stack.last().map(|v| v.data.clone()),
+        Some("Call stack exhausted: the limit is 100 frames".into())
+    );
+}
+
+#[test]
+fn test_max_stack_error() {
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi.set_max_stack(10);
+    let result = tardi.execute_str(": grow   1 grow ; grow");
+    assert!(
+        matches!(result, Err(Error::VMError(VMError::StackOverflow(10)))),
+        "Expected StackOverflow, got {:?}",
+        result
+    );
+}
+
+#[test]
+fn test_hashmap_unhashable_keys_error() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str("uses: std/hashmaps { 1 } 2 <map> set-at");
+    assert!(
+        matches!(result, Err(Error::VMError(VMError::UnfreezableValue(_)))),
+        "Expected UnfreezableValue, got {:?}",
+        result
+    );
+
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str("uses: std/hashmaps 1.5 <map> at");
+    assert!(
+        matches!(result, Err(Error::VMError(VMError::UnfreezableValue(_)))),
+        "Expected UnfreezableValue, got {:?}",
+        result
+    );
+}
+
+#[test]
+fn test_nth_out_of_bounds_error() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str("uses: std/vectors 5 { 10 20 30 } nth");
+    assert!(
+        matches!(
+            result,
+            Err(Error::VMError(VMError::IndexOutOfBounds(5, 3)))
+        ),
+        "Expected IndexOutOfBounds, got {:?}",
+        result
+    );
+    assert_eq!(
+        result.unwrap_err().to_string(),
+        "Index 5 out of bounds for length 3"
+    );
+
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str("uses: std/vectors 99 -1 { 10 20 30 } set-nth!");
+    assert!(
+        matches!(
+            result,
+            Err(Error::VMError(VMError::IndexOutOfBounds(-1, 3)))
+        ),
+        "Expected IndexOutOfBounds, got {:?}",
+        result
+    );
+}
+
+#[test]
+fn test_substring_out_of_bounds_error() {
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi
+        .execute_str(r#"uses: std/strings "héllo" 1 5 substring"#)
+        .unwrap();
+    assert_eq!(tardi.stack(), vec![Value::from("éllo")]);
+
+    for (script, index, length) in [
+        (r#""héllo" 0 6 substring"#, 6, 5),
+        (r#""héllo" 3 2 substring"#, 3, 2),
+        (r#""héllo" -1 2 substring"#, -1, 2),
+    ] {
+        let mut tardi = Tardi::new(None).unwrap();
+        let result = tardi.execute_str(&format!("uses: std/strings {}", script));
+        assert!(
+            matches!(
+                result,
+                Err(Error::VMError(VMError::IndexOutOfBounds(i, l))) if i == index && l == length
+            ),
+            "Expected IndexOutOfBounds for {}, got {:?}",
+            script,
+            result
+        );
+    }
+}
+
+#[test]
+fn test_catch_without_throw() {
+    test_word("[ 1 ] [ drop 2 ] catch", &[1i64]);
+}
+
+#[test]
+fn test_catch_restores_data_stack() {
+    test_word("10 [ 1 2 99 throw 3 ] [ 1 + ] catch", &[10i64, 100]);
+}
+
+#[test]
+fn test_catch_restores_return_stack() {
+    test_word(
+        r#"
+        : deep    5 >r 99 throw r> ;
+        : deeper  1 >r deep r> ;
+        [ deeper ] [ ] catch
+        "#,
+        &[99i64],
+    );
+}
+
+#[test]
+fn test_catch_nested_rethrow() {
+    test_word("[ [ 1 throw ] [ 1 + throw ] catch ] [ 10 * ] catch", &[20i64]);
+}
+
+#[test]
+fn test_ensure() {
+    test_word("1 [ 2 ] [ 3 ] ensure", &[1i64, 2, 3]);
+}
+
+#[test]
+fn test_ensure_cleans_up_after_throw() {
+    // The cleanup runs with the stacks unwound, and then the value is thrown
+    // again.
+    test_word(
+        r#"
+        uses: std/vectors
+        { } [ dup [ 5 >r 1 throw ] [ 2 swap push! ] ensure ] [ ] catch
+        swap first
+        "#,
+        &[1i64, 2],
+    );
+}
+
+#[test]
+fn test_ensure_cleanup_throws() {
+    // The inner cleanup's throw replaces the body's, and the outer cleanup
+    // still runs before it's caught.
+    test_word(
+        r#"
+        uses: std/vectors
+        { }
+        [
+            dup [ [ 1 throw ] [ 2 throw ] ensure ] [ 3 swap push! ] ensure
+        ] [ 10 * ] catch
+        swap first
+        "#,
+        &[20i64, 3],
+    );
+}
+
+#[test]
+fn test_break_out_of_catch() {
+    // The inner handler is dropped by `break`, so it doesn't catch the throw.
+    test_word(
+        "[ [ [ break ] [ drop ] catch ] loop 7 throw ] [ 1 + ] catch",
+        &[8i64],
+    );
+}
+
+#[test]
+fn test_record() {
+    test_word(
+        r#"
+        record: point x y ;
+        3 4 <point>
+        dup point-x swap point-y
+        "#,
+        &[3i64, 4],
+    );
+    test_word(
+        r#"
+        record: point x y ;
+        3 4 <point> 10 over set-point-x point-x
+        "#,
+        &[10i64],
+    );
+    test_word(
+        r#"
+        record: point x y ;
+        record: circle r ;
+        3 4 <point> point?
+        5 <circle> point?
+        7 point?
+        "#,
+        &[true, false, false],
+    );
+    test_word(
+        r#"
+        record: point x y ;
+        3 4 <point> 3 4 <point> ==
+        3 4 <point> 4 3 <point> ==
+        "#,
+        &[true, false],
+    );
+}
+
+#[test]
+fn test_record_wrong_type_error() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str("record: point x y ; record: circle r ; 5 <circle> point-x");
+    assert!(
+        matches!(
+            &result,
+            Err(Error::VMError(VMError::WrongRecordType(expected, actual)))
+                if expected == "point" && actual == "circle"
+        ),
+        "Expected WrongRecordType, got {:?}",
+        result
+    );
+    assert_eq!(
+        result.unwrap_err().to_string(),
+        "Expected point but got circle"
+    );
+}
+
+#[test]
+fn test_pprint_with_bad_options() {
+    for options in [
+        "H{ { :size 3 } }",
+        "H{ { :depth -1 } }",
+        "H{ { :width \"wide\" } }",
+        "{ 80 }",
+    ] {
+        let mut tardi = Tardi::new(None).unwrap();
+        let script = format!(
+            "uses: std/io uses: std/hashmaps {{ 1 2 }} {} pprint-with",
+            options
+        );
+        let result = tardi.execute_str(&script);
+        assert!(
+            matches!(result, Err(Error::VMError(VMError::TypeMismatch(_)))),
+            "Expected TypeMismatch for {}, got {:?}",
+            options,
+            result
+        );
+    }
+}
+
+#[test]
+fn test_catch_runtime_error() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str("[ 1 0 / ] [ ] catch 7");
+    assert!(result.is_ok(), "Expected Ok, got {:?}", result);
+    let stack = tardi
+        .stack()
+        .into_iter()
+        .map(|v| v.data)
+        .collect::<Vec<_>>();
+    assert_eq!(
+        stack,
+        vec![
+            ValueData::String("Division by zero".to_string()),
+            ValueData::Integer(7)
+        ]
+    );
+}
+
+#[test]
+fn test_uncaught_throw() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str("[ 1 ] [ ] catch\n  \"oops\" throw");
+    assert!(
+        matches!(
+            result,
+            Err(Error::VMError(VMError::UncaughtThrow(ref message)))
+                if message == "\"oops\" (line 2, column 3)"
+        ),
+        "Expected UncaughtThrow, got {:?}",
+        result
+    );
+}
+
+#[test]
+fn test_error_trace_walks_return_stack() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str(": inner   0 / ;\n: outer   1 inner 2 + ;\nouter");
+    assert!(result.is_err(), "Expected Err, got {:?}", result);
+    let trace = tardi
+        .trace()
+        .iter()
+        .map(|frame| {
+            let loc = frame.loc.as_ref().unwrap();
+            (frame.word.as_str(), loc.pos.line, loc.pos.column)
+        })
+        .collect::<Vec<_>>();
+    assert_eq!(
+        trace,
+        vec![
+            ("std/sandbox::inner", 1, 13),
+            ("std/sandbox::outer", 2, 13),
+            ("<top level>", 3, 1),
+        ]
+    );
+}
+
+#[test]
+fn test_error_trace_cleared_when_caught() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str("[ 1 0 / ] [ drop ] catch");
+    assert!(result.is_ok(), "Expected Ok, got {:?}", result);
+    assert!(tardi.trace().is_empty());
+}
+
+#[test]
+fn test_locals() {
+    test_word(
+        ":: sum-of-squares ( x y -- z ) x x * y y * + ; 3 4 sum-of-squares",
+        &[25i64],
+    );
+    test_word(":: sub3 ( a b c -- d ) a b - c - ; 10 3 2 sub3", &[5i64]);
+    test_word(":: swap-em ( a b -- b a ) b a ; 1 2 swap-em", &[2i64, 1]);
+}
+
+#[test]
+fn test_locals_store() {
+    test_word(":: bump ( n -- m ) n 1 + n! n n * ; 4 bump", &[25i64]);
+}
+
+#[test]
+fn test_locals_with_return_stack() {
+    test_word(
+        ":: shuffle ( a b -- c ) a >r b r> - b >r a r@ * r> drop + ; 3 5 shuffle",
+        &[17i64],
+    );
+}
+
+#[test]
+fn test_locals_balance_return_stack() {
+    test_word(
+        ":: add2 ( a b -- c ) a b + ; 0 [ dup 1000 < ] [ 1 add2 ] while",
+        &[1000i64],
+    );
+    test_word(":: tail ( a -- b ) a [ 1 + ] apply ; 1 tail", &[2i64]);
+}
+
+#[test]
+fn test_locals_dont_leak() {
+    test_word(": x 100 ; :: f ( x -- y ) x ; : g x ; 1 f g", &[1i64, 100]);
+}
+
+#[test]
+fn test_locals_in_quotation_error() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str(":: f ( x -- y ) #t [ x ] [ 0 ] if ;");
+    assert!(
+        matches!(
+            result,
+            Err(Error::CompilerError(CompilerError::LocalInQuotation(ref name))) if name == "x"
+        ),
+        "Expected LocalInQuotation, got {:?}",
+        result
+    );
+}
+
+#[test]
+fn test_vocab() {
+    test_word(
+        "vocab: geometry : square dup * ; ;vocab : square drop 0 ; uses: geometry 3 square 3 geometry/square",
+        &[0i64, 9],
+    );
+}
+
+#[test]
+fn test_vocab_exports() {
+    test_word(
+        "vocab: v exports: public ; : private 1 ; : public private 1 + ; ;vocab uses: v public",
+        &[2i64],
+    );
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi
+        .execute_str(
+            "vocab: v exports: public ; : private 1 ; : public private 1 + ; ;vocab uses: v",
+        )
+        .unwrap();
+    let env = tardi.environment.borrow();
+    let sandbox = env.get_module(SANDBOX).unwrap();
+    assert!(sandbox.get("public").is_some());
+    assert!(sandbox.get("private").is_none());
+    assert!(env.get_module("v").unwrap().get("private").is_some());
+}
+
+#[test]
+fn test_end_vocab_without_vocab_error() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str("1 ;vocab");
+    assert!(
+        matches!(
+            result,
+            Err(Error::CompilerError(CompilerError::InvalidState(_)))
+        ),
+        "Expected InvalidState, got {:?}",
+        result
+    );
+}
+
+/// s -- s!
+fn shout(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let value = vm.pop()?;
+    let message = format!("{}!", value.borrow());
+    vm.push(shared(message.into()))
+}
+
+#[test]
+fn test_register_primitive() {
+    let mut tardi = Tardi::new(None).unwrap();
+    // The sandbox exists before the primitive does.
+    tardi.execute_str("1 drop").unwrap();
+    tardi.register_primitive("shout", 1, shout);
+    let result = tardi.execute_str("\"hey\" shout : twice shout shout ; \"ho\" twice");
+    assert!(result.is_ok(), "Expected Ok, got {:?}", result);
+    assert_eq!(
+        tardi.stack(),
+        vec![
+            ValueData::from("hey!").into(),
+            ValueData::from("ho!!").into()
+        ]
+    );
+}
+
+#[test]
+fn test_register_primitive_arity() {
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi.register_primitive("shout", 1, shout);
+    let result = tardi.execute_str("shout");
+    assert!(
+        matches!(result, Err(Error::VMError(VMError::StackUnderflow))),
+        "Expected StackUnderflow, got {:?}",
+        result
+    );
+}
+
+#[test]
+fn test_register_primitive_shadowed() {
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi.register_primitive("shout", 1, shout);
+    let result = tardi.execute_str(": shout drop \"quiet\" ; \"hey\" shout");
+    assert!(result.is_ok(), "Expected Ok, got {:?}", result);
+    assert_eq!(tardi.stack(), vec![ValueData::from("quiet").into()]);
+}
+
+#[test]
+fn test_test_results() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str(
+        "uses: std/testing
+        test: passes   1 2 + 3 assert= ;
+        test: fails   1 2 + 4 assert= [ 1 0 / ] assert-error ;
+        test: throws   \"boom\" throw ;
+        #f assert",
+    );
+    assert!(result.is_ok(), "Expected Ok, got {:?}", result);
+
+    let results = tardi.test_results();
+    let names = results
+        .iter()
+        .map(|result| result.name.as_deref())
+        .collect::<Vec<_>>();
+    assert_eq!(
+        names,
+        vec![Some("passes"), Some("fails"), Some("throws"), None]
+    );
+    let messages = results
+        .iter()
+        .map(|result| {
+            result
+                .failures
+                .iter()
+                .map(|failure| failure.message.as_str())
+                .collect::<Vec<_>>()
+        })
+        .collect::<Vec<_>>();
+    assert_eq!(
+        messages,
+        vec![
+            vec![],
+            vec!["expected 4, but got 3"],
+            vec!["unexpected error: boom"],
+            vec!["assertion failed"],
+        ]
+    );
+    assert!(results[1].failures[0].loc.is_some());
+}
+
+#[test]
+fn test_equal() {
+    let cases = [
+        ("1 1 equal?", true),
+        ("1 1.0 equal?", true),
+        ("1.5 1 equal?", false),
+        ("#t #t equal?", true),
+        ("#t #f equal?", false),
+        ("'a' 'a' equal?", true),
+        ("\"a\" \"a\" equal?", true),
+        ("\"a\" \"b\" equal?", false),
+        ("'a' \"a\" equal?", false),
+        ("1 \"1\" equal?", false),
+        ("1 #t equal?", false),
+        ("{ } { } equal?", true),
+        ("{ 1 { 2 3 } } { 1 { 2 3 } } equal?", true),
+        ("{ 1 { 2 3 } } { 1 { 2 4 } } equal?", false),
+        ("{ 1 2 } { 1 2 3 } equal?", false),
+        ("{ 1 2 } { 1.0 2.0 } equal?", true),
+        (
+            "uses: std/hashmaps H{ { 1 2 } { 3 4 } } H{ { 3 4 } { 1 2 } } equal?",
+            true,
+        ),
+        (
+            "uses: std/hashmaps H{ { 1 2 } { 3 4 } } H{ { 1 2 } { 3 5 } } equal?",
+            false,
+        ),
+        (
+            "uses: std/hashmaps H{ { 1 2 } } H{ { 1 2 } { 3 4 } } equal?",
+            false,
+        ),
+        ("[ 1 ] dup equal?", true),
+        ("[ 1 ] [ 1 ] equal?", false),
+    ];
+    for (script, expected) in cases {
+        test_word(script, &[expected]);
+    }
+}
+
+#[test]
+fn test_compare() {
+    let cases = [
+        ("1 2 compare", -1i64),
+        ("2 2 compare", 0),
+        ("3 2 compare", 1),
+        ("1 1.5 compare", -1),
+        ("2.0 2 compare", 0),
+        ("2.5 2 compare", 1),
+        ("\"apple\" \"banana\" compare", -1),
+        ("\"b\" \"a\" compare", 1),
+        ("\"ab\" \"a\" compare", 1),
+        ("'a' 'b' compare", -1),
+        ("'z' 'z' compare", 0),
+        ("#f #t compare", -1),
+        ("#t #t compare", 0),
+        ("{ 1 2 } { 1 2 } compare", 0),
+        ("{ 1 2 3 } { 2 } compare", -1),
+        ("{ 1 2 } { 1 2 3 } compare", -1),
+        ("{ 1 3 } { 1 2 3 } compare", 1),
+        ("{ } { 1 } compare", -1),
+    ];
+    for (script, expected) in cases {
+        test_word(script, &[expected]);
+    }
+}
+
+#[test]
+fn test_compare_incomparable_error() {
+    let cases = [
+        "1 \"1\" compare",
+        "'a' \"a\" compare",
+        "#t 1 compare",
+        "{ 1 } 1 compare",
+        "{ 1 } { \"a\" } compare",
+        "[ 1 ] [ 1 ] compare",
+        "uses: std/hashmaps H{ } H{ } compare",
+    ];
+    for script in cases {
+        let mut tardi = Tardi::new(None).unwrap();
+        let result = tardi.execute_str(script);
+        assert!(
+            matches!(result, Err(Error::VMError(VMError::NotComparable(_, _)))),
+            "Expected NotComparable for {:?}, got {:?}",
+            script,
+            result
+        );
+    }
+
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str("1 \"a\" compare");
+    assert_eq!(
+        result.unwrap_err().to_string(),
+        "Cannot compare integer 1 and string \"a\""
+    );
+}
+
+#[test]
+fn test_compare_functions() {
+    // Named functions can't be ordered by their names, either.
+    fn nop(_vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+        Ok(())
+    }
+    let a = ValueData::Function(Lambda::new_builtin("a", nop));
+    let b = ValueData::Function(Lambda::new_builtin("b", nop));
+    assert_eq!(a.partial_cmp(&b), None);
+}
+
+#[test]
+fn test_vector_ordering() {
+    // `<`, `>`, and `sort!` order vectors by their items before their
+    // lengths, the same as `compare`.
+    test_word("{ 1 2 3 } { 2 } <", &[true]);
+    test_word("{ 2 } { 1 2 3 } >", &[true]);
+    test_word("{ 1 2 } { 1 2 3 } <", &[true]);
+    test_word(
+        r#"
+        uses: std/vectors
+        { { 2 } { 1 2 3 } { 1 2 } } sort!
+        dup first length swap 2 swap nth length
+        "#,
+        &[2i64, 1],
+    );
+}
+
+#[test]
+fn test_symbols_are_interned() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str(
+        "uses: std/strings
+        : color   :red ;
+        :red color \"red\" string>symbol :blue",
+    );
+    assert!(result.is_ok(), "Expected Ok, got {:?}", result);
+
+    let stack = tardi.stack();
+    let symbols = stack
+        .iter()
+        .map(|v| v.data.as_keyword().unwrap())
+        .collect::<Vec<_>>();
+    assert!(symbols[0].ptr_eq(symbols[1]));
+    assert!(symbols[0].ptr_eq(symbols[2]));
+    assert_eq!(symbols[3].name(), "blue");
+    assert!(!symbols[0].ptr_eq(symbols[3]));
+
+    test_word(":red :red ==", &[true]);
+    test_word(":red :blue ==", &[false]);
+    test_word(":red \"red\" ==", &[false]);
+    test_word("uses: std/strings :red symbol>string \"red\" ==", &[true]);
+    test_word(":a :b compare", &[-1i64]);
+}
+
+#[test]
+fn test_times() {
+    test_word("0 5 [ 1 + ] times", &[5i64]);
+    test_word("0 0 [ 1 + ] times", &[0i64]);
+    test_word("0 -3 [ 1 + ] times", &[0i64]);
+    test_word("1 2 3 2 [ + ] times", &[6i64]);
+}
+
+#[test]
+fn test_each_integer() {
+    test_word("0 5 [ + ] each-integer", &[10i64]);
+    test_word("5 [ ] each-integer", &[0i64, 1, 2, 3, 4]);
+    test_word("100 0 [ drop 1 ] each-integer", &[100i64]);
+}
+
+#[test]
+fn test_until() {
+    test_word("0 [ dup 3 > ] [ 1 + ] until", &[4i64]);
+    test_word("10 [ dup 3 > ] [ 1 + ] until", &[10i64]);
+}
+
+#[test]
+fn test_loop_combinators_run_in_constant_return_stack() {
+    test_word("0 10000 [ 1 + ] times", &[10000i64]);
+    test_word("0 10000 [ + ] each-integer", &[49995000i64]);
+    test_word("0 [ dup 10000 == ] [ 1 + ] until", &[10000i64]);
+}
+
+#[test]
+fn test_disassemble_is_relocatable() {
+    let listing = |script: &str| {
+        let mut tardi = Tardi::new(None).unwrap();
+        tardi.execute_str(script).unwrap();
+        let env = tardi.environment.borrow();
+        let index = env.get_op_index(SANDBOX, "countdown").unwrap();
+        let name = env.word_name(index);
+        let lambda = env.op_table[index].borrow();
+        env.disassemble(name.as_deref(), &lambda)
+    };
+    let word = ": countdown ( n -- ) dup 0 > [ 1 - countdown ] [ drop ] if ;";
+
+    // Both are on the second line, so they have the same positions.
+    let first = listing(&format!("\n{}", word));
+    assert!(first.starts_with(": std/sandbox::countdown\n"), "{}", first);
+    assert!(first.contains("TailCall   if"), "{}", first);
+    assert!(first.contains("\n[ 1 - countdown ]\n"), "{}", first);
+    assert!(first.contains("TailCall   countdown"), "{}", first);
+    assert_eq!(
+        first,
+        listing(&format!("1 2 + drop : other ( -- ) ;\n{}", word))
+    );
+}
+
+#[test]
+fn test_see_errors() {
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str("uses: std/io :no-such-word see");
+    assert!(
+        matches!(result, Err(Error::VMError(VMError::UnknownWord(_)))),
+        "Expected UnknownWord, got {:?}",
+        result
+    );
+
+    let mut tardi = Tardi::new(None).unwrap();
+    let result = tardi.execute_str("uses: std/io 42 see");
+    assert!(
+        matches!(result, Err(Error::VMError(VMError::TypeMismatch(_)))),
+        "Expected TypeMismatch, got {:?}",
+        result
+    );
+}
+
+#[test]
+fn test_hashmap_insertion_order() {
+    test_word(
+        "uses: std/hashmaps uses: std/strings \
+         H{ { 3 :c } { 1 :a } { 2 :b } } >string \
+         \"H{ { 3 :c } { 1 :a } { 2 :b } }\" ==",
+        &[true],
+    );
+    test_word(
+        "uses: std/hashmaps \
+         H{ { 'b' 2 } { 'a' 1 } } 'c' 3 pick set! 'b' 9 pick set! \
+         keys { 'b' 'a' 'c' } ==",
+        &[true],
+    );
+    test_word(
+        "uses: std/hashmaps \
+         H{ { 1 1 } { 2 2 } { 3 3 } } 1 over remove! 1 1 pick set! \
+         keys { 2 3 1 } ==",
+        &[true],
+    );
+    test_word(
+        "uses: std/hashmaps uses: std/vectors \
+         { } H{ { 'z' 1 } { 'y' 2 } { 'x' 3 } } [ drop over push! ] hashmaps/each \
+         { 'z' 'y' 'x' } ==",
+        &[true],
+    );
+}
@@ -0,0 +1,160 @@
+//! A step debugger for the VM.
+//!
+//! The `Debugger` is a `Hook`, so the VM calls it before each instruction.
+//! When it pauses, it prints the word that's about to run, where it's from,
+//! and the top of the stack, then it reads commands until one moves on:
+//!
+//! * `s` or `step` (or an empty line) runs the next instruction;
+//! * `c` or `continue` runs until the next breakpoint;
+//! * `p` or `stack` prints the whole stack;
+//! * `q` or `quit` stops the program.
+//!
+//! A breakpoint is a `breakpoint` word in the source, or a word whose calls
+//! it pauses before, like `tardi debug --break square`.
+
+use std::collections::HashSet;
+use std::io::{BufRead, Write};
+
+use crate::error::{Result, VMError};
+use crate::value::SharedValue;
+use crate::vm::{Hook, VM};
+
+const PROMPT: &str = "(debug) ";
+
+/// How many values from the top of the stack are shown when it pauses.
+const STACK_PREVIEW: usize = 5;
+
+const HELP: &str = "commands:
+    s, step       run the next instruction
+    c, continue   run until the next breakpoint
+    p, stack      show the whole stack
+    q, quit       stop the program";
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Mode {
+    Step,
+    Continue,
+}
+
+/// A debugger that reads its commands from `input` and writes to `output`.
+pub struct Debugger<R, W> {
+    input: R,
+    output: W,
+    mode: Mode,
+    breakpoints: HashSet<String>,
+}
+
+impl<R: BufRead, W: Write> Debugger<R, W> {
+    /// Creates a debugger that pauses before calls to the words in
+    /// `breakpoints`. Without any, it pauses before the first instruction.
+    pub fn new<I>(input: R, output: W, breakpoints: I) -> Self
+    where
+        I: IntoIterator<Item = String>,
+    {
+        let breakpoints: HashSet<String> = breakpoints.into_iter().collect();
+        let mode = if breakpoints.is_empty() {
+            Mode::Step
+        } else {
+            Mode::Continue
+        };
+        Debugger {
+            input,
+            output,
+            mode,
+            breakpoints,
+        }
+    }
+
+    fn should_pause(&self, vm: &VM, ip: usize) -> bool {
+        match self.mode {
+            Mode::Step => true,
+            Mode::Continue => {
+                vm.break_requested
+                    || vm
+                        .environment
+                        .as_ref()
+                        .and_then(|env| env.borrow().get_called_word(ip))
+                        .is_some_and(|word| self.breakpoints.contains(&word))
+            }
+        }
+    }
+
+    fn show_instruction(&mut self, vm: &VM, ip: usize) -> Result<()> {
+        let word = vm
+            .environment
+            .as_ref()
+            .and_then(|env| env.borrow().describe_instruction(ip))
+            .unwrap_or_else(|| "?".to_string());
+        let frame = vm.build_trace(ip, vm.return_stack.len()).into_iter().next();
+        match frame {
+            Some(frame) => writeln!(self.output, "-> {}    {}", word, frame)?,
+            None => writeln!(self.output, "-> {}", word)?,
+        }
+        writeln!(self.output, "   {}", format_stack(&vm.stack, STACK_PREVIEW))?;
+        Ok(())
+    }
+
+    /// Reads commands until one of them runs something.
+    fn prompt(&mut self, vm: &VM) -> Result<()> {
+        loop {
+            write!(self.output, "{}", PROMPT)?;
+            self.output.flush()?;
+
+            let mut line = String::new();
+            if self.input.read_line(&mut line)? == 0 {
+                writeln!(self.output)?;
+                return Err(VMError::Bye.into());
+            }
+
+            match line.trim() {
+                "" | "s" | "step" => {
+                    self.mode = Mode::Step;
+                    return Ok(());
+                }
+                "c" | "continue" => {
+                    self.mode = Mode::Continue;
+                    return Ok(());
+                }
+                "p" | "stack" => {
+                    writeln!(
+                        self.output,
+                        "   {}",
+                        format_stack(&vm.stack, vm.stack.len())
+                    )?;
+                }
+                "q" | "quit" => return Err(VMError::Bye.into()),
+                _ => writeln!(self.output, "{}", HELP)?,
+            }
+        }
+    }
+}
+
+impl<R: BufRead, W: Write> Hook for Debugger<R, W> {
+    fn before_instruction(&mut self, vm: &mut VM, ip: usize) -> Result<()> {
+        if !self.should_pause(vm, ip) {
+            return Ok(());
+        }
+        vm.break_requested = false;
+        self.show_instruction(vm, ip)?;
+        self.prompt(vm)
+    }
+}
+
+/// Formats the top `count` values on the stack like a stack effect, with
+/// `...` if there are more under them.
+fn format_stack(stack: &[SharedValue], count: usize) -> String {
+    let start = stack.len().saturating_sub(count);
+    let mut output = String::from("(");
+    if start > 0 {
+        output.push_str(" ...");
+    }
+    for value in &stack[start..] {
+        output.push(' ');
+        output.push_str(&value.borrow().to_repr());
+    }
+    output.push_str(" -- )");
+    output
+}
+
+#[cfg(test)]
+mod tests;
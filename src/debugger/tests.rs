@@ -0,0 +1,102 @@
+use std::io::{self, Cursor};
+
+use pretty_assertions::assert_eq;
+
+use super::*;
+use crate::core::Tardi;
+use crate::error::Error;
+use crate::module::internal::sandbox::SANDBOX;
+use crate::shared::{shared, Shared};
+use crate::value::ValueData;
+
+/// Collects what the debugger writes, so it can be read after it's been
+/// handed to the VM.
+#[derive(Clone, Default)]
+struct Output(Shared<Vec<u8>>);
+
+impl Write for Output {
+    fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        self.0.borrow_mut().extend_from_slice(buf);
+        Ok(buf.len())
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        Ok(())
+    }
+}
+
+impl Output {
+    fn pauses(&self) -> Vec<String> {
+        String::from_utf8(self.0.borrow().clone())
+            .unwrap()
+            .lines()
+            .filter_map(|line| {
+                line.strip_prefix("(debug) -> ")
+                    .or(line.strip_prefix("-> "))
+            })
+            .map(|line| line.split_whitespace().next().unwrap().to_string())
+            .collect()
+    }
+}
+
+fn debug(script: &str, commands: &str, breakpoints: &[&str]) -> (Result<()>, Output, Tardi) {
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi.compile_str(SANDBOX, script).unwrap();
+    let output = Output(shared(Vec::new()));
+    let debugger = Debugger::new(
+        Cursor::new(commands.to_string()),
+        output.clone(),
+        breakpoints.iter().map(|word| word.to_string()),
+    );
+    tardi.set_hook(Box::new(debugger));
+    let result = tardi.execute();
+    (result, output, tardi)
+}
+
+#[test]
+fn test_debugger_steps() {
+    let (result, output, tardi) = debug("1 2 +", "s\ns\n\n", &[]);
+    assert!(result.is_ok(), "Expected Ok, got {:?}", result);
+    assert_eq!(output.pauses(), vec!["1", "2", "+"]);
+    assert_eq!(tardi.stack(), vec![ValueData::Integer(3).into()]);
+}
+
+#[test]
+fn test_debugger_word_breakpoints() {
+    let (result, output, tardi) = debug(
+        ": square ( n -- n^2 ) dup * ; 3 square 4 square +",
+        "c\nc\n",
+        &["square"],
+    );
+    assert!(result.is_ok(), "Expected Ok, got {:?}", result);
+    assert_eq!(output.pauses(), vec!["square", "square"]);
+    assert_eq!(tardi.stack(), vec![ValueData::Integer(25).into()]);
+}
+
+#[test]
+fn test_debugger_breakpoint_word() {
+    let (result, output, _) = debug("1 breakpoint 2 breakpoint 3", "c\nc\n", &["nothing"]);
+    assert!(result.is_ok(), "Expected Ok, got {:?}", result);
+    assert_eq!(output.pauses(), vec!["2", "3"]);
+}
+
+#[test]
+fn test_debugger_quit() {
+    let (result, output, tardi) = debug("1 2 3", "s\nq\n", &[]);
+    assert!(
+        matches!(result, Err(Error::VMError(VMError::Bye))),
+        "Expected Bye, got {:?}",
+        result
+    );
+    assert_eq!(output.pauses(), vec!["1", "2"]);
+    assert_eq!(tardi.stack(), vec![ValueData::Integer(1).into()]);
+}
+
+#[test]
+fn test_format_stack() {
+    let stack = (1..=7)
+        .map(|i| shared(ValueData::Integer(i).into()))
+        .collect::<Vec<_>>();
+    assert_eq!(format_stack(&stack, 3), "( ... 5 6 7 -- )");
+    assert_eq!(format_stack(&stack[..2], 3), "( 1 2 -- )");
+}
@@ -1,10 +1,11 @@
 use crate::compiler::error::{CompilerError, CompilerResult};
 use crate::config::Config;
 use crate::error::{Result, VMError, VMResult};
+use crate::module::internal::kernel::KERNEL;
 use crate::module::{Module, ModuleManager};
 use crate::shared::{shared, Shared};
-use crate::value::lambda::Lambda;
-use crate::value::Value;
+use crate::value::lambda::{Lambda, OpFn};
+use crate::value::{Keyword, Pos, Value, ValueData};
 use crate::vm::OpCode;
 use crate::Scanner;
 use std::collections::HashMap;
@@ -34,6 +35,27 @@ pub struct Environment {
     pub op_table: Vec<Shared<Lambda>>,
 
     pub module_manager: ModuleManager,
+
+    /// Where in the source each instruction was compiled from, keyed by
+    /// instruction pointer.
+    pub positions: HashMap<usize, SourceLoc>,
+
+    /// The interned symbols, keyed by name, so each `:name` is the same
+    /// value everywhere it's created.
+    pub symbols: HashMap<String, Keyword>,
+}
+
+/// A location in a source file or module.
+#[derive(Debug, Clone, PartialEq)]
+pub struct SourceLoc {
+    pub source: String,
+    pub pos: Pos,
+}
+
+impl fmt::Display for SourceLoc {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}:{}:{}", self.source, self.pos.line, self.pos.column)
+    }
 }
 
 pub struct EnvLoc {
@@ -76,6 +98,8 @@ impl Environment {
             instructions,
             op_table,
             module_manager,
+            positions: HashMap::new(),
+            symbols: HashMap::new(),
         }
     }
 
@@ -127,6 +151,14 @@ impl Environment {
         self.constants.get(index)
     }
 
+    /// Returns the symbol for `name`, creating it the first time.
+    pub fn intern(&mut self, name: &str) -> Keyword {
+        self.symbols
+            .entry(name.to_string())
+            .or_insert_with(|| Keyword::new(name))
+            .clone()
+    }
+
     pub fn set_op_table(&mut self, op_table: Vec<Shared<Lambda>>) {
         self.op_table = op_table;
     }
@@ -160,6 +192,31 @@ impl Environment {
         Ok(())
     }
 
+    /// Adds a word that the host program implements. It's defined in the
+    /// kernel, like the other built-ins, so every module can use it, and
+    /// it's also imported into the modules that already exist. A module that
+    /// defines a word with the same name shadows it.
+    pub fn register_primitive(&mut self, name: &str, arity: usize, function: OpFn) {
+        log::trace!("Environment::register_primitive {} ({})", name, arity);
+        let index = self.op_table.len();
+        self.op_table
+            .push(shared(Lambda::new_primitive(name, arity, function)));
+
+        let previous = self
+            .module_manager
+            .get_module_mut(KERNEL)
+            .and_then(|kernel| kernel.defined.insert(name.to_string(), index));
+        for module in self.module_manager.modules.values_mut() {
+            if module.name == KERNEL {
+                continue;
+            }
+            let imported = module.imported.get(name).copied();
+            if imported.is_none() || imported == previous {
+                module.imported.insert(name.to_string(), index);
+            }
+        }
+    }
+
     pub fn set_imported(&mut self, module_name: &str, imported: HashMap<String, usize>) {
         if let Some(module) = self.module_manager.get_module_mut(module_name) {
             module.imported = imported;
@@ -187,6 +244,66 @@ impl Environment {
         None
     }
 
+    /// The name of the word that the instruction at `ip` calls. Opcodes are
+    /// named by the kernel words they implement.
+    pub fn get_called_word(&self, ip: usize) -> Option<String> {
+        let instruction = *self.instructions.get(ip)?;
+        let index = match OpCode::try_from(instruction) {
+            Ok(OpCode::Lit) => return None,
+            Ok(OpCode::TailCall) => *self.instructions.get(ip + 1)?,
+            _ => instruction,
+        };
+        self.op_table.get(index)?.borrow().name.clone()
+    }
+
+    /// A short description of the instruction at `ip`: the word it calls,
+    /// or the value it pushes.
+    pub fn describe_instruction(&self, ip: usize) -> Option<String> {
+        let instruction = *self.instructions.get(ip)?;
+        if let Ok(OpCode::Lit) = OpCode::try_from(instruction) {
+            let index = *self.instructions.get(ip + 1)?;
+            return self.constants.get(index).map(Value::to_repr);
+        }
+        self.get_called_word(ip)
+    }
+
+    /// The name of the compiled word whose body contains `ip`.
+    pub fn get_containing_op_name(&self, ip: usize) -> Option<String> {
+        for module in self.module_manager.iter_modules() {
+            for (word, index) in module.defined.iter() {
+                let span = self.op_table.get(*index).and_then(|l| {
+                    let lambda = l.borrow();
+                    Some((lambda.get_ip()?, lambda.get_length()?))
+                });
+                if let Some((start, length)) = span {
+                    if start <= ip && ip < start + length {
+                        return Some(format!("{}::{}", module.name, word));
+                    }
+                }
+            }
+        }
+        None
+    }
+
+    /// Whether `ip` is in the body of an anonymous lambda.
+    pub fn is_in_lambda(&self, ip: usize) -> bool {
+        self.constants.iter().any(|value| match value.data {
+            ValueData::Function(ref lambda) => match (lambda.get_ip(), lambda.get_length()) {
+                (Some(start), Some(length)) => start <= ip && ip < start + length,
+                _ => false,
+            },
+            _ => false,
+        })
+    }
+
+    pub fn add_position(&mut self, ip: usize, loc: SourceLoc) {
+        self.positions.insert(ip, loc);
+    }
+
+    pub fn get_position(&self, ip: usize) -> Option<&SourceLoc> {
+        self.positions.get(&ip)
+    }
+
     pub fn get_op_index(&self, module: &str, word: &str) -> Option<usize> {
         self.module_manager.get_op_index(module, word)
     }
@@ -227,11 +344,11 @@ impl Environment {
     }
 
     pub fn get_scanner_module(&self, scanner: &Scanner) -> Option<&Module> {
-        self.module_manager.get(&scanner.source.get_key())
+        self.module_manager.get(&scanner.module_key())
     }
 
     pub fn get_scanner_module_mut(&mut self, scanner: &Scanner) -> Option<&mut Module> {
-        self.module_manager.get_mut(&scanner.source.get_key())
+        self.module_manager.get_mut(&scanner.module_key())
     }
 
     pub fn add_module(&mut self, module: Module) {
@@ -412,6 +529,7 @@ impl Environment {
             | OpCode::RFrom
             | OpCode::RFetch
             | OpCode::Apply
+            | OpCode::TailApply
             | OpCode::Return
             | OpCode::Stop
             | OpCode::Bye
@@ -419,7 +537,11 @@ impl Environment {
             | OpCode::LitStack
             | OpCode::Compile
             | OpCode::Continue => self.debug_simple(op, f, ip),
-            OpCode::Jump | OpCode::Break => self.debug_jump(op, f, ip),
+            OpCode::Jump
+            | OpCode::Break
+            | OpCode::TailCall
+            | OpCode::LoadLocal
+            | OpCode::StoreLocal => self.debug_jump(op, f, ip),
         }?;
 
         self.write_function_names(f, ip)?;
@@ -551,5 +673,7 @@ impl fmt::Debug for Environment {
 //     }
 // }
 
+mod disasm;
+
 #[cfg(test)]
 mod tests;
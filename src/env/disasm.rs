@@ -0,0 +1,187 @@
+//! Listings of the bytecode that words compile to.
+//!
+//! Instructions are numbered from the start of the word they're in, and
+//! jumps inside it point to those numbers, so a word's listing doesn't
+//! change when something's compiled before it. Quotations are listed after
+//! the word that pushes them.
+
+use std::convert::TryFrom;
+use std::fmt::Write;
+
+use super::Environment;
+use crate::value::lambda::{Callable, Lambda};
+use crate::value::ValueData;
+use crate::vm::OpCode;
+
+impl Environment {
+    /// The modules that define a word named `name`, with its index in the
+    /// op table. `name` can be qualified, like `std/_io::println`.
+    pub fn find_word(&self, name: &str) -> Vec<(String, usize)> {
+        if let Some((module, word)) = name.rsplit_once("::") {
+            return self
+                .get_op_index(module, word)
+                .map(|index| (module.to_string(), index))
+                .into_iter()
+                .collect();
+        }
+
+        let mut found: Vec<(String, usize)> = self
+            .module_manager
+            .iter_modules()
+            .filter_map(|module| {
+                let index = module.defined.get(name)?;
+                Some((module.name.clone(), *index))
+            })
+            .collect();
+        found.sort();
+        found
+    }
+
+    /// The qualified name of the word at `index` in the op table.
+    pub fn word_name(&self, index: usize) -> Option<String> {
+        let mut names: Vec<String> = self
+            .module_manager
+            .iter_modules()
+            .flat_map(|module| {
+                module
+                    .defined
+                    .iter()
+                    .filter(|(_, i)| **i == index)
+                    .map(move |(word, _)| format!("{}::{}", module.name, word))
+            })
+            .collect();
+        names.sort();
+        names.into_iter().next()
+    }
+
+    /// If `quotation` is just a call to one word, like `[ square ]`, this
+    /// is the word's index in the op table.
+    pub fn quoted_word(&self, quotation: &Lambda) -> Option<usize> {
+        match &quotation.callable {
+            Callable::Compiled { words, ip, .. } if words.len() == 1 => {
+                let instruction = *self.instructions.get(*ip)?;
+                match OpCode::try_from(instruction) {
+                    Ok(OpCode::TailCall) => self.instructions.get(ip + 1).copied(),
+                    Ok(OpCode::Lit) => None,
+                    // Opcodes are also the kernel words' indices.
+                    _ => Some(instruction),
+                }
+            }
+            _ => None,
+        }
+    }
+
+    /// Lists the instructions of `lambda`, followed by the quotations that
+    /// it pushes. `name` is the qualified name of the word, if it's not
+    /// a quotation.
+    pub fn disassemble(&self, name: Option<&str>, lambda: &Lambda) -> String {
+        let mut output = String::new();
+        let header = name.map_or_else(|| quote(lambda), |name| format!(": {}", name));
+        let (ip, length) = match (lambda.get_ip(), lambda.get_length()) {
+            (Some(ip), Some(length)) => (ip, length),
+            _ => {
+                writeln!(output, "{} is built in", name.unwrap_or("fn")).unwrap();
+                return output;
+            }
+        };
+
+        writeln!(output, "{}", header).unwrap();
+        let mut quotations = self.disassemble_body(ip, length, &mut output);
+
+        let mut listed = vec![ip];
+        while !quotations.is_empty() {
+            let quotation = quotations.remove(0);
+            let (ip, length) = match (quotation.get_ip(), quotation.get_length()) {
+                (Some(ip), Some(length)) if !listed.contains(&ip) => (ip, length),
+                _ => continue,
+            };
+            listed.push(ip);
+            writeln!(output, "{}", quote(&quotation)).unwrap();
+            quotations.extend(self.disassemble_body(ip, length, &mut output));
+        }
+
+        output
+    }
+
+    /// Writes the instructions from `start` to `start + length` to
+    /// `output`, and returns the quotations they push.
+    fn disassemble_body(&self, start: usize, length: usize, output: &mut String) -> Vec<Lambda> {
+        let end = (start + length).min(self.instructions.len());
+        let offset = |target: usize| {
+            if start <= target && target < start + length {
+                format!("+{:04}", target - start)
+            } else {
+                format!("@{:04}", target)
+            }
+        };
+
+        let mut quotations = Vec::new();
+        let mut ip = start;
+        while ip < end {
+            let instruction = self.instructions[ip];
+            let operand = self.instructions.get(ip + 1).copied();
+            let (op, argument, width) = match OpCode::try_from(instruction) {
+                Ok(OpCode::Lit) => {
+                    let argument = match operand.and_then(|index| self.constants.get(index)) {
+                        Some(value) => match &value.data {
+                            ValueData::Function(lambda) => {
+                                quotations.push(lambda.clone());
+                                quote(lambda)
+                            }
+                            _ => value.to_repr(),
+                        },
+                        None => "?".to_string(),
+                    };
+                    ("Lit".to_string(), argument, 2)
+                }
+                Ok(op @ (OpCode::Jump | OpCode::Break)) => {
+                    let argument = operand.map_or_else(|| "?".to_string(), offset);
+                    (format!("{:?}", op), argument, 2)
+                }
+                Ok(OpCode::TailCall) => {
+                    let argument = operand
+                        .and_then(|index| self.op_name(index))
+                        .unwrap_or_else(|| "?".to_string());
+                    ("TailCall".to_string(), argument, 2)
+                }
+                Ok(op @ (OpCode::LoadLocal | OpCode::StoreLocal)) => {
+                    let argument = operand.map_or_else(|| "?".to_string(), |i| i.to_string());
+                    (format!("{:?}", op), argument, 2)
+                }
+                Ok(op) => (format!("{:?}", op), String::new(), 1),
+                Err(_) => {
+                    let name = self.op_name(instruction).unwrap_or_else(|| "?".to_string());
+                    ("Call".to_string(), name, 1)
+                }
+            };
+
+            let line = format!("    +{:04}  {:<10} {}", ip - start, op, argument);
+            let line = line.trim_end();
+            match self.get_position(ip) {
+                Some(loc) => writeln!(output, "{:<40} // {}", line, loc).unwrap(),
+                None => writeln!(output, "{}", line).unwrap(),
+            }
+            ip += width;
+        }
+
+        quotations
+    }
+
+    fn op_name(&self, index: usize) -> Option<String> {
+        let op = self.op_table.get(index)?;
+        let op = op.borrow();
+        Some(op.name.clone().unwrap_or_else(|| quote(&op)))
+    }
+}
+
+/// A quotation's words, without its address, which changes as code is
+/// compiled before it.
+fn quote(lambda: &Lambda) -> String {
+    match &lambda.callable {
+        Callable::Compiled { words, .. } if words.is_empty() => "[ ]".to_string(),
+        Callable::Compiled { words, .. } => {
+            format!("[ {} ]", words.join(" "))
+        }
+        _ => lambda.to_string(),
+    }
+}
@@ -9,7 +9,7 @@ use rustyline::error::ReadlineError;
 
 use crate::compiler::error::CompilerError;
 use crate::scanner::error::ScannerError;
-use crate::value::ValueData;
+use crate::value::{Value, ValueData};
 
 pub type Result<R> = result::Result<R, Error>;
 pub type VMResult<R> = result::Result<R, VMError>;
@@ -24,7 +24,9 @@ pub enum Error {
     ReplError(ReadlineError),
     TomlError(toml::de::Error),
     MissingConfiguration,
+    InvalidCache(String),
     ConfigReadError(Box<figment::Error>),
+    JsonError(serde_json::Error),
     InfallibleError,
     TardiError(Box<dyn error::Error>),
 }
@@ -32,21 +34,39 @@ pub enum Error {
 #[derive(Debug)]
 pub enum VMError {
     StackUnderflow,
-    StackOverflow,
+    /// The data stack is at its limit, which is the value.
+    StackOverflow(usize),
     ReturnStackUnderflow,
-    ReturnStackOverflow,
+    /// The return stack is at its limit, which is the value. This is usually
+    /// runaway recursion.
+    ReturnStackOverflow(usize),
     InvalidInstructionPointer(usize),
     InvalidOpCode(usize, usize),
     InvalidOpIndex(usize),
     InvalidConstantIndex(usize),
     TypeMismatch(String),
+    InvalidFormat(String),
+    FileError(String, String),
+    InvalidCodePoint(i64),
+    InvalidJson(String),
+    InvalidRegex(String, String),
     DivisionByZero,
+    DomainError(String),
+    NotComparable(String, String),
+    WrongRecordType(String, String),
     EmptyList,
+    IndexOutOfBounds(i64, usize),
     InvalidAddress(usize),
     InvalidWordCall(String),
+    UnknownWord(String),
+    AmbiguousWord(String, String),
+    YieldOutsideCoroutine,
+    InvalidResume(String),
     MissingEnvironment,
     MissingModule,
     UnfreezableValue(ValueData),
+    Throw(Value),
+    UncaughtThrow(String),
     Stop,
     Break,
     Continue,
@@ -66,7 +86,9 @@ impl fmt::Display for Error {
             ReplError(ref err) => err.fmt(f),
             TomlError(ref err) => err.fmt(f),
             MissingConfiguration => write!(f, "missing configuration"),
+            InvalidCache(reason) => write!(f, "invalid cache: {}", reason),
             ConfigReadError(ref err) => err.fmt(f),
+            JsonError(ref err) => err.fmt(f),
             InfallibleError => unimplemented!("Error::InfallibleError"),
             TardiError(ref err) => err.fmt(f),
         }
@@ -77,21 +99,54 @@ impl fmt::Display for VMError {
     fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
         match self {
             VMError::StackUnderflow => write!(f, "Stack underflow"),
-            VMError::StackOverflow => write!(f, "Stack overflow"),
+            VMError::StackOverflow(limit) => {
+                write!(f, "Stack overflow: the limit is {} items", limit)
+            }
             VMError::InvalidInstructionPointer(ip) => write!(f, "Invalid IP: {}", ip),
             VMError::InvalidOpCode(ip, code) => write!(f, "Invalid opcode: {} @ {}", code, ip),
             VMError::InvalidOpIndex(index) => write!(f, "invalid op table index: {}", index),
             VMError::InvalidConstantIndex(index) => write!(f, "Invalid constant index: {}", index),
             VMError::TypeMismatch(op) => write!(f, "Type mismatch in {} operation", op),
+            VMError::InvalidFormat(reason) => write!(f, "Invalid format string: {}", reason),
+            VMError::FileError(path, message) => write!(f, "{}: {}", path, message),
+            VMError::InvalidCodePoint(n) => write!(f, "Invalid Unicode code point: {}", n),
+            VMError::InvalidJson(reason) => write!(f, "Invalid JSON: {}", reason),
+            VMError::InvalidRegex(pattern, reason) => {
+                write!(f, "Invalid regex {:?}: {}", pattern, reason)
+            }
             VMError::DivisionByZero => write!(f, "Division by zero"),
+            VMError::DomainError(reason) => write!(f, "Math domain error: {}", reason),
+            VMError::NotComparable(a, b) => write!(f, "Cannot compare {} and {}", a, b),
+            VMError::WrongRecordType(expected, actual) => {
+                write!(f, "Expected {} but got {}", expected, actual)
+            }
             VMError::ReturnStackUnderflow => write!(f, "Return stack underflow"),
-            VMError::ReturnStackOverflow => write!(f, "Return stack overflow"),
+            VMError::ReturnStackOverflow(limit) => {
+                write!(f, "Call stack exhausted: the limit is {} frames", limit)
+            }
             VMError::EmptyList => write!(f, "Cannot split head of empty list"),
+            VMError::IndexOutOfBounds(index, length) => {
+                write!(f, "Index {} out of bounds for length {}", index, length)
+            }
             VMError::InvalidAddress(addr) => write!(f, "Invalid address: {}", addr),
             VMError::InvalidWordCall(word) => write!(f, "Invalid word call: {}", word),
+            VMError::UnknownWord(word) => write!(f, "Unknown word: {}", word),
+            VMError::AmbiguousWord(word, modules) => {
+                write!(
+                    f,
+                    "{} is defined in more than one module: {}",
+                    word, modules
+                )
+            }
+            VMError::YieldOutsideCoroutine => write!(f, "yield outside of a coroutine"),
+            VMError::InvalidResume(state) => {
+                write!(f, "Cannot resume a coroutine that's {}", state)
+            }
             VMError::MissingModule => write!(f, "No module"),
             VMError::MissingEnvironment => write!(f, "No environment"),
             VMError::UnfreezableValue(v) => write!(f, "cannot freeze value {}", v),
+            VMError::Throw(v) => write!(f, "thrown {}", v.to_repr()),
+            VMError::UncaughtThrow(message) => write!(f, "uncaught throw: {}", message),
             VMError::Stop => unreachable!("VMError::Stop"),
             VMError::Break => unreachable!("VMError::Break"),
             VMError::Continue => unreachable!("VMError::Continue"),
@@ -133,6 +188,12 @@ impl From<figment::Error> for Error {
     }
 }
 
+impl From<serde_json::Error> for Error {
+    fn from(err: serde_json::Error) -> Self {
+        JsonError(err)
+    }
+}
+
 impl From<Infallible> for Error {
     fn from(_: Infallible) -> Self {
         InfallibleError
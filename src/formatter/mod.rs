@@ -0,0 +1,383 @@
+//! A canonical formatter for Tardi source.
+//!
+//! This uses the regular `Scanner` to find the tokens, and it recovers the
+//! comments from the gaps between them, so formatting doesn't lose any. The
+//! line breaks in the source are kept, but the spacing is normalized:
+//!
+//! * words on a line are separated by one space;
+//! * lines are indented four spaces for every enclosing definition,
+//!   quotation, or `exports:` list, so definitions sit at column zero;
+//! * runs of blank lines are collapsed to one;
+//! * stack-effect comments right after the name in consecutive definitions,
+//!   and trailing `//` comments on consecutive lines, are aligned.
+//!
+//! Multi-line tokens, like triple-quoted strings and `(* ... *)` comments,
+//! are copied as-is.
+
+use crate::scanner::error::ScannerResult;
+use crate::scanner::Scanner;
+
+const INDENT: &str = "    ";
+const DEFINITION_WORDS: &[&str] = &[":", "::", "MACRO:"];
+const OPENING_WORDS: &[&str] = &["[", "{", ":", "::", "MACRO:", "exports:", "record:"];
+const CLOSING_WORDS: &[&str] = &["]", "}", ";"];
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum TokenKind {
+    Code,
+    Comment,
+    LineComment,
+}
+
+#[derive(Debug, Clone)]
+struct Token {
+    kind: TokenKind,
+    text: String,
+    line: usize,
+    end_line: usize,
+}
+
+impl Token {
+    fn is_code(&self, words: &[&str]) -> bool {
+        self.kind == TokenKind::Code && words.contains(&self.text.as_str())
+    }
+
+    fn is_multiline(&self) -> bool {
+        self.line != self.end_line
+    }
+}
+
+#[derive(Debug, Default)]
+struct Line {
+    depth: usize,
+    blank_before: bool,
+    tokens: Vec<Token>,
+}
+
+impl Line {
+    fn is_multiline(&self) -> bool {
+        self.tokens.iter().any(|t| t.is_multiline())
+    }
+
+    /// The number of tokens that make up a definition head, `: name`, when
+    /// it's followed by a stack-effect comment.
+    fn stack_effect_index(&self) -> Option<usize> {
+        match self.tokens.as_slice() {
+            [def, name, effect, ..]
+                if def.is_code(DEFINITION_WORDS)
+                    && name.kind == TokenKind::Code
+                    && effect.kind == TokenKind::Comment
+                    && effect.text.starts_with('(')
+                    && !effect.text.starts_with("(*") =>
+            {
+                Some(2)
+            }
+            _ => None,
+        }
+    }
+
+    fn has_trailing_comment(&self) -> bool {
+        self.tokens.len() > 1 && self.tokens.last().map(|t| t.kind) == Some(TokenKind::LineComment)
+    }
+
+    fn indent(&self) -> String {
+        INDENT.repeat(self.depth)
+    }
+}
+
+/// Formats Tardi source into its canonical form.
+///
+/// Formatting is idempotent, so formatting the output again doesn't change
+/// it.
+pub fn format_source(input: &str) -> ScannerResult<String> {
+    let tokens = tokenize(input)?;
+    let lines = layout(tokens);
+    let eol = if input.contains("\r\n") { "\r\n" } else { "\n" };
+    Ok(render(&lines, eol))
+}
+
+/// Returns `true` if the source is already in its canonical form.
+pub fn is_formatted(input: &str) -> ScannerResult<bool> {
+    Ok(format_source(input)? == input)
+}
+
+fn tokenize(input: &str) -> ScannerResult<Vec<Token>> {
+    let line_starts = line_starts(input);
+    let line_of = |offset: usize| line_starts.partition_point(|&start| start <= offset);
+    let mut scanner = Scanner::from_input_string(input);
+    let mut tokens = Vec::new();
+    let mut end = 0;
+
+    while let Some(value) = scanner.scan_value() {
+        let value = value?;
+        let pos = value.pos.expect("scanned values have positions");
+        scan_comments(input, end, pos.offset, &line_of, &mut tokens);
+        end = pos.offset + pos.length;
+        tokens.push(Token {
+            kind: TokenKind::Code,
+            text: input[pos.offset..end].to_string(),
+            line: pos.line,
+            end_line: line_of(end.saturating_sub(1).max(pos.offset)),
+        });
+    }
+    scan_comments(input, end, input.len(), &line_of, &mut tokens);
+
+    Ok(tokens)
+}
+
+fn line_starts(input: &str) -> Vec<usize> {
+    let mut starts = vec![0];
+    starts.extend(input.match_indices('\n').map(|(i, _)| i + 1));
+    starts
+}
+
+/// Finds the comments between two tokens. The scanner has already skipped
+/// over these, so anything that isn't whitespace is a comment.
+fn scan_comments(
+    input: &str,
+    start: usize,
+    end: usize,
+    line_of: &dyn Fn(usize) -> usize,
+    tokens: &mut Vec<Token>,
+) {
+    let gap = &input[start..end];
+    let mut index = 0;
+
+    while let Some(word_start) = gap[index..].find(|c: char| !c.is_whitespace()) {
+        let word_start = index + word_start;
+        let word = next_word(gap, word_start);
+        let (kind, comment_end) = if word.starts_with("//") {
+            let eol = gap[word_start..]
+                .find('\n')
+                .map_or(gap.len(), |i| word_start + i);
+            (TokenKind::LineComment, eol)
+        } else {
+            let close = if word == "(*" { "*)" } else { ")" };
+            (
+                TokenKind::Comment,
+                comment_end(gap, word_start, word, close),
+            )
+        };
+
+        let text = gap[word_start..comment_end].trim_end();
+        tokens.push(Token {
+            kind,
+            text: text.to_string(),
+            line: line_of(start + word_start),
+            end_line: line_of(start + word_start + text.len().saturating_sub(1)),
+        });
+        index = comment_end;
+    }
+}
+
+fn next_word(input: &str, start: usize) -> &str {
+    let end = input[start..]
+        .find(|c: char| c.is_whitespace())
+        .map_or(input.len(), |i| start + i);
+    &input[start..end]
+}
+
+/// Finds the end of a comment that starts with `open`, allowing for nested
+/// comments.
+fn comment_end(input: &str, start: usize, open: &str, close: &str) -> usize {
+    let mut depth = 0;
+    let mut index = start;
+
+    while let Some(word_start) = input[index..].find(|c: char| !c.is_whitespace()) {
+        let word_start = index + word_start;
+        let word = next_word(input, word_start);
+        index = word_start + word.len();
+        if word == open {
+            depth += 1;
+        } else if word == close {
+            depth -= 1;
+            if depth == 0 {
+                break;
+            }
+        }
+    }
+
+    index
+}
+
+/// Groups the tokens into lines and works out how deeply each is nested.
+///
+/// Each opening word that's still open keeps the depth of the lines inside
+/// it, which is one more than the line it's on. That way several openers on
+/// one line, like `: while [`, only indent the following lines once.
+fn layout(tokens: Vec<Token>) -> Vec<Line> {
+    let mut lines: Vec<Line> = Vec::new();
+    let mut open: Vec<usize> = Vec::new();
+    // Set when the next word is quoted by `\` or names a definition, so
+    // it isn't structural.
+    let mut escaped = false;
+    let mut last_line = None;
+
+    for token in tokens {
+        let starts_definition = token.is_code(DEFINITION_WORDS) && open.is_empty() && !escaped;
+        match last_line {
+            Some(last_line) if token.line > last_line => {
+                lines.push(Line {
+                    blank_before: token.line > last_line + 1,
+                    ..new_line(&open, &token, escaped)
+                });
+            }
+            Some(_) if starts_definition => {
+                lines.push(new_line(&open, &token, escaped));
+            }
+            Some(_) => {}
+            None => lines.push(new_line(&open, &token, escaped)),
+        }
+        last_line = Some(token.end_line);
+
+        if token.kind == TokenKind::Code {
+            if escaped {
+                escaped = false;
+            } else if token.text == "\\" {
+                escaped = true;
+            } else if token.is_code(OPENING_WORDS) {
+                let depth = lines.last().map_or(0, |line| line.depth);
+                open.push(depth + 1);
+                escaped = token.is_code(DEFINITION_WORDS);
+            } else if token.is_code(CLOSING_WORDS) {
+                open.pop();
+            }
+        }
+
+        if let Some(line) = lines.last_mut() {
+            line.tokens.push(token);
+        }
+    }
+
+    lines
+}
+
+/// Starts a line. A line that starts by closing something lines up with
+/// the line that opened it.
+fn new_line(open: &[usize], first: &Token, escaped: bool) -> Line {
+    let depth = open.last().copied().unwrap_or(0);
+    let depth = if !escaped && first.is_code(CLOSING_WORDS) {
+        depth.saturating_sub(1)
+    } else {
+        depth
+    };
+    Line {
+        depth,
+        ..Line::default()
+    }
+}
+
+fn render(lines: &[Line], eol: &str) -> String {
+    let mut output = String::new();
+    for block in blocks(lines) {
+        let plain = || block.iter().filter(|line| !line.is_multiline());
+        let effect_column = align_column(plain().filter_map(|line| {
+            line.stack_effect_index()
+                .map(|i| width(&join(line, &line.tokens[..i])))
+        }));
+        let code: Vec<String> = block
+            .iter()
+            .map(|line| render_code(line, effect_column))
+            .collect();
+        let comment_column = align_column(
+            block
+                .iter()
+                .zip(&code)
+                .filter(|(line, _)| !line.is_multiline() && line.has_trailing_comment())
+                .map(|(_, code)| width(code)),
+        );
+
+        for (line, code) in block.iter().zip(code) {
+            if line.blank_before && !output.is_empty() {
+                output.push_str(eol);
+            }
+            output.push_str(&code);
+            if line.has_trailing_comment() {
+                let comment = &line.tokens[line.tokens.len() - 1].text;
+                pad_to(&mut output, &code, comment_column);
+                output.push_str(comment);
+            }
+            output.push_str(eol);
+        }
+    }
+    output
+}
+
+/// Splits the lines into blocks separated by blank lines. Alignment only
+/// happens within a block.
+fn blocks(lines: &[Line]) -> Vec<&[Line]> {
+    let mut blocks = Vec::new();
+    let mut start = 0;
+    for (i, line) in lines.iter().enumerate() {
+        if i > start && line.blank_before {
+            blocks.push(&lines[start..i]);
+            start = i;
+        }
+    }
+    if start < lines.len() {
+        blocks.push(&lines[start..]);
+    }
+    blocks
+}
+
+/// Finds the column to align something at, given how wide the text before
+/// it is on each line. Nothing is aligned unless at least two lines have it.
+fn align_column<I>(widths: I) -> Option<usize>
+where
+    I: Iterator<Item = usize>,
+{
+    let widths: Vec<usize> = widths.collect();
+    if widths.len() > 1 {
+        widths.into_iter().max().map(|w| w + 1)
+    } else {
+        None
+    }
+}
+
+/// Renders everything on a line but a trailing comment.
+fn render_code(line: &Line, effect_column: Option<usize>) -> String {
+    let end = if line.has_trailing_comment() {
+        line.tokens.len() - 1
+    } else {
+        line.tokens.len()
+    };
+    let tokens = &line.tokens[..end];
+
+    match (line.stack_effect_index(), effect_column) {
+        (Some(i), Some(column)) if !line.is_multiline() => {
+            let mut code = join(line, &tokens[..i]);
+            let head = code.clone();
+            pad_to(&mut code, &head, Some(column));
+            code.push_str(&join_tokens(&tokens[i..]));
+            code
+        }
+        _ => join(line, tokens),
+    }
+}
+
+fn join(line: &Line, tokens: &[Token]) -> String {
+    format!("{}{}", line.indent(), join_tokens(tokens))
+}
+
+fn join_tokens(tokens: &[Token]) -> String {
+    tokens
+        .iter()
+        .map(|t| t.text.as_str())
+        .collect::<Vec<_>>()
+        .join(" ")
+}
+
+fn pad_to(output: &mut String, current: &str, column: Option<usize>) {
+    let padding = column
+        .map(|column| column.saturating_sub(width(current)))
+        .unwrap_or(0)
+        .max(1);
+    output.push_str(&" ".repeat(padding));
+}
+
+fn width(text: &str) -> usize {
+    text.chars().count()
+}
+
+#[cfg(test)]
+mod tests;
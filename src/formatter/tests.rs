@@ -0,0 +1,140 @@
+use pretty_assertions::assert_eq;
+
+use super::*;
+use crate::scanner::error::ScannerError;
+
+fn assert_formats(input: &str, expected: &str) {
+    let output = format_source(input).unwrap();
+    assert_eq!(output, expected);
+    assert_eq!(format_source(&output).unwrap(), output, "not idempotent");
+}
+
+#[test]
+fn test_format_spacing() {
+    assert_formats("  1   2\t+   \n\n\n\n3  *", "1 2 +\n\n3 *\n");
+}
+
+#[test]
+fn test_format_definitions_at_column_zero() {
+    assert_formats(
+        "   : double   2 * ;  : triple 3 * ;\n",
+        ": double 2 * ;\n: triple 3 * ;\n",
+    );
+}
+
+#[test]
+fn test_format_indents_bodies() {
+    let input = "
+: check-bounds
+pick < [
+            2drop #f
+  ] [
+dupd < [ drop #f ] when
+      ] if ;
+";
+    let expected = ": check-bounds
+    pick < [
+        2drop #f
+    ] [
+        dupd < [ drop #f ] when
+    ] if ;
+";
+    assert_formats(input, expected);
+}
+
+#[test]
+fn test_format_escaped_closer() {
+    let input = "MACRO: :
+scan-value
+\\ ; scan-object-list compile
+;
+";
+    let expected = "MACRO: :
+    scan-value
+    \\ ; scan-object-list compile
+;
+";
+    assert_formats(input, expected);
+}
+
+#[test]
+fn test_format_exports() {
+    assert_formats(
+        "exports:\n  double\n triple ;\n",
+        "exports:\n    double\n    triple ;\n",
+    );
+}
+
+#[test]
+fn test_format_records() {
+    assert_formats("record: point\nx y ;\n", "record: point\n    x y ;\n");
+}
+
+#[test]
+fn test_format_aligns_stack_effects() {
+    let input = ": sq ( n -- n' ) dup * ;
+: double  ( n -- n' )   2 * ;
+\n: alone ( -- ) ;
+";
+    let expected = ": sq     ( n -- n' ) dup * ;
+: double ( n -- n' ) 2 * ;
+
+: alone ( -- ) ;
+";
+    assert_formats(input, expected);
+}
+
+#[test]
+fn test_format_aligns_trailing_comments() {
+    let input = ": max [ > ] 2keep ? ;   // x y -- xy
+: 1+ 1 + ; // n -- n'
+: 1- 1 - ;
+";
+    let expected = ": max [ > ] 2keep ? ; // x y -- xy
+: 1+ 1 + ;            // n -- n'
+: 1- 1 - ;
+";
+    assert_formats(input, expected);
+}
+
+#[test]
+fn test_format_preserves_comments() {
+    let input = "// leading
+(* a (* nested *)
+   block *)
+1 ( inline ) 2   // trailing
+";
+    let expected = "// leading
+(* a (* nested *)
+   block *)
+1 ( inline ) 2 // trailing
+";
+    assert_formats(input, expected);
+}
+
+#[test]
+fn test_format_preserves_strings() {
+    assert_formats(
+        "\"a  b\"   \"\"\"two\n   lines\"\"\"   'x'\n",
+        "\"a  b\" \"\"\"two\n   lines\"\"\" 'x'\n",
+    );
+}
+
+#[test]
+fn test_format_keeps_crlf() {
+    assert_formats(": a\r\n  1 ;\r\n", ": a\r\n    1 ;\r\n");
+}
+
+#[test]
+fn test_is_formatted() {
+    assert!(is_formatted(": a 1 ;\n").unwrap());
+    assert!(!is_formatted(":  a 1 ;\n").unwrap());
+}
+
+#[test]
+fn test_format_scanner_error() {
+    assert!(matches!(
+        format_source("1 (* never closed"),
+        Err(ScannerError::UnterminatedComment)
+    ));
+}
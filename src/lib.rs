@@ -1,17 +1,25 @@
 //! Tardi environmentming language implementation
 
+pub mod cache;
 pub mod compiler;
 pub mod config;
 pub mod core;
+pub mod debugger;
 pub mod env;
 pub mod error;
+pub mod formatter;
+pub mod lsp;
 pub mod module;
+pub mod profiler;
+pub mod regex;
 pub mod scanner;
 pub mod shared;
 pub mod value;
 pub mod vm;
 
-use std::path::Path;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
 
 use error::VMError;
 use rustyline::error::ReadlineError;
@@ -19,35 +27,243 @@ use rustyline::history::{FileHistory, History};
 use rustyline::{self, DefaultEditor};
 
 use crate::compiler::Compiler;
-use crate::config::Config;
+use crate::config::{old_history_file, Config};
 use crate::core::Tardi;
+use crate::debugger::Debugger;
 use crate::error::Result;
+use crate::profiler::{Profiler, ReportFormat};
+use crate::scanner::error::ScannerError;
 use crate::scanner::Scanner;
+use crate::value::{Value, ValueData};
 use crate::vm::VM;
 
-/// Run a Tardi source file
-pub fn run_file(path: &Path, config: &Config, print_stack: bool) -> Result<()> {
-    // TODO: make creation and bootstrapping one function between run_file and repl
+/// Run a Tardi source file. If it has a cache that's up to date, that's run
+/// instead. A cache file itself can also be run directly.
+///
+/// With `profile`, this prints a report of the words that ran to stderr in
+/// that format, even if the program fails.
+pub fn run_file(
+    path: &Path,
+    args: &[String],
+    config: &Config,
+    print_stack: bool,
+    profile: Option<ReportFormat>,
+) -> Result<()> {
+    let profiler = profile.map(|_| Profiler::new());
+    let result = execute_path(path, args, config, profiler.as_ref());
+    if let (Some(profiler), Some(format)) = (profiler, profile) {
+        profiler.finish();
+        profiler.write_report(&mut io::stderr(), format)?;
+    }
+    let tardi = result?;
+
+    if print_stack {
+        print_stack_values(&tardi);
+    }
+
+    Ok(())
+}
+
+/// Run a program that's given as a string, like `tardi -e '1 2 + println'`.
+/// It's compiled and run in a fresh VM, the same as a file would be, and
+/// `argv` leaves `args`.
+pub fn run_str(program: &str, args: &[String], config: &Config, print_stack: bool) -> Result<()> {
     let mut tardi = Tardi::from(config);
-    // TODO: add an option for the bootstrap dir
+    tardi.set_args(args.to_vec());
     tardi.bootstrap(None)?;
-    tardi.execute_file(path)?;
-    let tardi = tardi;
+    if let Err(err) = tardi.execute_str(program) {
+        print_trace(&tardi);
+        return Err(err);
+    }
 
     if print_stack {
-        // Print stack contents from top to bottom
-        for value in tardi.stack() {
-            eprintln!("{}", value.to_repr());
+        print_stack_values(&tardi);
+    }
+
+    Ok(())
+}
+
+fn print_stack_values(tardi: &Tardi) {
+    // Print stack contents from top to bottom
+    for value in tardi.stack() {
+        eprintln!("{}", value.to_repr());
+    }
+}
+
+/// Run a Tardi source file and report the results of the tests in it.
+///
+/// This returns `true` if every test passed.
+pub fn test_file(path: &Path, config: &Config) -> Result<bool> {
+    let tardi = execute_path(path, &[], config, None)?;
+    let results = tardi.test_results();
+
+    let mut failed = 0;
+    for result in results.iter().filter(|result| !result.passed()) {
+        failed += 1;
+        let name = result.name.as_deref().unwrap_or("assertion");
+        for failure in &result.failures {
+            println!("FAIL {}: {}", name, failure);
         }
     }
+    println!("{} passed, {} failed", results.len() - failed, failed);
+
+    Ok(failed == 0)
+}
 
+/// Run a Tardi source file in the step debugger, which reads its commands
+/// from stdin. It pauses before the first instruction, or if there are
+/// `breakpoints`, before the first call to one of them.
+pub fn debug_file(path: &Path, breakpoints: &[String], config: &Config) -> Result<()> {
+    let mut tardi = Tardi::from(config);
+    tardi.bootstrap(None)?;
+    tardi.compile_script(path)?;
+
+    let input = io::BufReader::new(io::stdin());
+    let debugger = Debugger::new(input, io::stderr(), breakpoints.iter().cloned());
+    tardi.set_hook(Box::new(debugger));
+
+    match tardi.execute() {
+        Ok(()) | Err(error::Error::VMError(VMError::Bye)) => Ok(()),
+        Err(err) => {
+            print_trace(&tardi);
+            Err(err)
+        }
+    }
+}
+
+/// Load and execute a file, printing the call trace if it fails. The
+/// `profiler` only watches the program run, not bootstrapping or compiling.
+fn execute_path(
+    path: &Path,
+    args: &[String],
+    config: &Config,
+    profiler: Option<&Profiler>,
+) -> Result<Tardi> {
+    // TODO: make creation and bootstrapping one function between run_file and repl
+    let mut tardi = Tardi::from(config);
+    tardi.set_args(args.to_vec());
+    let result = if path
+        .extension()
+        .is_some_and(|ext| ext == cache::CACHE_EXTENSION)
+    {
+        if !tardi.load_cache(path, false)? {
+            return Err(io::Error::from(io::ErrorKind::NotFound).into());
+        }
+        execute_profiled(&mut tardi, profiler)
+    } else {
+        let cache_file = cache::cache_path(path);
+        let is_cached = tardi.load_cache(&cache_file, true).unwrap_or_else(|err| {
+            log::warn!("ignoring cache {}: {}", cache_file.display(), err);
+            false
+        });
+        if is_cached {
+            execute_profiled(&mut tardi, profiler)
+        } else {
+            // TODO: add an option for the bootstrap dir
+            tardi.bootstrap(None)?;
+            tardi.reset();
+            tardi
+                .compile_script(path)
+                .and_then(|_| execute_profiled(&mut tardi, profiler))
+        }
+    };
+    if let Err(err) = result {
+        print_trace(&tardi);
+        return Err(err);
+    }
+
+    Ok(tardi)
+}
+
+fn execute_profiled(tardi: &mut Tardi, profiler: Option<&Profiler>) -> Result<()> {
+    if let Some(profiler) = profiler {
+        tardi.set_hook(Box::new(profiler.clone()));
+    }
+    tardi.execute()
+}
+
+/// Format a Tardi source file in place. With `check`, the file is left alone.
+///
+/// This returns `true` if the file was already formatted.
+pub fn format_file(path: &Path, check: bool) -> Result<bool> {
+    let input = fs::read_to_string(path)?;
+    let output = formatter::format_source(&input)?;
+    let formatted = output == input;
+    if !formatted && !check {
+        fs::write(path, output)?;
+    }
+    Ok(formatted)
+}
+
+/// Run the language server, which talks to an editor over stdin and stdout
+/// until the editor tells it to exit.
+pub fn language_server(config: &Config) -> Result<()> {
+    let stdin = io::stdin();
+    let mut server = lsp::Server::new(stdin.lock(), io::stdout(), config.clone());
+    server.run()
+}
+
+/// Compile a Tardi source file to a cache, which defaults to the one that
+/// `run_file` looks for. This returns where the cache was written.
+pub fn compile_file(path: &Path, output: Option<&Path>, config: &Config) -> Result<PathBuf> {
+    let mut tardi = Tardi::from(config);
+    tardi.bootstrap(None)?;
+    let output = output.map_or_else(|| cache::cache_path(path), Path::to_path_buf);
+    tardi.compile_to_cache(path, &output)?;
+    Ok(output)
+}
+
+/// Print the bytecode of the words that a Tardi source file defines,
+/// without running it. With `words`, only those are printed. They're looked
+/// up in the file's module first, so they can be words it uses from others.
+pub fn disassemble_file(path: &Path, words: &[String], config: &Config) -> Result<()> {
+    let mut tardi = Tardi::from(config);
+    tardi.bootstrap(None)?;
+    tardi.compile_script(path)?;
+
+    let env = tardi.environment.borrow();
+    let module_name = scanner::Source::ScriptFile {
+        path: path.to_path_buf(),
+    }
+    .get_key();
+    let indices = if words.is_empty() {
+        let mut defined: Vec<(usize, usize)> = env
+            .get_module(&module_name)
+            .map(|module| module.defined.values().copied().collect::<Vec<_>>())
+            .unwrap_or_default()
+            .into_iter()
+            .filter_map(|index| Some((env.op_table.get(index)?.borrow().get_ip()?, index)))
+            .collect();
+        defined.sort();
+        defined.into_iter().map(|(_, index)| index).collect()
+    } else {
+        words
+            .iter()
+            .map(|word| {
+                env.get_op_index(&module_name, word)
+                    .or_else(|| env.find_word(word).first().map(|(_, index)| *index))
+                    .ok_or_else(|| VMError::UnknownWord(word.clone()).into())
+            })
+            .collect::<Result<Vec<_>>>()?
+    };
+
+    let listings: Vec<String> = indices
+        .into_iter()
+        .map(|index| {
+            let name = env.word_name(index);
+            env.disassemble(name.as_deref(), &env.op_table[index].borrow())
+        })
+        .collect();
+    print!("{}", listings.join("\n"));
     Ok(())
 }
 
+const PROMPT: &str = ">>> ";
+const CONTINUATION_PROMPT: &str = "... ";
+
 // TODO: highlighting
 // TODO: completion
 // TODO: hints
-// TODO: multilines (via rustyline::validate)
 pub fn repl(config: &Config) -> Result<()> {
     let mut tardi = Tardi::from(config);
 
@@ -59,16 +275,36 @@ pub fn repl(config: &Config) -> Result<()> {
     if let Some(history_file) = config.repl.history_file.as_ref() {
         if history_file.exists() {
             readline.history_mut().load(history_file)?;
+        } else if let Some(old_file) = old_history_file(history_file) {
+            // This is saved to the new location when the REPL exits.
+            readline.history_mut().load(&old_file)?;
         }
     }
 
     tardi.bootstrap(None)?;
 
+    // Incomplete input stays here until the rest of it is read.
+    let mut buffer = String::new();
     loop {
-        let input = readline.readline(">>> ");
-        match input {
-            Ok(input) => {
-                readline.add_history_entry(&input)?;
+        let prompt = if buffer.is_empty() {
+            PROMPT
+        } else {
+            CONTINUATION_PROMPT
+        };
+        match readline.readline(prompt) {
+            Ok(line) => {
+                if buffer.is_empty() && line.trim() == ".quit" {
+                    println!("bye now");
+                    break;
+                }
+
+                buffer.push_str(&line);
+                buffer.push('\n');
+                if is_incomplete(&buffer) {
+                    continue;
+                }
+                let input = std::mem::take(&mut buffer);
+                readline.add_history_entry(input.trim_end())?;
 
                 // TODO: reset the stack and items on it on errors
                 // how? memory snapshots? clones? yech!
@@ -78,12 +314,17 @@ pub fn repl(config: &Config) -> Result<()> {
                         println!("bye now");
                         break;
                     }
-                    Err(err) => eprintln!("error: {}", err),
+                    Err(err) => {
+                        eprintln!("error: {}", err);
+                        print_trace(&tardi);
+                    }
                 }
 
-                for value in tardi.stack() {
-                    println!("{}", value.to_repr());
-                }
+                println!("{}", format_stack(&tardi.stack()));
+            }
+            Err(ReadlineError::Interrupted) if !buffer.is_empty() => {
+                // This drops the incomplete input, but it stays in the REPL.
+                buffer.clear();
             }
             Err(ReadlineError::Interrupted) | Err(ReadlineError::Eof) => {
                 println!("bye");
@@ -102,5 +343,97 @@ pub fn repl(config: &Config) -> Result<()> {
     Ok(())
 }
 
+/// The words that open a form that the REPL waits to have closed, with
+/// whether the word after each one is a name rather than part of the form.
+const OPENING_WORDS: &[(&str, bool)] = &[
+    ("[", false),
+    ("{", false),
+    ("H{", false),
+    ("exports:", false),
+    (":", true),
+    ("::", true),
+    ("vocab:", true),
+    ("record:", true),
+    ("test:", true),
+];
+
+/// The words that close a form from `OPENING_WORDS`.
+const CLOSING_WORDS: &[&str] = &["]", "}", ";", ";vocab"];
+
+/// Is this REPL input unfinished? It is if it has an unterminated string or
+/// comment, or if a form from `OPENING_WORDS` or a `MACRO:` definition is
+/// still open.
+fn is_incomplete(input: &str) -> bool {
+    let mut scanner = Scanner::from_input_string(input);
+    let mut depth: isize = 0;
+    // The word after `\` or a definition word is quoted or a name, so it
+    // doesn't open or close anything.
+    let mut skip_next = false;
+
+    while let Some(value) = scanner.scan_value() {
+        let value = match value {
+            Ok(value) => value,
+            Err(ScannerError::UnterminatedString) | Err(ScannerError::UnterminatedComment) => {
+                return true
+            }
+            Err(_) => return false,
+        };
+        if skip_next {
+            skip_next = false;
+            continue;
+        }
+        if matches!(value.data, ValueData::Macro) {
+            depth += 1;
+            skip_next = true;
+            continue;
+        }
+        let word = match value.data.get_word() {
+            Some(word) => word,
+            None => continue,
+        };
+        if let Some((_, names)) = OPENING_WORDS.iter().find(|(opener, _)| *opener == word) {
+            depth += 1;
+            skip_next = *names;
+        } else if CLOSING_WORDS.contains(&word) {
+            depth -= 1;
+        } else if word == "\\" {
+            skip_next = true;
+        }
+    }
+
+    depth > 0 || skip_next
+}
+
+/// Formats the stack like a stack effect, `( 1 2 3 -- )`, with the top of
+/// the stack on the right.
+fn format_stack(stack: &[Value]) -> String {
+    let mut output = String::from("(");
+    for value in stack {
+        output.push(' ');
+        output.push_str(&value.to_repr());
+    }
+    output.push_str(" -- )");
+    output
+}
+
+/// How many frames of a call trace are printed. Runaway recursion can leave
+/// a very long one, and the innermost frames are the interesting ones.
+const TRACE_LIMIT: usize = 20;
+
+/// Print the call trace for an uncaught error to stderr.
+fn print_trace(tardi: &Tardi) {
+    let trace = tardi.trace();
+    if trace.is_empty() {
+        return;
+    }
+    eprintln!("Call trace (innermost first):");
+    for frame in trace.iter().take(TRACE_LIMIT) {
+        eprintln!("    {}", frame);
+    }
+    if trace.len() > TRACE_LIMIT {
+        eprintln!("    ... and {} more", trace.len() - TRACE_LIMIT);
+    }
+}
+
 #[cfg(test)]
 mod tests;
@@ -0,0 +1,652 @@
+//! A language server for editors, speaking LSP over stdio.
+//!
+//! It handles:
+//!
+//! * diagnostics, from compiling a document when it's opened or saved;
+//! * hover, which shows a word's definition head, with its stack effect,
+//!   and the `///` comments before it;
+//! * go to definition, for words defined in Tardi source, using the
+//!   environment's position table;
+//! * completion of the words that are in scope in the document.
+//!
+//! Documents are synced whole, so every change sends all of the text. A
+//! document is compiled again when it's saved, or the next time it's needed
+//! after it changes. Compiling runs the document's macros, but not its
+//! top-level code.
+
+use std::collections::{BTreeSet, HashMap};
+use std::fs;
+use std::io::{self, BufRead, Write};
+use std::path::{Path, PathBuf};
+
+use serde_json::{json, Value as Json};
+
+use crate::compiler::effects::{builtin_effect, opcode_step, Step};
+use crate::config::Config;
+use crate::core::{Tardi, BOOTSTRAP_SOURCES};
+use crate::error::Result;
+use crate::module::internal::kernel::KERNEL;
+use crate::scanner::Scanner;
+use crate::value::lambda::Callable;
+use crate::value::{Pos, Value, ValueData};
+
+/// The JSON-RPC error code for a request that came after `shutdown`.
+const INVALID_REQUEST: i64 = -32600;
+
+/// The JSON-RPC error code for a method the server doesn't handle.
+const METHOD_NOT_FOUND: i64 = -32601;
+
+/// The JSON-RPC error code for a request that failed.
+const INTERNAL_ERROR: i64 = -32603;
+
+/// The words that start a definition, with the name after them.
+const DEFINITION_WORDS: &[&str] = &[":", "::"];
+
+/// A language server that reads messages from `input` and writes them to
+/// `output`.
+pub struct Server<R, W> {
+    input: R,
+    output: W,
+    config: Config,
+    documents: HashMap<String, Document>,
+    shutting_down: bool,
+}
+
+struct Document {
+    path: PathBuf,
+    text: String,
+    /// The document compiled, if it hasn't changed since.
+    analysis: Option<Analysis>,
+}
+
+/// What compiling a document found.
+struct Analysis {
+    tardi: Tardi,
+    /// The document's module.
+    module: String,
+    /// Where the compile failed, and why.
+    error: Option<(Pos, String)>,
+}
+
+/// Where a word is defined.
+enum Definition {
+    /// In Tardi source. The kernel's source doesn't have a `path`. `head`
+    /// is the definition word and the name, like `: square`, if it could be
+    /// found.
+    Source {
+        path: Option<PathBuf>,
+        text: String,
+        pos: Pos,
+        head: Option<String>,
+    },
+    /// In Rust.
+    BuiltIn,
+}
+
+impl<R: BufRead, W: Write> Server<R, W> {
+    pub fn new(input: R, output: W, config: Config) -> Self {
+        Server {
+            input,
+            output,
+            config,
+            documents: HashMap::new(),
+            shutting_down: false,
+        }
+    }
+
+    /// Handles messages until the client sends `exit` or closes the input.
+    pub fn run(&mut self) -> Result<()> {
+        while let Some(message) = self.read_message()? {
+            let method = message["method"].as_str().unwrap_or_default().to_string();
+            if method == "exit" {
+                break;
+            }
+            let params = message.get("params").cloned().unwrap_or(Json::Null);
+            match message.get("id").cloned() {
+                Some(id) if self.shutting_down => {
+                    let response =
+                        error_response(id, INVALID_REQUEST, "the server is shutting down");
+                    self.write_message(&response)?;
+                }
+                Some(id) => {
+                    let response = match self.handle_request(&method, &params) {
+                        Ok(Some(result)) => json!({ "jsonrpc": "2.0", "id": id, "result": result }),
+                        Ok(None) => error_response(id, METHOD_NOT_FOUND, &method),
+                        Err(err) => error_response(id, INTERNAL_ERROR, &err.to_string()),
+                    };
+                    self.write_message(&response)?;
+                }
+                None => self.handle_notification(&method, &params)?,
+            }
+        }
+        Ok(())
+    }
+
+    fn read_message(&mut self) -> Result<Option<Json>> {
+        let mut length = None;
+        loop {
+            let mut line = String::new();
+            if self.input.read_line(&mut line)? == 0 {
+                return Ok(None);
+            }
+            let line = line.trim_end();
+            if line.is_empty() {
+                break;
+            }
+            if let Some(value) = line.strip_prefix("Content-Length:") {
+                length = value.trim().parse::<usize>().ok();
+            }
+        }
+
+        let length = length.ok_or_else(|| {
+            io::Error::new(io::ErrorKind::InvalidData, "missing Content-Length header")
+        })?;
+        let mut body = vec![0; length];
+        self.input.read_exact(&mut body)?;
+        Ok(Some(serde_json::from_slice(&body)?))
+    }
+
+    fn write_message(&mut self, message: &Json) -> Result<()> {
+        let body = message.to_string();
+        write!(
+            self.output,
+            "Content-Length: {}\r\n\r\n{}",
+            body.len(),
+            body
+        )?;
+        self.output.flush()?;
+        Ok(())
+    }
+
+    fn notify(&mut self, method: &str, params: Json) -> Result<()> {
+        self.write_message(&json!({ "jsonrpc": "2.0", "method": method, "params": params }))
+    }
+
+    /// Returns `None` if the server doesn't handle `method`.
+    fn handle_request(&mut self, method: &str, params: &Json) -> Result<Option<Json>> {
+        let result = match method {
+            "initialize" => json!({
+                "capabilities": {
+                    "textDocumentSync": {
+                        "openClose": true,
+                        "change": 1,
+                        "save": { "includeText": true },
+                    },
+                    "hoverProvider": true,
+                    "definitionProvider": true,
+                    "completionProvider": {},
+                },
+                "serverInfo": { "name": "tardi", "version": env!("CARGO_PKG_VERSION") },
+            }),
+            "shutdown" => {
+                self.shutting_down = true;
+                Json::Null
+            }
+            "textDocument/hover" => self.hover(params)?,
+            "textDocument/definition" => self.definition(params)?,
+            "textDocument/completion" => self.completion(params)?,
+            _ => return Ok(None),
+        };
+        Ok(Some(result))
+    }
+
+    fn handle_notification(&mut self, method: &str, params: &Json) -> Result<()> {
+        let uri = params["textDocument"]["uri"]
+            .as_str()
+            .unwrap_or_default()
+            .to_string();
+        match method {
+            "textDocument/didOpen" => {
+                if let Some(path) = uri_to_path(&uri) {
+                    let text = params["textDocument"]["text"]
+                        .as_str()
+                        .unwrap_or_default()
+                        .to_string();
+                    let document = Document {
+                        path,
+                        text,
+                        analysis: None,
+                    };
+                    self.documents.insert(uri.clone(), document);
+                    self.publish_diagnostics(&uri)?;
+                }
+            }
+            "textDocument/didChange" => {
+                let text = params["contentChanges"]
+                    .as_array()
+                    .and_then(|changes| changes.last())
+                    .and_then(|change| change["text"].as_str());
+                if let (Some(document), Some(text)) = (self.documents.get_mut(&uri), text) {
+                    document.text = text.to_string();
+                    document.analysis = None;
+                }
+            }
+            "textDocument/didSave" => {
+                if let Some(document) = self.documents.get_mut(&uri) {
+                    if let Some(text) = params["text"].as_str() {
+                        document.text = text.to_string();
+                    }
+                    document.analysis = None;
+                    self.publish_diagnostics(&uri)?;
+                }
+            }
+            "textDocument/didClose" => {
+                if self.documents.remove(&uri).is_some() {
+                    self.notify(
+                        "textDocument/publishDiagnostics",
+                        json!({ "uri": uri, "diagnostics": [] }),
+                    )?;
+                }
+            }
+            _ => log::debug!("lsp: ignoring {}", method),
+        }
+        Ok(())
+    }
+
+    /// Compiles the document if it's changed since it was last compiled.
+    fn analyze(&mut self, uri: &str) -> Result<Option<&Document>> {
+        let config = &self.config;
+        let document = match self.documents.get_mut(uri) {
+            Some(document) => document,
+            None => return Ok(None),
+        };
+        if document.analysis.is_none() {
+            document.analysis = Some(Analysis::new(config, &document.path, &document.text)?);
+        }
+        Ok(Some(document))
+    }
+
+    fn publish_diagnostics(&mut self, uri: &str) -> Result<()> {
+        let diagnostics = match self.analyze(uri)? {
+            Some(Document {
+                text,
+                analysis:
+                    Some(Analysis {
+                        error: Some((pos, message)),
+                        ..
+                    }),
+                ..
+            }) => vec![json!({
+                "range": range(text, pos),
+                "severity": 1,
+                "source": "tardi",
+                "message": message,
+            })],
+            _ => vec![],
+        };
+        self.notify(
+            "textDocument/publishDiagnostics",
+            json!({ "uri": uri, "diagnostics": diagnostics }),
+        )
+    }
+
+    /// The document and the word at the position in the parameters.
+    fn word_at(&mut self, params: &Json) -> Result<Option<(&Document, String, Pos)>> {
+        let uri = params["textDocument"]["uri"].as_str().unwrap_or_default();
+        let line = params["position"]["line"].as_u64().unwrap_or_default() as usize;
+        let character = params["position"]["character"].as_u64().unwrap_or_default() as usize;
+        let document = match self.analyze(uri)? {
+            Some(document) => document,
+            None => return Ok(None),
+        };
+        let offset = offset_of(&document.text, line, character);
+        Ok(word_at(&document.text, offset).map(|(word, pos)| (document, word, pos)))
+    }
+
+    fn hover(&mut self, params: &Json) -> Result<Json> {
+        let (document, word, pos) = match self.word_at(params)? {
+            Some(found) => found,
+            None => return Ok(Json::Null),
+        };
+        let contents = match find(document, &word) {
+            Some(Definition::Source {
+                text, pos, head, ..
+            }) => {
+                let head = head.unwrap_or_else(|| word.clone());
+                let mut contents = format!(
+                    "```tardi\n{}{}\n```",
+                    head,
+                    stack_effect(&text, &pos).unwrap_or_default()
+                );
+                let docs = doc_comment(&text, &pos);
+                if !docs.is_empty() {
+                    contents.push_str("\n\n");
+                    contents.push_str(&docs);
+                }
+                contents
+            }
+            Some(Definition::BuiltIn) => {
+                let mut contents = format!("```tardi\n{}\n```\n\nA built-in word", word);
+                let effect = builtin_effect(&word).or_else(|| match opcode_step(&word) {
+                    Some(Step::Effect(effect)) => Some(effect),
+                    _ => None,
+                });
+                if let Some(effect) = effect {
+                    contents.push_str("; ");
+                    contents.push_str(&effect.to_string());
+                }
+                contents.push('.');
+                contents
+            }
+            None => return Ok(Json::Null),
+        };
+        Ok(json!({
+            "contents": { "kind": "markdown", "value": contents },
+            "range": range(&document.text, &pos),
+        }))
+    }
+
+    fn definition(&mut self, params: &Json) -> Result<Json> {
+        let (document, word, _) = match self.word_at(params)? {
+            Some(found) => found,
+            None => return Ok(Json::Null),
+        };
+        match find(document, &word) {
+            Some(Definition::Source {
+                path: Some(path),
+                text,
+                pos,
+                ..
+            }) => Ok(json!({ "uri": path_to_uri(&path), "range": range(&text, &pos) })),
+            _ => Ok(Json::Null),
+        }
+    }
+
+    fn completion(&mut self, params: &Json) -> Result<Json> {
+        let uri = params["textDocument"]["uri"].as_str().unwrap_or_default();
+        let document = match self.analyze(uri)? {
+            Some(document) => document,
+            None => return Ok(Json::Null),
+        };
+
+        let mut words = BTreeSet::new();
+        if let Some(analysis) = document.analysis.as_ref() {
+            let env = analysis.tardi.environment.borrow();
+            if let Some(module) = env.get_module(&analysis.module) {
+                words.extend(module.defined.keys().cloned());
+                words.extend(module.imported.keys().cloned());
+            }
+        }
+        // If it didn't compile, the definitions after the error are still
+        // worth offering.
+        let tokens = scan(&document.text);
+        for pair in tokens.windows(2) {
+            if let [definer, name] = pair {
+                if is_definition_word(definer) {
+                    words.extend(name.data.get_word().map(str::to_string));
+                }
+            }
+        }
+
+        let items: Vec<Json> = words
+            .into_iter()
+            .map(|word| json!({ "label": word, "kind": 3 }))
+            .collect();
+        Ok(json!(items))
+    }
+}
+
+impl Analysis {
+    fn new(config: &Config, path: &Path, text: &str) -> Result<Self> {
+        let mut tardi = Tardi::from(config);
+        tardi.bootstrap(None)?;
+        let scanner = Scanner::from_script(path, text);
+        let module = scanner.source.get_key();
+        let env = tardi.environment.clone();
+        let error = tardi
+            .compiler
+            .compile_scanner(&mut tardi.executor, env, scanner)
+            .err()
+            .map(|err| (error_pos(&tardi, path), err.to_string()));
+        Ok(Analysis {
+            tardi,
+            module,
+            error,
+        })
+    }
+}
+
+/// Where in the file at `path` a compile error happened. This is the value
+/// that caused it, if it's known and in this file, or else how far the
+/// scanner got. If the error was in a module that it uses, that's the
+/// `uses:`.
+fn error_pos(tardi: &Tardi, path: &Path) -> Pos {
+    let file_name = path
+        .file_name()
+        .map(|name| name.to_string_lossy().to_string());
+    tardi
+        .compiler
+        .error_loc()
+        .filter(|loc| Some(&loc.source) == file_name.as_ref())
+        .map(|loc| loc.pos.clone())
+        .or_else(|| tardi.compiler.scanner_pos(path))
+        .unwrap_or(Pos {
+            line: 1,
+            column: 1,
+            offset: 0,
+            length: 0,
+        })
+}
+
+/// Finds where `word` is defined. Definitions in the document itself are
+/// found by scanning it, so they're found even if it doesn't compile.
+/// Anything else is looked up in the document's module, and the position
+/// table has which module's source its body is in.
+fn find(document: &Document, word: &str) -> Option<Definition> {
+    if let Some(definition) = find_in(Some(&document.path), &document.text, word) {
+        return Some(definition);
+    }
+
+    let analysis = document.analysis.as_ref()?;
+    let env = analysis.tardi.environment.borrow();
+    let index = env.get_module(&analysis.module)?.get(word)?;
+    let lambda = env.get_callable(index)?;
+    let ip = match lambda.borrow().callable {
+        Callable::Compiled { ip, .. } => ip,
+        Callable::BuiltIn { .. } | Callable::Primitive { .. } => return Some(Definition::BuiltIn),
+    };
+    let loc = env.get_position(ip)?;
+    if loc.source == KERNEL {
+        return BOOTSTRAP_SOURCES
+            .iter()
+            .find_map(|text| find_in(None, text, word));
+    }
+
+    let path = env
+        .module_manager
+        .get(&loc.source)
+        .and_then(|module| module.path.clone())
+        .or_else(|| {
+            env.find_module(&loc.source, Some(&document.path))
+                .ok()
+                .flatten()
+                .map(|(_, path)| path)
+        })?;
+    let text = source_text(document, &path)?;
+    find_in(Some(&path), &text, word).or(Some(Definition::Source {
+        path: Some(path),
+        text,
+        pos: loc.pos.clone(),
+        head: None,
+    }))
+}
+
+/// The definition of `word` in `text`, if it's there.
+fn find_in(path: Option<&Path>, text: &str, word: &str) -> Option<Definition> {
+    let (head, pos) = find_definition(text, word)?;
+    Some(Definition::Source {
+        path: path.map(Path::to_path_buf),
+        text: text.to_string(),
+        pos,
+        head: Some(head),
+    })
+}
+
+/// Where `word` is defined in `text`, with the head of the definition.
+fn find_definition(text: &str, word: &str) -> Option<(String, Pos)> {
+    let tokens = scan(text);
+    tokens.windows(2).find_map(|pair| match pair {
+        [definer, name] if is_definition_word(definer) && name.data.get_word() == Some(word) => {
+            let definer = definer.lexeme.clone().unwrap_or_default();
+            Some((format!("{} {}", definer, word), name.pos.clone()?))
+        }
+        _ => None,
+    })
+}
+
+fn is_definition_word(value: &Value) -> bool {
+    value.data == ValueData::Macro
+        || value
+            .data
+            .get_word()
+            .is_some_and(|word| DEFINITION_WORDS.contains(&word))
+}
+
+/// The text of the file at `path`, from the document if it's the same file.
+fn source_text(document: &Document, path: &Path) -> Option<String> {
+    if path == document.path {
+        Some(document.text.clone())
+    } else {
+        fs::read_to_string(path).ok()
+    }
+}
+
+/// The values in `text` that scan, with their positions.
+fn scan(text: &str) -> Vec<Value> {
+    Scanner::from_input_string(text)
+        .scan_to_end()
+        .into_iter()
+        .filter_map(|value| value.ok())
+        .filter(|value| value.pos.is_some())
+        .collect()
+}
+
+/// The word at, or just before, the byte offset in `text`.
+fn word_at(text: &str, offset: usize) -> Option<(String, Pos)> {
+    scan(text).into_iter().find_map(|value| {
+        let pos = value.pos.clone()?;
+        let word = value.data.get_word()?;
+        if pos.offset <= offset && offset <= pos.offset + pos.length {
+            Some((word.to_string(), pos))
+        } else {
+            None
+        }
+    })
+}
+
+/// The stack-effect comment after the name of a definition, like
+/// ` ( n -- n' )`, if it has one.
+fn stack_effect(text: &str, pos: &Pos) -> Option<String> {
+    let rest = text.get(pos.offset + pos.length..)?;
+    let rest = rest.lines().next()?;
+    let trimmed = rest.trim_start();
+    if !trimmed.starts_with("( ") {
+        return None;
+    }
+    let end = trimmed.find(')')?;
+    Some(format!(" {}", &trimmed[..=end]))
+}
+
+/// The `///` comments on the lines right before line of `pos`, and one at
+/// the end of the line itself.
+fn doc_comment(text: &str, pos: &Pos) -> String {
+    let lines: Vec<&str> = text.lines().collect();
+    let index = pos.line - 1;
+    let mut docs: Vec<&str> = lines[..index.min(lines.len())]
+        .iter()
+        .rev()
+        .map(|line| line.trim())
+        .take_while(|line| line.starts_with("///"))
+        .collect();
+    docs.reverse();
+    if let Some(trailing) = lines.get(index).and_then(|line| line.split_once("///")) {
+        docs.push(trailing.1);
+    }
+    docs.iter()
+        .map(|line| line.trim_start_matches('/').trim())
+        .collect::<Vec<_>>()
+        .join("\n")
+}
+
+fn error_response(id: Json, code: i64, message: &str) -> Json {
+    json!({
+        "jsonrpc": "2.0",
+        "id": id,
+        "error": { "code": code, "message": message },
+    })
+}
+
+/// The LSP range that a token covers.
+fn range(text: &str, pos: &Pos) -> Json {
+    json!({
+        "start": position_of(text, pos.offset),
+        "end": position_of(text, pos.offset + pos.length),
+    })
+}
+
+/// The LSP position of a byte offset in `text`. LSP counts characters in
+/// UTF-16 code units.
+fn position_of(text: &str, offset: usize) -> Json {
+    let mut offset = offset.min(text.len());
+    while !text.is_char_boundary(offset) {
+        offset -= 1;
+    }
+    let before = &text[..offset];
+    let line = before.matches('\n').count();
+    let line_start = before.rfind('\n').map_or(0, |i| i + 1);
+    let character: usize = before[line_start..].chars().map(char::len_utf16).sum();
+    json!({ "line": line, "character": character })
+}
+
+/// The byte offset in `text` of an LSP position.
+fn offset_of(text: &str, line: usize, character: usize) -> usize {
+    let line_start: usize = text.split_inclusive('\n').take(line).map(str::len).sum();
+    let mut units = 0;
+    for (i, c) in text[line_start..].char_indices() {
+        if units >= character || c == '\n' {
+            return line_start + i;
+        }
+        units += c.len_utf16();
+    }
+    text.len()
+}
+
+fn uri_to_path(uri: &str) -> Option<PathBuf> {
+    let path = uri.strip_prefix("file://")?;
+    let bytes = path.as_bytes();
+    let mut decoded = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        let escaped = bytes
+            .get(i + 1..i + 3)
+            .and_then(|hex| std::str::from_utf8(hex).ok())
+            .and_then(|hex| u8::from_str_radix(hex, 16).ok());
+        match (bytes[i], escaped) {
+            (b'%', Some(byte)) => {
+                decoded.push(byte);
+                i += 3;
+            }
+            (byte, _) => {
+                decoded.push(byte);
+                i += 1;
+            }
+        }
+    }
+    String::from_utf8(decoded).ok().map(PathBuf::from)
+}
+
+fn path_to_uri(path: &Path) -> String {
+    let mut uri = String::from("file://");
+    for byte in path.to_string_lossy().bytes() {
+        match byte {
+            b'A'..=b'Z' | b'a'..=b'z' | b'0'..=b'9' | b'/' | b'-' | b'_' | b'.' | b'~' => {
+                uri.push(byte as char)
+            }
+            byte => uri.push_str(&format!("%{:02X}", byte)),
+        }
+    }
+    uri
+}
+
+#[cfg(test)]
+mod tests;
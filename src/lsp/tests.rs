@@ -0,0 +1,194 @@
+use std::io::{Cursor, Write};
+
+use pretty_assertions::assert_eq;
+
+use super::*;
+
+const URI: &str = "file:///tmp/lsp%20test/doc.tardi";
+
+/// Runs the server on the messages and returns what it wrote.
+fn run(messages: &[Json]) -> Vec<Json> {
+    let mut input = Vec::new();
+    for message in messages {
+        let body = message.to_string();
+        write!(input, "Content-Length: {}\r\n\r\n{}", body.len(), body).unwrap();
+    }
+
+    let mut output = Vec::new();
+    let mut server = Server::new(Cursor::new(input), &mut output, Config::default());
+    let result = server.run();
+    assert!(result.is_ok(), "ERROR: {:?}", result);
+
+    let mut output = Cursor::new(output);
+    let mut reader = Server::new(&mut output, io::sink(), Config::default());
+    let mut messages = Vec::new();
+    while let Some(message) = reader.read_message().unwrap() {
+        messages.push(message);
+    }
+    messages
+}
+
+fn open(text: &str) -> Json {
+    json!({
+        "jsonrpc": "2.0",
+        "method": "textDocument/didOpen",
+        "params": {
+            "textDocument": { "uri": URI, "languageId": "tardi", "version": 1, "text": text },
+        },
+    })
+}
+
+fn request(id: usize, method: &str, line: usize, character: usize) -> Json {
+    json!({
+        "jsonrpc": "2.0",
+        "id": id,
+        "method": method,
+        "params": {
+            "textDocument": { "uri": URI },
+            "position": { "line": line, "character": character },
+        },
+    })
+}
+
+fn response(messages: &[Json], id: usize) -> Json {
+    messages
+        .iter()
+        .find(|message| message["id"] == id)
+        .map(|message| message["result"].clone())
+        .unwrap()
+}
+
+#[test]
+fn test_lsp_initialize_and_shutdown() {
+    let messages = run(&[
+        json!({ "jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {} }),
+        json!({ "jsonrpc": "2.0", "id": 2, "method": "shutdown" }),
+        json!({ "jsonrpc": "2.0", "id": 3, "method": "textDocument/hover", "params": {} }),
+        json!({ "jsonrpc": "2.0", "method": "exit" }),
+        json!({ "jsonrpc": "2.0", "id": 4, "method": "shutdown" }),
+    ]);
+
+    assert_eq!(messages.len(), 3);
+    let capabilities = &response(&messages, 1)["capabilities"];
+    assert_eq!(capabilities["hoverProvider"], json!(true));
+    assert_eq!(capabilities["definitionProvider"], json!(true));
+    assert_eq!(response(&messages, 2), Json::Null);
+    assert_eq!(messages[2]["error"]["code"], json!(INVALID_REQUEST));
+}
+
+#[test]
+fn test_lsp_publishes_diagnostics() {
+    let messages = run(&[open("1 2 +\n: bad ( a -- b c ) ;\n")]);
+    assert_eq!(messages.len(), 1);
+    assert_eq!(messages[0]["method"], "textDocument/publishDiagnostics");
+    assert_eq!(messages[0]["params"]["uri"], URI);
+
+    let diagnostics = messages[0]["params"]["diagnostics"].as_array().unwrap();
+    assert_eq!(diagnostics.len(), 1);
+    assert!(diagnostics[0]["message"]
+        .as_str()
+        .unwrap()
+        .starts_with("Stack effect mismatch"));
+    assert_eq!(diagnostics[0]["range"]["start"]["line"], json!(1));
+
+    let messages = run(&[open(": good ( a -- a a ) dup ;\n")]);
+    assert_eq!(messages[0]["params"]["diagnostics"], json!([]));
+}
+
+#[test]
+fn test_lsp_hover_shows_stack_effect_and_docs() {
+    let messages = run(&[
+        open("/// Squares a number.\n: square ( n -- n' ) dup * ;\n3 square\n4 [ 1 + ] keep\n"),
+        request(1, "textDocument/hover", 2, 3),
+        request(2, "textDocument/hover", 3, 11),
+        request(3, "textDocument/hover", 1, 21),
+        request(4, "textDocument/hover", 2, 0),
+    ]);
+
+    assert_eq!(
+        response(&messages, 1),
+        json!({
+            "contents": {
+                "kind": "markdown",
+                "value": "```tardi\n: square ( n -- n' )\n```\n\nSquares a number.",
+            },
+            "range": {
+                "start": { "line": 2, "character": 2 },
+                "end": { "line": 2, "character": 8 },
+            },
+        })
+    );
+    assert_eq!(
+        response(&messages, 2)["contents"]["value"],
+        "```tardi\n: keep\n```\n\n...x lambda -- ...x' x"
+    );
+    assert_eq!(
+        response(&messages, 3)["contents"]["value"],
+        "```tardi\ndup\n```\n\nA built-in word; it takes 1 value and leaves 2 values."
+    );
+    // Numbers aren't words.
+    assert_eq!(response(&messages, 4), Json::Null);
+}
+
+#[test]
+fn test_lsp_goes_to_definition() {
+    let messages = run(&[
+        open("3 square\n: square ( n -- n' ) dup * ;\n[ 2 square ] keep\n"),
+        request(1, "textDocument/definition", 2, 5),
+        request(2, "textDocument/definition", 2, 14),
+    ]);
+
+    assert_eq!(
+        response(&messages, 1),
+        json!({
+            "uri": URI,
+            "range": {
+                "start": { "line": 1, "character": 2 },
+                "end": { "line": 1, "character": 8 },
+            },
+        })
+    );
+    // The kernel doesn't have a file to go to.
+    assert_eq!(response(&messages, 2), Json::Null);
+}
+
+#[test]
+fn test_lsp_completes_words() {
+    let messages = run(&[
+        // `cube` is after a compile error.
+        open(": square ( n -- n' ) dup * ;\n: bad ( a -- b c ) ;\n: cube ( n -- n' ) dup square * ;\n"),
+        request(1, "textDocument/completion", 0, 0),
+    ]);
+
+    let items = response(&messages, 1);
+    let labels: Vec<&str> = items
+        .as_array()
+        .unwrap()
+        .iter()
+        .map(|item| item["label"].as_str().unwrap())
+        .collect();
+    assert!(labels.contains(&"square"));
+    assert!(labels.contains(&"cube"));
+    assert!(labels.contains(&"keep"));
+    assert!(labels.contains(&"dup"));
+}
+
+#[test]
+fn test_lsp_positions() {
+    let text = "ab\n\u{1F600}x é\n";
+    assert_eq!(position_of(text, 0), json!({ "line": 0, "character": 0 }));
+    assert_eq!(position_of(text, 3), json!({ "line": 1, "character": 0 }));
+    // The emoji is two UTF-16 code units and four bytes.
+    assert_eq!(position_of(text, 7), json!({ "line": 1, "character": 2 }));
+    assert_eq!(position_of(text, 9), json!({ "line": 1, "character": 4 }));
+
+    assert_eq!(offset_of(text, 0, 1), 1);
+    assert_eq!(offset_of(text, 1, 2), 7);
+    assert_eq!(offset_of(text, 1, 4), 9);
+    assert_eq!(offset_of(text, 0, 10), 2);
+    assert_eq!(offset_of(text, 5, 0), text.len());
+
+    let path = uri_to_path(URI).unwrap();
+    assert_eq!(path, PathBuf::from("/tmp/lsp test/doc.tardi"));
+    assert_eq!(path_to_uri(&path), URI);
+}
@@ -3,7 +3,9 @@ use clap_verbosity_flag::Verbosity;
 use human_panic::setup_panic;
 use std::fs;
 use std::path::PathBuf;
+use std::process;
 use tardi::config::{init_default_config, read_config_sources};
+use tardi::profiler::ReportFormat;
 
 use tardi::error::Result;
 
@@ -16,7 +18,14 @@ fn main() -> Result<()> {
         .init();
 
     // TODO: some way to edit config from the command line
-    let config = read_config_sources(&args.config.as_deref())?;
+    let mut config = read_config_sources(&args.config.as_deref())?;
+    config.strict_effects |= args.strict_effects;
+    if let Some(max_stack) = args.max_stack {
+        config.max_stack = max_stack;
+    }
+    if let Some(max_depth) = args.max_depth {
+        config.max_depth = max_depth;
+    }
     if let Some(history_dir) = config.repl.history_file.as_ref().and_then(|p| p.parent()) {
         fs::create_dir_all(history_dir)?;
     }
@@ -26,19 +35,71 @@ fn main() -> Result<()> {
     match args.command {
         Some(Commands::Evaluate { script_files }) => {
             for file in script_files {
-                tardi::run_file(&file, &config, args.print_stack)?;
+                tardi::run_file(&file, &[], &config, args.print_stack, None)?;
+            }
+            Ok(())
+        }
+        Some(Commands::Run {
+            file,
+            args: script_args,
+            profile,
+            profile_format,
+        }) => {
+            let profile = profile.then_some(profile_format);
+            tardi::run_file(&file, &script_args, &config, args.print_stack, profile)
+        }
+        Some(Commands::Test { files }) => {
+            let mut passed = true;
+            for file in files {
+                passed &= tardi::test_file(&file, &config)?;
+            }
+            if !passed {
+                process::exit(1);
+            }
+            Ok(())
+        }
+        Some(Commands::Debug { file, breakpoints }) => {
+            tardi::debug_file(&file, &breakpoints, &config)
+        }
+        Some(Commands::Compile { file, output }) => {
+            let output = tardi::compile_file(&file, output.as_deref(), &config)?;
+            println!("{}", output.display());
+            Ok(())
+        }
+        Some(Commands::Disasm { file, words }) => tardi::disassemble_file(&file, &words, &config),
+        Some(Commands::Fmt { check, files }) => {
+            let mut unformatted = false;
+            for file in files {
+                if !tardi::format_file(&file, check)? && check {
+                    eprintln!("{} is not formatted", file.display());
+                    unformatted = true;
+                }
+            }
+            if unformatted {
+                process::exit(1);
             }
             Ok(())
         }
         Some(Commands::Repl) => tardi::repl(&config),
+        Some(Commands::Lsp) => tardi::language_server(&config),
         Some(Commands::ConfigInit) => {
             let path = init_default_config()?;
             println!("{}", path.display());
             Ok(())
         }
         None => {
-            if let Some(file) = args.file {
-                tardi::run_file(&file, &config, args.print_stack)
+            if let Some(program) = args.eval {
+                // There's no file with `-e`, so the first positional is an
+                // argument too.
+                let script_args: Vec<String> = args
+                    .file
+                    .map(|file| file.to_string_lossy().into_owned())
+                    .into_iter()
+                    .chain(args.args)
+                    .collect();
+                tardi::run_str(&program, &script_args, &config, args.print_stack)
+            } else if let Some(file) = args.file {
+                tardi::run_file(&file, &args.args, &config, args.print_stack, None)
             } else {
                 tardi::repl(&config)
             }
@@ -56,15 +117,38 @@ struct Cli {
     #[arg(long)]
     print_stack: bool,
 
+    /// Fail to compile definitions whose declared stack effects can't be
+    /// checked, instead of warning about them.
+    #[arg(long)]
+    strict_effects: bool,
+
+    /// How many values the data stack can hold.
+    #[arg(long, value_name = "N")]
+    max_stack: Option<usize>,
+
+    /// How deep calls can nest before failing with "call stack exhausted".
+    #[arg(long, value_name = "N")]
+    max_depth: Option<usize>,
+
     /// The location of the configuration file.
     #[arg(short, long)]
     config: Option<PathBuf>,
 
+    /// Run this program instead of a file, like `tardi -e '1 2 + println'`.
+    /// The arguments after it are passed to the program.
+    #[arg(short = 'e', long = "eval", value_name = "PROGRAM")]
+    eval: Option<String>,
+
     #[command(subcommand)]
     command: Option<Commands>,
 
     /// The Tardi source file to execute
     file: Option<PathBuf>,
+
+    /// Arguments for the script, which it can get with `argv`. Put them
+    /// after `--` if they look like options.
+    #[arg(trailing_var_arg = true, allow_hyphen_values = true)]
+    args: Vec<String>,
 }
 
 #[derive(Debug, Parser)]
@@ -75,9 +159,84 @@ enum Commands {
         script_files: Vec<PathBuf>,
     },
 
+    /// Run a script, passing it the arguments after it.
+    Run {
+        /// Count how often each word runs and how long it takes, and print
+        /// a report of them to stderr when the program exits.
+        #[arg(long)]
+        profile: bool,
+
+        /// How to write the profile: `text`, `csv`, or `json`.
+        #[arg(long, value_name = "FORMAT", default_value = "text")]
+        profile_format: ReportFormat,
+
+        /// The file to run.
+        file: PathBuf,
+
+        /// Arguments for the script, which it can get with `argv`.
+        #[arg(trailing_var_arg = true, allow_hyphen_values = true)]
+        args: Vec<String>,
+    },
+
+    /// Run the tests in one or more files and report which failed.
+    Test {
+        /// The files to test.
+        files: Vec<PathBuf>,
+    },
+
+    /// Run a script in the step debugger. It pauses before the first
+    /// instruction, or at the first breakpoint if there are any.
+    Debug {
+        /// The file to debug.
+        file: PathBuf,
+
+        /// Pause before each call to this word. This can be given more
+        /// than once.
+        #[arg(long = "break", value_name = "WORD")]
+        breakpoints: Vec<String>,
+    },
+
+    /// Compile a file to a `.tardic` cache. Running the file uses the
+    /// cache until the file or any module it uses changes.
+    Compile {
+        /// The file to compile.
+        file: PathBuf,
+
+        /// Where to write the cache. This defaults to the file with a
+        /// `.tardic` extension. Caches elsewhere can be run directly.
+        #[arg(short, long)]
+        output: Option<PathBuf>,
+    },
+
+    /// Print the bytecode that a file's words compile to, without running
+    /// it.
+    Disasm {
+        /// The file to compile.
+        file: PathBuf,
+
+        /// Only print these words. They can also be words that the file
+        /// uses from other modules.
+        words: Vec<String>,
+    },
+
+    /// Format files in place.
+    Fmt {
+        /// Don't change the files. Instead, list the ones that aren't
+        /// formatted and exit with an error if there are any.
+        #[arg(long)]
+        check: bool,
+
+        /// The files to format.
+        files: Vec<PathBuf>,
+    },
+
     /// Run a REPL to execute Tardi interactively.
     Repl,
 
+    /// Run a language server for editors, which speaks LSP over stdin and
+    /// stdout.
+    Lsp,
+
     /// Initialize configuration by outputting a default configuration
     /// and printing where it was output.
     ConfigInit,
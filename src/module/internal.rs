@@ -1,12 +1,20 @@
 use std::collections::HashMap;
 
+use coroutines::{CoroutinesModule, COROUTINES};
 use fs::{FsModule, FS};
 use internals::{InternalsModule, INTERNALS};
 use io::{IoModule, IO};
+use json::{JsonModule, JSON};
 use kernel::{KernelModule, KERNEL};
+use math::{MathBuilder, MATH};
+use os::{OsModule, OS};
+// `self::` because the `regex` crate has the same name.
+use self::regex::{RegexModule, REGEX};
 use sandbox::{SandboxBuilder, SANDBOX};
 use scanning::{ScanningBuilder, SCANNING};
 use strings::{StringsBuilder, STRINGS};
+use testing::{TestingModule, TESTING};
+use time::{TimeModule, TIME};
 use vectors::{VectorsBuilder, VECTORS};
 
 use crate::compiler::error::CompilerError;
@@ -18,14 +26,21 @@ use crate::vm::VM;
 
 use super::{Module, ModuleManager};
 
+pub mod coroutines;
 pub mod fs;
 pub mod hashmaps;
 pub mod internals;
 pub mod io;
+pub mod json;
 pub mod kernel;
+pub mod math;
+pub mod os;
+pub mod regex;
 pub mod sandbox;
 pub mod scanning;
 pub mod strings;
+pub mod testing;
+pub mod time;
 pub mod vectors;
 
 pub fn define_module(
@@ -34,14 +49,21 @@ pub fn define_module(
     op_table: &mut Vec<Shared<Lambda>>,
 ) -> Result<Module> {
     let builder: Box<dyn InternalBuilder> = match name {
+        COROUTINES => Box::new(CoroutinesModule),
         FS => Box::new(FsModule),
         HASHMAPS => Box::new(HashMapsBuilder),
         INTERNALS => Box::new(InternalsModule),
         IO => Box::new(IoModule),
+        JSON => Box::new(JsonModule),
         KERNEL => Box::new(KernelModule),
+        MATH => Box::new(MathBuilder),
+        OS => Box::new(OsModule),
+        REGEX => Box::new(RegexModule),
         SANDBOX => Box::new(SandboxBuilder),
         SCANNING => Box::new(ScanningBuilder),
         STRINGS => Box::new(StringsBuilder),
+        TESTING => Box::new(TestingModule),
+        TIME => Box::new(TimeModule),
         VECTORS => Box::new(VectorsBuilder),
         _ => return Err(CompilerError::ModuleNotFound(name.to_string()).into()),
     };
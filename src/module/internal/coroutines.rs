@@ -0,0 +1,69 @@
+use std::collections::{HashMap, HashSet};
+
+use crate::error::Result;
+use crate::module::Module;
+use crate::shared::shared;
+use crate::value::ValueData;
+use crate::vm::VM;
+use crate::{compiler::Compiler, error::VMError};
+
+use super::{push_op, InternalBuilder};
+
+pub const COROUTINES: &str = "std/_coroutines";
+
+pub struct CoroutinesModule;
+impl InternalBuilder for CoroutinesModule {
+    fn define_module(
+        &self,
+        _module_manager: &crate::module::ModuleManager,
+        op_table: &mut Vec<crate::shared::Shared<crate::value::lambda::Lambda>>,
+    ) -> crate::module::Module {
+        let mut index = HashMap::new();
+
+        push_op(op_table, &mut index, "<coroutine>", coroutine);
+        push_op(op_table, &mut index, "<resume>", resume);
+        push_op(op_table, &mut index, "<resume-end>", resume_end);
+        push_op(op_table, &mut index, "yield", yield_value);
+        push_op(op_table, &mut index, "done?", is_done);
+
+        Module {
+            imported: HashMap::new(),
+            path: None,
+            name: COROUTINES.to_string(),
+            defined: index,
+            exported: HashSet::new(),
+        }
+    }
+}
+
+/// lambda -- coroutine
+fn coroutine(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.coroutine()
+}
+
+/// coroutine --
+fn resume(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
+    vm.resume(compiler)
+}
+
+/// -- value coroutine
+fn resume_end(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.resume_end()
+}
+
+/// value --
+fn yield_value(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.yield_value()
+}
+
+/// coroutine -- ?
+fn is_done(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let value = vm.pop()?;
+    let done = value
+        .borrow()
+        .data
+        .as_coroutine()
+        .map(|coroutine| coroutine.is_done())
+        .ok_or_else(|| VMError::TypeMismatch("done? coroutine".to_string()))?;
+    vm.push(shared(ValueData::Boolean(done).into()))
+}
@@ -1,4 +1,5 @@
 use std::collections::{HashMap, HashSet};
+use std::path::Path;
 use std::{fs, io};
 
 use crate::error::Result;
@@ -23,6 +24,7 @@ impl InternalBuilder for FsModule {
         push_op(op_table, &mut index, "rm", rm);
         push_op(op_table, &mut index, "truncate", truncate);
         push_op(op_table, &mut index, "exists?", does_file_exist);
+        push_op(op_table, &mut index, "file-exists?", is_file);
         push_op(op_table, &mut index, "rmdir", rmdir);
         push_op(op_table, &mut index, "ensure-dir", ensure_dir);
         push_op(op_table, &mut index, "touch", touch);
@@ -80,6 +82,21 @@ fn does_file_exist(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     vm.push(shared(exists.into()))
 }
 
+/// path -- ?
+/// Returns `#t` if `path` is a file, and `#f` if it's missing or something
+/// else, like a directory.
+fn is_file(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let path = vm.pop()?;
+    let path = path.borrow();
+    let path = path
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("file-exists? path must be string".to_string()))?;
+
+    let is_file = Path::new(path).is_file();
+
+    vm.push(shared(is_file.into()))
+}
+
 /// path -- ?
 /// Returns `#t` if it removes the directory, `#f` if not.
 fn rmdir(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
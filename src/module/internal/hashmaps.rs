@@ -1,6 +1,8 @@
 use std::collections::{HashMap, HashSet};
 use std::convert::TryInto;
 
+use indexmap::IndexMap;
+
 use crate::compiler::Compiler;
 use crate::error::{Result, VMError};
 
@@ -34,6 +36,7 @@ impl InternalBuilder for HashMapsBuilder {
         push_op(op_table, &mut index, "get", get);
         push_op(op_table, &mut index, "add!", add);
         push_op(op_table, &mut index, "set!", set);
+        push_op(op_table, &mut index, "remove!", remove);
 
         Module {
             imported: HashMap::new(),
@@ -47,7 +50,7 @@ impl InternalBuilder for HashMapsBuilder {
 
 // <hashmap> ( -- hashmap )
 fn hashmap(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
-    let hashmap = HashMap::new();
+    let hashmap = IndexMap::new();
     let value_data = ValueData::HashMap(hashmap);
 
     vm.push(shared(value_data.into()))
@@ -82,7 +85,7 @@ fn to_hashmap(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
         .as_list()
         .ok_or_else(|| VMError::TypeMismatch(">hashmap expects a vector".to_string()))?;
 
-    let mut hashmap = HashMap::new();
+    let mut hashmap = IndexMap::new();
     for pair in vector.iter() {
         let (key, value) = parse_vector_pair(">hashmap", pair)?;
         hashmap.insert(key, value.clone());
@@ -196,3 +199,26 @@ fn set(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
 
     Ok(())
 }
+
+// remove! ( key hashmap -- )
+// Uses `shift_remove` so the remaining keys keep their insertion order.
+fn remove(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let popped = vm.pop()?;
+    if !popped.borrow().data.is_hash_map() {
+        return Err(VMError::TypeMismatch(format!(
+            "hashmaps/remove! expects a hashmap: {}",
+            popped.borrow().to_repr()
+        ))
+        .into());
+    }
+
+    let key = vm.pop()?;
+    let key: FrozenValueData = key.borrow().data.clone().try_into()?;
+
+    let mut hashmap = popped.borrow_mut();
+    if let Some(hashmap) = hashmap.data.as_hash_map_mut() {
+        let _ = hashmap.shift_remove(&key);
+    }
+
+    Ok(())
+}
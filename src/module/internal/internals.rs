@@ -1,10 +1,12 @@
 use std::collections::{HashMap, HashSet};
 
+use crate::compiler::effects::StackEffect;
 use crate::compiler::Compiler;
-use crate::error::Result;
+use crate::error::{Result, VMError};
 use crate::module::{Module, ModuleManager};
-use crate::shared::Shared;
+use crate::shared::{shared, Shared};
 use crate::value::lambda::Lambda;
+use crate::value::{Keyword, Record, SharedValue, Value, ValueData};
 use crate::vm::VM;
 
 use super::{push_op, InternalBuilder};
@@ -27,6 +29,14 @@ impl InternalBuilder for InternalsModule {
             "<predeclare-function>",
             predeclare_function,
         );
+        push_op(op_table, &mut index, "<scan-effect>", scan_effect);
+        push_op(op_table, &mut index, "<scan-locals>", scan_locals);
+        push_op(op_table, &mut index, "<compile-locals>", compile_locals);
+        push_op(op_table, &mut index, "<record>", record);
+        push_op(op_table, &mut index, "<record-new>", record_new);
+        push_op(op_table, &mut index, "<record?>", is_record);
+        push_op(op_table, &mut index, "<record-slot>", record_slot);
+        push_op(op_table, &mut index, "<set-record-slot>", set_record_slot);
 
         Module {
             imported: HashMap::new(),
@@ -38,10 +48,207 @@ impl InternalBuilder for InternalsModule {
     }
 }
 
-fn function(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+fn function(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
+    if let Some(lambda) = vm.peek() {
+        compiler.check_effect(&lambda.borrow())?;
+    }
     vm.function()
 }
 
 fn predeclare_function(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     vm.predeclare_function()
 }
+
+/// Reads the optional stack effect after the name of a definition, which is
+/// on the top of the stack.
+fn scan_effect(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
+    let name = vm.peek().ok_or(VMError::StackUnderflow)?.borrow().clone();
+    compiler.scan_effect(&name)
+}
+
+fn scan_locals(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
+    let name = vm.peek().ok_or(VMError::StackUnderflow)?.borrow().clone();
+    compiler.scan_locals(&name)
+}
+
+fn compile_locals(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
+    vm.compile_locals(compiler)
+}
+
+/// Defines the words for `record: point x y ;`. The name and the vector of
+/// slot names are on the stack. This defines the constructor `<point>`, which
+/// takes a value for each slot, the predicate `point?`, and `point-x` and
+/// `set-point-x` for each slot.
+fn record(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
+    let slots = vm.pop()?;
+    let name = vm.pop()?;
+    let name = name.borrow().clone();
+    let (module, kind) = name
+        .as_symbol()
+        .map(|(module, word)| (module.to_string(), word.to_string()))
+        .ok_or_else(|| VMError::TypeMismatch(format!("record name: {}", name)))?;
+    let slots = slots
+        .borrow()
+        .as_list()
+        .ok_or_else(|| VMError::TypeMismatch("record slots".to_string()))?
+        .iter()
+        .map(|slot| {
+            let slot = slot.borrow();
+            slot.data
+                .get_word()
+                .map(String::from)
+                .ok_or_else(|| VMError::TypeMismatch(format!("record slot: {}", slot)))
+        })
+        .collect::<std::result::Result<Vec<_>, _>>()?;
+
+    let env = vm.environment.clone().ok_or(VMError::MissingEnvironment)?;
+    let tag = Value::new(ValueData::Keyword(env.borrow_mut().intern(&kind)));
+    // The bodies call the words below directly, so they work in any module.
+    let call = |word: &str| {
+        Value::new(ValueData::Symbol {
+            module: INTERNALS.to_string(),
+            word: word.to_string(),
+        })
+    };
+
+    let mut words = vec![
+        (
+            format!("<{}>", kind),
+            vec![
+                tag.clone(),
+                Value::from(slots.len() as i64),
+                call("<record-new>"),
+            ],
+            StackEffect::new(slots.len(), 1),
+        ),
+        (
+            format!("{}?", kind),
+            vec![tag.clone(), call("<record?>")],
+            StackEffect::new(1, 1),
+        ),
+    ];
+    for (i, slot) in slots.iter().enumerate() {
+        let i = Value::from(i as i64);
+        words.push((
+            format!("{}-{}", kind, slot),
+            vec![tag.clone(), i.clone(), call("<record-slot>")],
+            StackEffect::new(1, 1),
+        ));
+        words.push((
+            format!("set-{}-{}", kind, slot),
+            vec![tag.clone(), i, call("<set-record-slot>")],
+            StackEffect::new(2, 0),
+        ));
+    }
+
+    for (word, body, effect) in words {
+        let lambda = compiler.compile_list(vm, &body)?;
+        vm.push(shared(Value {
+            data: ValueData::Symbol {
+                module: module.clone(),
+                word: word.clone(),
+            },
+            lexeme: Some(word.clone()),
+            ..name.clone()
+        }))?;
+        vm.push(shared(Value::new(ValueData::Function(lambda))))?;
+        vm.function()?;
+        if let Some(op_index) = env.borrow().get_op_index(&module, &word) {
+            compiler.declare_word_effect(op_index, effect);
+        }
+    }
+
+    Ok(())
+}
+
+fn pop_kind(vm: &mut VM) -> Result<Keyword> {
+    let kind = vm.pop()?;
+    let kind = kind.borrow();
+    kind.data
+        .as_keyword()
+        .cloned()
+        .ok_or_else(|| VMError::TypeMismatch(format!("record type: {}", kind)).into())
+}
+
+fn pop_slot(vm: &mut VM) -> Result<usize> {
+    let i = vm.pop()?;
+    let i = i.borrow();
+    i.as_integer()
+        .map(|i| i as usize)
+        .ok_or_else(|| VMError::TypeMismatch(format!("record slot: {}", i)).into())
+}
+
+/// The error for finding `value` where a `kind` record should be.
+fn wrong_record(kind: &Keyword, value: &Value) -> VMError {
+    let actual = match value.data.as_record() {
+        Some(record) => record.name().to_string(),
+        None => value.data.type_name().to_string(),
+    };
+    VMError::WrongRecordType(kind.name().to_string(), actual)
+}
+
+/// <record-new> ( ...slots kind n -- record )
+fn record_new(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let n = pop_slot(vm)?;
+    let kind = pop_kind(vm)?;
+    if vm.stack_size() < n {
+        return Err(VMError::StackUnderflow.into());
+    }
+    let slots = vm.stack.split_off(vm.stack_size() - n);
+    vm.push(shared(ValueData::Record(Record::new(kind, slots)).into()))
+}
+
+/// <record?> ( value kind -- ? )
+fn is_record(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let kind = pop_kind(vm)?;
+    let value = vm.pop()?;
+    let is_kind = value
+        .borrow()
+        .data
+        .as_record()
+        .is_some_and(|record| record.kind == kind);
+    vm.push(shared(Value::from(is_kind)))
+}
+
+/// <record-slot> ( record kind i -- value )
+fn record_slot(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let i = pop_slot(vm)?;
+    let kind = pop_kind(vm)?;
+    let value = vm.pop()?;
+    let value = value.borrow();
+    let slots = &value
+        .data
+        .as_record()
+        .filter(|record| record.kind == kind)
+        .ok_or_else(|| wrong_record(&kind, &value))?
+        .slots;
+    let slot: SharedValue = slots
+        .get(i)
+        .cloned()
+        .ok_or(VMError::IndexOutOfBounds(i as i64, slots.len()))?;
+    vm.push(slot)
+}
+
+/// <set-record-slot> ( x record kind i -- )
+fn set_record_slot(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let i = pop_slot(vm)?;
+    let kind = pop_kind(vm)?;
+    let value = vm.pop()?;
+    let x = vm.pop()?;
+    let mut value = value.borrow_mut();
+    if !value
+        .data
+        .as_record()
+        .is_some_and(|record| record.kind == kind)
+    {
+        return Err(wrong_record(&kind, &value).into());
+    }
+    if let Some(slot) = value
+        .data
+        .as_record_mut()
+        .and_then(|record| record.slots.get_mut(i))
+    {
+        *slot = x;
+    }
+    Ok(())
+}
@@ -1,20 +1,24 @@
 use std::collections::{HashMap, HashSet};
+use std::convert::TryFrom;
 use std::io::{Read, Write};
-use std::path::PathBuf;
+use std::path::{Path, PathBuf};
 use std::str::FromStr;
 use std::{fs, io};
 
 use crate::compiler::Compiler;
-use crate::error::Result;
+use crate::env::Environment;
 use crate::error::VMError;
+use crate::error::{Error, Result};
 use crate::module::Module;
 use crate::shared::shared;
-use crate::value::{TardiReader, TardiWriter, ValueData};
-use crate::vm::VM;
+use crate::value::frozen::FrozenValueData;
+use crate::value::pretty::{pretty, PrettyOptions};
+use crate::value::{TardiReader, TardiWriter, Value, ValueData};
+use crate::vm::{OutputFrame, VM};
 
 use super::{push_false, push_op, push_true, InternalBuilder};
 
-pub const IO: &str = "std/io";
+pub const IO: &str = "std/_io";
 
 pub struct IoModule;
 impl InternalBuilder for IoModule {
@@ -27,6 +31,7 @@ impl InternalBuilder for IoModule {
 
         push_op(op_table, &mut index, "write-file", write_file);
         push_op(op_table, &mut index, "read-file", read_file);
+        push_op(op_table, &mut index, "file-lines", file_lines);
         push_op(op_table, &mut index, "<writer>", writer);
         push_op(op_table, &mut index, "<reader>", reader);
         push_op(op_table, &mut index, "file-path>>", get_file_path);
@@ -48,6 +53,8 @@ impl InternalBuilder for IoModule {
         push_op(op_table, &mut index, "print", print);
         push_op(op_table, &mut index, "println", println);
         push_op(op_table, &mut index, "nl", nl);
+        push_op(op_table, &mut index, "<push-output-file>", push_output_file);
+        push_op(op_table, &mut index, "<pop-output>", pop_output);
 
         push_op(op_table, &mut index, "eprint", eprint);
         push_op(op_table, &mut index, "eprintln", eprintln);
@@ -56,6 +63,13 @@ impl InternalBuilder for IoModule {
         push_op(op_table, &mut index, ".", dot);
         push_op(op_table, &mut index, ".s", dot_stack);
 
+        push_op(op_table, &mut index, "stdin-line", stdin_line);
+
+        push_op(op_table, &mut index, "pprint", pprint);
+        push_op(op_table, &mut index, "pprint-with", pprint_with);
+
+        push_op(op_table, &mut index, "see", see);
+
         Module {
             imported: HashMap::new(),
             path: None,
@@ -66,7 +80,13 @@ impl InternalBuilder for IoModule {
     }
 }
 
-/// contents path -- result-flag
+/// The error for something going wrong with the file at `path`. This
+/// carries the path, since the OS's message doesn't.
+fn file_error(path: &str, err: io::Error) -> VMError {
+    VMError::FileError(path.to_string(), err.to_string())
+}
+
+/// contents path --
 fn write_file(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let path = vm.pop()?;
     let path = path.borrow();
@@ -79,13 +99,12 @@ fn write_file(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
         .as_string()
         .ok_or_else(|| VMError::TypeMismatch("write-file contents must be string".to_string()))?;
 
-    // TODO: needs to propagate errors
-    fs::write(path, contents)?;
+    fs::write(path, contents).map_err(|err| file_error(path, err))?;
 
-    push_true(vm)
+    Ok(())
 }
 
-/// path -- contents result-flag
+/// path -- contents
 fn read_file(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let path = vm.pop()?;
     let path = path.borrow();
@@ -93,11 +112,23 @@ fn read_file(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
         .as_string()
         .ok_or_else(|| VMError::TypeMismatch("read-file path must be string".to_string()))?;
 
-    // TODO: needs to propagate errors
-    let contents = fs::read_to_string(path)?;
+    let contents = fs::read_to_string(path).map_err(|err| file_error(path, err))?;
 
-    vm.push(shared(contents.into()))?;
-    push_true(vm)
+    vm.push(shared(contents.into()))
+}
+
+/// path -- line-vector
+fn file_lines(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let path = vm.pop()?;
+    let path = path.borrow();
+    let path = path
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("file-lines path must be string".to_string()))?;
+
+    let contents = fs::read_to_string(path).map_err(|err| file_error(path, err))?;
+    let lines: Vec<Value> = contents.lines().map(Value::from).collect();
+
+    vm.push(shared(lines.into()))
 }
 
 /// path -- writer
@@ -313,6 +344,27 @@ fn stdin(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     vm.push(shared(ValueData::Reader(reader).into()))
 }
 
+/// -- line ?
+///
+/// Reads the next line of stdin without its line ending. At the end of
+/// stdin, this is an empty string and `#f`.
+fn stdin_line(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let mut line = TardiReader::Stdin.read_line()?;
+    if line.is_empty() {
+        vm.push(shared(line.into()))?;
+        return push_false(vm);
+    }
+
+    if line.ends_with('\n') {
+        line.pop();
+        if line.ends_with('\r') {
+            line.pop();
+        }
+    }
+    vm.push(shared(line.into()))?;
+    push_true(vm)
+}
+
 /// -- <stdout>
 fn stdout(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let writer = TardiWriter::Stdout;
@@ -339,26 +391,69 @@ fn flush_stderr() -> Result<()> {
     Ok(())
 }
 
+/// Writes to the current output, which is stdout unless something like
+/// `with-output-file` has redirected it.
+fn write_output(vm: &mut VM, text: &str) -> Result<()> {
+    match vm.outputs.last_mut() {
+        Some(output) => output.writer.write_all(text.as_bytes())?,
+        None => {
+            print!("{}", text);
+            flush_stdout()?;
+        }
+    }
+    Ok(())
+}
+
 /// object --
 fn print(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let object = vm.pop()?;
-
-    print!("{}", object.borrow());
-    flush_stdout()
+    let text = object.borrow().to_string();
+    write_output(vm, &text)
 }
 
 /// object --
 fn println(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let object = vm.pop()?;
+    let text = format!("{}\n", object.borrow());
+    write_output(vm, &text)
+}
 
-    println!("{}", object.borrow());
-    flush_stdout()
+/// --
+fn nl(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    write_output(vm, "\n")
+}
+
+/// path --
+///
+/// Sends the output of `print` and friends to the file at `path` until the
+/// matching `<pop-output>`. Unwinding to a `catch`, or returning or breaking
+/// out of the word that called this, also restores it.
+fn push_output_file(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let path = vm.pop()?;
+    let path = path.borrow();
+    let path = path.as_string().ok_or_else(|| {
+        VMError::TypeMismatch("<push-output-file> path must be string".to_string())
+    })?;
+
+    let writer = TardiWriter::create(Path::new(path)).map_err(|err| match err {
+        Error::IoError(err) => file_error(path, err).into(),
+        err => err,
+    })?;
+    let return_depth = vm.return_stack.len();
+    vm.outputs.push(OutputFrame {
+        writer,
+        return_depth,
+    });
+
+    Ok(())
 }
 
 /// --
-fn nl(_vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
-    println!();
-    flush_stdout()
+fn pop_output(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    if let Some(mut output) = vm.outputs.pop() {
+        output.writer.flush()?;
+    }
+    Ok(())
 }
 
 /// object --
@@ -384,14 +479,126 @@ fn enl(_vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
 /// object --
 fn dot(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let object = vm.pop()?;
-    println!("{}", object.borrow().to_repr());
-    flush_stdout()
+    let text = format!("{}\n", object.borrow().to_repr());
+    write_output(vm, &text)
+}
+
+/// object --
+fn pprint(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let object = vm.pop()?;
+    let text = format!("{}\n", pretty(&object, &PrettyOptions::default()));
+    write_output(vm, &text)
+}
+
+/// object options --
+fn pprint_with(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let options = vm.pop()?;
+    let options = pretty_options(&options.borrow())?;
+    let object = vm.pop()?;
+    let text = format!("{}\n", pretty(&object, &options));
+    write_output(vm, &text)
+}
+
+/// word --
+///
+/// Prints the bytecode that a word compiled to. `word` is a quotation that
+/// calls it, like `[ square ]`, or its name as a string or symbol. Names
+/// that are in more than one module need the module, like `std/_io::print`.
+/// Any other quotation has its own bytecode printed.
+fn see(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let word = vm.pop()?;
+    let env = vm.environment.clone().ok_or(VMError::MissingEnvironment)?;
+    let env = env.borrow();
+
+    let index = match &word.borrow().data {
+        ValueData::Function(quotation) => match env.quoted_word(quotation) {
+            Some(index) => index,
+            None => {
+                let text = env.disassemble(None, quotation);
+                return write_output(vm, &text);
+            }
+        },
+        ValueData::String(name) => find_word(&env, name)?,
+        ValueData::Keyword(keyword) => find_word(&env, keyword.name())?,
+        _ => {
+            return Err(VMError::TypeMismatch(format!(
+                "see needs a quotation, string, or symbol: {}",
+                word.borrow()
+            ))
+            .into())
+        }
+    };
+
+    let lambda = env.get_op(&vm.ip, index)?;
+    let name = env.word_name(index);
+    let text = env.disassemble(name.as_deref(), &lambda.borrow());
+    write_output(vm, &text)
+}
+
+fn find_word(env: &Environment, name: &str) -> Result<usize> {
+    let found = env.find_word(name);
+    match found.as_slice() {
+        [] => Err(VMError::UnknownWord(name.to_string()).into()),
+        [(_, index)] => Ok(*index),
+        _ => {
+            let modules: Vec<_> = found.iter().map(|(module, _)| module.as_str()).collect();
+            Err(VMError::AmbiguousWord(name.to_string(), modules.join(", ")).into())
+        }
+    }
+}
+
+/// Reads the options for `pprint-with`. They're a hashmap that can have
+/// `:width` and `:depth`, which are both integers.
+fn pretty_options(options: &Value) -> Result<PrettyOptions> {
+    let pairs = options.data.as_hash_map().ok_or_else(|| {
+        VMError::TypeMismatch(format!(
+            "pprint-with options must be a hashmap: {}",
+            options
+        ))
+    })?;
+
+    let mut pretty_options = PrettyOptions::default();
+    for (key, value) in pairs.iter() {
+        let value = value.borrow();
+        let n = value
+            .as_integer()
+            .and_then(|n| usize::try_from(n).ok())
+            .ok_or_else(|| {
+                VMError::TypeMismatch(format!(
+                    "pprint-with option {} must be a non-negative integer: {}",
+                    key.to_repr(),
+                    value.to_repr()
+                ))
+            })?;
+        match key {
+            FrozenValueData::Keyword(keyword) if keyword.name() == "width" => {
+                pretty_options.width = n
+            }
+            FrozenValueData::Keyword(keyword) if keyword.name() == "depth" => {
+                pretty_options.depth = Some(n)
+            }
+            _ => {
+                return Err(VMError::TypeMismatch(format!(
+                    "unknown pprint-with option: {}",
+                    key.to_repr()
+                ))
+                .into())
+            }
+        }
+    }
+
+    Ok(pretty_options)
 }
 
 /// ...s -- ...s
+///
+/// Prints the whole stack, bottom to top, with the pretty-printer.
 fn dot_stack(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
-    for value in vm.stack.iter() {
-        println!("{}", value.borrow().to_repr());
-    }
-    flush_stdout()
+    let options = PrettyOptions::default();
+    let text: String = vm
+        .stack
+        .iter()
+        .map(|value| format!("{}\n", pretty(value, &options)))
+        .collect();
+    write_output(vm, &text)
 }
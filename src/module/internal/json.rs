@@ -0,0 +1,138 @@
+use std::collections::{HashMap, HashSet};
+
+use indexmap::IndexMap;
+use serde_json::{Map, Number, Value as Json};
+
+use crate::error::Result;
+use crate::module::Module;
+use crate::shared::shared;
+use crate::value::frozen::FrozenValueData;
+use crate::value::{Value, ValueData};
+use crate::vm::VM;
+use crate::{compiler::Compiler, error::VMError};
+
+use super::{push_op, InternalBuilder};
+
+pub const JSON: &str = "std/json";
+
+pub struct JsonModule;
+impl InternalBuilder for JsonModule {
+    fn define_module(
+        &self,
+        _module_manager: &crate::module::ModuleManager,
+        op_table: &mut Vec<crate::shared::Shared<crate::value::lambda::Lambda>>,
+    ) -> crate::module::Module {
+        let mut index = HashMap::new();
+
+        push_op(op_table, &mut index, "json-parse", json_parse);
+        push_op(op_table, &mut index, "json-serialize", json_serialize);
+
+        Module {
+            imported: HashMap::new(),
+            path: None,
+            name: JSON.to_string(),
+            defined: index,
+            exported: HashSet::new(),
+        }
+    }
+}
+
+/// How deeply arrays and objects can nest. This is the same as serde_json's
+/// limit for parsing, and it keeps serializing a vector that contains itself
+/// from recursing forever.
+const MAX_DEPTH: usize = 128;
+
+/// string -- value
+///
+/// Objects become hashmaps, arrays become vectors, and numbers become
+/// integers if they can. There isn't a nil, so `null` becomes `#f`.
+fn json_parse(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let input = vm.pop()?;
+    let input = input.borrow();
+    let input = input
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("json-parse string".to_string()))?;
+
+    let json: Json =
+        serde_json::from_str(input).map_err(|err| VMError::InvalidJson(err.to_string()))?;
+    vm.push(shared(from_json(json)))
+}
+
+/// value -- string
+///
+/// Object keys come out in insertion order, so the same hashmap always
+/// serializes the same way.
+fn json_serialize(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let value = vm.pop()?;
+    let json = to_json(&value.borrow().data, 0)?;
+    vm.push(shared(json.to_string().into()))
+}
+
+fn from_json(json: Json) -> Value {
+    let data = match json {
+        Json::Null => ValueData::Boolean(false),
+        Json::Bool(b) => ValueData::Boolean(b),
+        // Integers too big for an i64 are kept as floats.
+        Json::Number(n) => match n.as_i64() {
+            Some(n) => ValueData::Integer(n),
+            None => ValueData::Float(n.as_f64().unwrap_or(f64::NAN)),
+        },
+        Json::String(s) => ValueData::String(s),
+        Json::Array(items) => ValueData::List(
+            items
+                .into_iter()
+                .map(|item| shared(from_json(item)))
+                .collect(),
+        ),
+        Json::Object(map) => ValueData::HashMap(
+            map.into_iter()
+                .map(|(key, value)| (FrozenValueData::String(key), shared(from_json(value))))
+                .collect::<IndexMap<_, _>>(),
+        ),
+    };
+    Value::new(data)
+}
+
+fn to_json(value: &ValueData, depth: usize) -> Result<Json> {
+    let json = match value {
+        ValueData::Integer(n) => Json::from(*n),
+        // This always has a decimal point or exponent, so it reads back as a
+        // float.
+        ValueData::Float(n) => Number::from_f64(*n)
+            .map(Json::Number)
+            .ok_or_else(|| VMError::TypeMismatch(format!("json-serialize {}", n)))?,
+        ValueData::Boolean(b) => Json::Bool(*b),
+        ValueData::Char(c) => Json::String(c.to_string()),
+        ValueData::String(s) => Json::String(s.clone()),
+        ValueData::List(_) | ValueData::HashMap(_) if depth >= MAX_DEPTH => {
+            return Err(VMError::InvalidJson(format!("nested more than {} deep", MAX_DEPTH)).into())
+        }
+        ValueData::List(items) => Json::Array(
+            items
+                .iter()
+                .map(|item| to_json(&item.borrow().data, depth + 1))
+                .collect::<Result<_>>()?,
+        ),
+        ValueData::HashMap(map) => {
+            let mut object = Map::new();
+            for (key, value) in map.iter() {
+                let key = match key {
+                    FrozenValueData::String(key) => key.clone(),
+                    key => {
+                        return Err(VMError::TypeMismatch(format!(
+                            "json-serialize key {}",
+                            key.to_repr()
+                        ))
+                        .into())
+                    }
+                };
+                object.insert(key, to_json(&value.borrow().data, depth + 1)?);
+            }
+            Json::Object(object)
+        }
+        value => {
+            return Err(VMError::TypeMismatch(format!("json-serialize {}", value.to_repr())).into())
+        }
+    };
+    Ok(json)
+}
@@ -26,6 +26,8 @@ impl InternalBuilder for KernelModule {
 
         // TODO: align this with the primitives in jones forth. what other primitives
         // would i need to implement more in forth/tardi?
+        // The op table is indexed by `OpCode`, so these have to stay in the
+        // same order as the enum.
         push_op(op_table, &mut index, "<nop>", nop);
         push_op(op_table, &mut index, "<lit>", lit);
         push_op(op_table, &mut index, "dup", dup);
@@ -56,9 +58,22 @@ impl InternalBuilder for KernelModule {
         push_op(op_table, &mut index, "compile", compile);
         push_op(op_table, &mut index, "break", break_word);
         push_op(op_table, &mut index, "continue", continue_word);
+        push_op(op_table, &mut index, "<tail-call>", tail_call);
+        push_op(op_table, &mut index, "<tail-apply>", tail_apply);
+        push_op(op_table, &mut index, "<load-local>", load_local);
+        push_op(op_table, &mut index, "<store-local>", store_local);
+        push_op(op_table, &mut index, "call", apply);
+        push_op(op_table, &mut index, "depth", stack_size);
+        push_op(op_table, &mut index, "<catch-begin>", catch_begin);
+        push_op(op_table, &mut index, "<catch-end>", catch_end);
+        push_op(op_table, &mut index, "throw", throw);
+        push_op(op_table, &mut index, "breakpoint", breakpoint);
+        push_op(op_table, &mut index, "compare", compare);
         push_macro(op_table, &mut index, "loop", loop_word::loop_word);
         push_macro(op_table, &mut index, "uses:", use_module);
         push_macro(op_table, &mut index, "exports:", export_list);
+        push_macro(op_table, &mut index, "vocab:", begin_vocab);
+        push_macro(op_table, &mut index, ";vocab", end_vocab);
 
         Module {
             imported: HashMap::new(),
@@ -144,6 +159,10 @@ fn equal(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     vm.equal()
 }
 
+fn compare(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.compare()
+}
+
 fn less(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     vm.less()
 }
@@ -162,6 +181,38 @@ fn apply(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
     vm.apply(compiler)
 }
 
+fn tail_call(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
+    vm.tail_call(compiler)
+}
+
+fn tail_apply(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
+    vm.tail_apply(compiler)
+}
+
+fn load_local(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.load_local()
+}
+
+fn store_local(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.store_local()
+}
+
+fn catch_begin(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.catch_begin()
+}
+
+fn catch_end(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.catch_end()
+}
+
+fn throw(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.throw()
+}
+
+fn breakpoint(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.breakpoint()
+}
+
 fn return_op(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     vm.return_op()
 }
@@ -207,12 +258,20 @@ fn use_module(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
     compiler.use_module(vm)
 }
 
+fn begin_vocab(_vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
+    compiler.begin_vocab()
+}
+
+fn end_vocab(_vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
+    compiler.end_vocab()
+}
+
 fn export_list(_vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
     log::trace!("export_list");
     let names = compiler.scan_value_list(&ValueData::Word(";".to_string()))?;
     let module_name = compiler
         .current_scanner()
-        .map(|s| s.source.get_key())
+        .map(|s| s.module_key())
         .ok_or_else(|| CompilerError::InvalidState("missing scanner".to_string()))?;
     let env = compiler.environment()?;
     let mut env = env.borrow_mut();
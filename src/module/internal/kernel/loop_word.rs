@@ -32,6 +32,7 @@ pub fn loop_word(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
         lambda_stack.push_front(start_ip);
 
         while let Some(mut cursor) = lambda_stack.pop_back() {
+            let is_body = cursor == start_ip;
             loop {
                 let op = env.borrow().get_instruction(cursor)?;
                 match OpCode::try_from(op) {
@@ -47,7 +48,16 @@ pub fn loop_word(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
                     Ok(OpCode::Lit) => {
                         loop_lit(&env, &mut lambda_stack, &mut cursor)?;
                     }
-                    Ok(OpCode::Jump) => cursor += 1,
+                    Ok(OpCode::Jump) | Ok(OpCode::LoadLocal) | Ok(OpCode::StoreLocal) => {
+                        cursor += 1
+                    }
+                    Ok(OpCode::TailCall) => {
+                        loop_tail_call(&env, is_body, &mut cursor)?;
+                    }
+                    Ok(OpCode::TailApply) if is_body => {
+                        env.borrow_mut()
+                            .set_instruction(cursor, OpCode::Apply as usize)?;
+                    }
                     _ => {}
                 }
                 cursor += 1;
@@ -90,6 +100,19 @@ fn loop_lit(
     Ok(())
 }
 
+// The loop body jumps back to the start instead of returning, so a call at the
+// end of it isn't really in tail position. Turn it back into a regular call.
+fn loop_tail_call(env: &Shared<Environment>, is_body: bool, cursor: &mut usize) -> Result<()> {
+    if is_body {
+        let op_table_index = env.borrow().get_instruction(*cursor + 1)?;
+        env.borrow_mut().set_instruction(*cursor, op_table_index)?;
+        env.borrow_mut()
+            .set_instruction(*cursor + 1, OpCode::Nop as usize)?;
+    }
+    *cursor += 1;
+    Ok(())
+}
+
 fn loop_continue(env: &Shared<Environment>, start_ip: usize, cursor: &mut usize) -> Result<()> {
     *cursor += 1;
     let next: OpCode = env.borrow().get_instruction(*cursor)?.try_into()?;
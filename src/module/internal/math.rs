@@ -0,0 +1,338 @@
+use std::collections::{HashMap, HashSet};
+use std::convert::TryFrom;
+
+use crate::compiler::Compiler;
+use crate::error::{Result, VMError};
+use crate::module::{Module, ModuleManager};
+use crate::shared::{shared, Shared};
+use crate::value::lambda::Lambda;
+use crate::value::ValueData;
+use crate::vm::VM;
+
+use super::{push_op, InternalBuilder};
+
+pub const MATH: &str = "std/_math";
+
+pub struct MathBuilder;
+impl InternalBuilder for MathBuilder {
+    fn define_module(
+        &self,
+        _module_manager: &ModuleManager,
+        op_table: &mut Vec<Shared<Lambda>>,
+    ) -> Module {
+        let mut index = HashMap::new();
+
+        push_op(op_table, &mut index, ">float", to_float);
+        push_op(op_table, &mut index, ">int", to_int);
+        push_op(op_table, &mut index, "pi", pi);
+        push_op(op_table, &mut index, "e", e);
+        push_op(op_table, &mut index, "sqrt", sqrt);
+        push_op(op_table, &mut index, "pow", pow);
+        push_op(op_table, &mut index, "floor", floor);
+        push_op(op_table, &mut index, "ceil", ceil);
+        push_op(op_table, &mut index, "round", round);
+        push_op(op_table, &mut index, "sin", sin);
+        push_op(op_table, &mut index, "cos", cos);
+        push_op(op_table, &mut index, "tan", tan);
+        push_op(op_table, &mut index, "log", log);
+        push_op(op_table, &mut index, "exp", exp);
+        push_op(op_table, &mut index, "mod", modulo);
+        push_op(op_table, &mut index, "divmod", divmod);
+        push_op(op_table, &mut index, "gcd", gcd);
+        push_op(op_table, &mut index, "**", int_pow);
+        push_op(op_table, &mut index, "bit-and", bit_and);
+        push_op(op_table, &mut index, "bit-or", bit_or);
+        push_op(op_table, &mut index, "bit-xor", bit_xor);
+        push_op(op_table, &mut index, "bit-not", bit_not);
+        push_op(op_table, &mut index, "shift-left", shift_left);
+        push_op(op_table, &mut index, "shift-right", shift_right);
+
+        Module {
+            imported: HashMap::new(),
+            path: None,
+            name: MATH.to_string(),
+            defined: index,
+            exported: HashSet::new(),
+        }
+    }
+}
+
+/// >float ( n -- f )
+fn to_float(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let n = vm.pop()?;
+    let f = match n.borrow().data {
+        ValueData::Integer(i) => i as f64,
+        ValueData::Float(f) => f,
+        _ => return Err(VMError::TypeMismatch(">float number".to_string()).into()),
+    };
+    vm.push(shared(ValueData::Float(f).into()))
+}
+
+/// >int ( n -- i )
+///
+/// Floats are truncated toward zero. Values outside of the integer range
+/// saturate at the minimum or maximum integer, and NaN becomes 0.
+fn to_int(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let n = vm.pop()?;
+    let i = match n.borrow().data {
+        ValueData::Integer(i) => i,
+        ValueData::Float(f) => f as i64,
+        _ => return Err(VMError::TypeMismatch(">int number".to_string()).into()),
+    };
+    vm.push(shared(ValueData::Integer(i).into()))
+}
+
+/// pi ( -- f )
+fn pi(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.push(shared(ValueData::Float(std::f64::consts::PI).into()))
+}
+
+/// e ( -- f )
+fn e(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    vm.push(shared(ValueData::Float(std::f64::consts::E).into()))
+}
+
+/// sqrt ( n -- f )
+fn sqrt(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let n = pop_number(vm, "sqrt")?;
+    if n < 0.0 {
+        return Err(VMError::DomainError(format!("sqrt of {}", n)).into());
+    }
+    push_float(vm, n.sqrt(), || format!("sqrt of {}", n))
+}
+
+/// pow ( base exponent -- f )
+///
+/// This is always float exponentiation. Use `**` to keep integers exact.
+fn pow(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let exponent = pop_number(vm, "pow")?;
+    let base = pop_number(vm, "pow")?;
+    if base == 0.0 && exponent < 0.0 {
+        return Err(VMError::DomainError(format!("0 pow {}", exponent)).into());
+    }
+    push_float(vm, base.powf(exponent), || {
+        format!("{} pow {}", base, exponent)
+    })
+}
+
+/// floor ( n -- n' )
+fn floor(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    rounding(vm, "floor", f64::floor)
+}
+
+/// ceil ( n -- n' )
+fn ceil(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    rounding(vm, "ceil", f64::ceil)
+}
+
+/// round ( n -- n' )
+///
+/// Halfway cases round away from zero.
+fn round(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    rounding(vm, "round", f64::round)
+}
+
+/// sin ( radians -- f )
+fn sin(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let n = pop_number(vm, "sin")?;
+    push_float(vm, n.sin(), || format!("sin of {}", n))
+}
+
+/// cos ( radians -- f )
+fn cos(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let n = pop_number(vm, "cos")?;
+    push_float(vm, n.cos(), || format!("cos of {}", n))
+}
+
+/// tan ( radians -- f )
+fn tan(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let n = pop_number(vm, "tan")?;
+    push_float(vm, n.tan(), || format!("tan of {}", n))
+}
+
+/// log ( n -- f )
+///
+/// This is the natural logarithm.
+fn log(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let n = pop_number(vm, "log")?;
+    if n <= 0.0 {
+        return Err(VMError::DomainError(format!("log of {}", n)).into());
+    }
+    push_float(vm, n.ln(), || format!("log of {}", n))
+}
+
+/// exp ( n -- f )
+fn exp(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let n = pop_number(vm, "exp")?;
+    push_float(vm, n.exp(), || format!("exp of {}", n))
+}
+
+/// mod ( a b -- r )
+///
+/// The remainder has the same sign as `a`, so it goes with `/`, which
+/// truncates toward zero.
+fn modulo(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let (a, b) = pop_divisor(vm, "mod")?;
+    vm.push(shared(ValueData::Integer(a.wrapping_rem(b)).into()))
+}
+
+/// divmod ( a b -- q r )
+fn divmod(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let (a, b) = pop_divisor(vm, "divmod")?;
+    let quotient = a
+        .checked_div(b)
+        .ok_or_else(|| VMError::DomainError(format!("{} divmod {} overflows", a, b)))?;
+    vm.push(shared(ValueData::Integer(quotient).into()))?;
+    vm.push(shared(ValueData::Integer(a.wrapping_rem(b)).into()))
+}
+
+/// gcd ( a b -- n )
+///
+/// The result is never negative, and `0 0 gcd` is 0.
+fn gcd(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let b = pop_integer(vm, "gcd")?;
+    let a = pop_integer(vm, "gcd")?;
+    let (mut x, mut y) = (a.unsigned_abs(), b.unsigned_abs());
+    while y != 0 {
+        (x, y) = (y, x % y);
+    }
+    let n =
+        i64::try_from(x).map_err(|_| VMError::DomainError(format!("{} gcd {} overflows", a, b)))?;
+    vm.push(shared(ValueData::Integer(n).into()))
+}
+
+/// ** ( base exponent -- n )
+///
+/// Integer exponentiation. The exponent can't be negative, and results that
+/// don't fit in an integer are an error rather than wrapping around.
+fn int_pow(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let exponent = pop_integer(vm, "**")?;
+    let base = pop_integer(vm, "**")?;
+    let n = u32::try_from(exponent)
+        .ok()
+        .and_then(|exponent| base.checked_pow(exponent))
+        .ok_or_else(|| {
+            let reason = if exponent < 0 {
+                format!("{} ** {} has a negative exponent", base, exponent)
+            } else {
+                format!("{} ** {} overflows", base, exponent)
+            };
+            VMError::DomainError(reason)
+        })?;
+    vm.push(shared(ValueData::Integer(n).into()))
+}
+
+/// bit-and ( a b -- n )
+fn bit_and(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let b = pop_integer(vm, "bit-and")?;
+    let a = pop_integer(vm, "bit-and")?;
+    vm.push(shared(ValueData::Integer(a & b).into()))
+}
+
+/// bit-or ( a b -- n )
+fn bit_or(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let b = pop_integer(vm, "bit-or")?;
+    let a = pop_integer(vm, "bit-or")?;
+    vm.push(shared(ValueData::Integer(a | b).into()))
+}
+
+/// bit-xor ( a b -- n )
+fn bit_xor(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let b = pop_integer(vm, "bit-xor")?;
+    let a = pop_integer(vm, "bit-xor")?;
+    vm.push(shared(ValueData::Integer(a ^ b).into()))
+}
+
+/// bit-not ( n -- n' )
+fn bit_not(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let n = pop_integer(vm, "bit-not")?;
+    vm.push(shared(ValueData::Integer(!n).into()))
+}
+
+/// shift-left ( n amount -- n' )
+///
+/// Bits shifted past the 64 bits of an integer are dropped, so shifting by
+/// 64 or more is 0.
+fn shift_left(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let (n, amount) = pop_shift(vm, "shift-left")?;
+    vm.push(shared(
+        ValueData::Integer(n.checked_shl(amount).unwrap_or(0)).into(),
+    ))
+}
+
+/// shift-right ( n amount -- n' )
+///
+/// This is an arithmetic shift, so it keeps the sign. Shifting by 64 or more
+/// is 0 for a non-negative number and -1 for a negative one.
+fn shift_right(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let (n, amount) = pop_shift(vm, "shift-right")?;
+    let filled = if n < 0 { -1 } else { 0 };
+    vm.push(shared(
+        ValueData::Integer(n.checked_shr(amount).unwrap_or(filled)).into(),
+    ))
+}
+
+/// Pops an integer and how far to shift it, which can't be negative.
+fn pop_shift(vm: &mut VM, word: &str) -> Result<(i64, u32)> {
+    let amount = pop_integer(vm, word)?;
+    let n = pop_integer(vm, word)?;
+    if amount < 0 {
+        return Err(VMError::DomainError(format!(
+            "{} {} {} has a negative shift",
+            n, word, amount
+        ))
+        .into());
+    }
+    Ok((n, u32::try_from(amount).unwrap_or(u32::MAX)))
+}
+
+fn pop_number(vm: &mut VM, word: &str) -> Result<f64> {
+    let n = vm.pop()?;
+    let n = match n.borrow().data {
+        ValueData::Integer(i) => i as f64,
+        ValueData::Float(f) => f,
+        _ => return Err(VMError::TypeMismatch(format!("{} number", word)).into()),
+    };
+    Ok(n)
+}
+
+fn pop_integer(vm: &mut VM, word: &str) -> Result<i64> {
+    let n = vm.pop()?;
+    let n = match n.borrow().data {
+        ValueData::Integer(i) => i,
+        _ => return Err(VMError::TypeMismatch(format!("{} integer", word)).into()),
+    };
+    Ok(n)
+}
+
+fn pop_divisor(vm: &mut VM, word: &str) -> Result<(i64, i64)> {
+    let b = pop_integer(vm, word)?;
+    let a = pop_integer(vm, word)?;
+    if b == 0 {
+        return Err(VMError::DivisionByZero.into());
+    }
+    Ok((a, b))
+}
+
+/// Pushes the result of a float operation. Anything that would come out as
+/// NaN is thrown instead, so bad inputs don't spread silently.
+fn push_float<F>(vm: &mut VM, f: f64, reason: F) -> Result<()>
+where
+    F: FnOnce() -> String,
+{
+    if f.is_nan() {
+        return Err(VMError::DomainError(reason()).into());
+    }
+    vm.push(shared(ValueData::Float(f).into()))
+}
+
+/// Integers are already whole, so they're left alone.
+fn rounding(vm: &mut VM, word: &str, op: fn(f64) -> f64) -> Result<()> {
+    let n = vm.pop()?;
+    let data = match n.borrow().data {
+        ValueData::Integer(i) => ValueData::Integer(i),
+        ValueData::Float(f) => ValueData::Float(op(f)),
+        _ => return Err(VMError::TypeMismatch(format!("{} number", word)).into()),
+    };
+    vm.push(shared(data.into()))
+}
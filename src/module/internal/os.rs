@@ -0,0 +1,79 @@
+use std::collections::{HashMap, HashSet};
+use std::env;
+
+use crate::error::Result;
+use crate::module::Module;
+use crate::shared::shared;
+use crate::value::Value;
+use crate::vm::VM;
+use crate::{compiler::Compiler, error::VMError};
+
+use super::{push_false, push_op, InternalBuilder};
+
+pub const OS: &str = "std/os";
+
+pub struct OsModule;
+impl InternalBuilder for OsModule {
+    fn define_module(
+        &self,
+        _module_manager: &crate::module::ModuleManager,
+        op_table: &mut Vec<crate::shared::Shared<crate::value::lambda::Lambda>>,
+    ) -> crate::module::Module {
+        let mut index = HashMap::new();
+
+        push_op(op_table, &mut index, "argv", argv);
+        push_op(op_table, &mut index, "getenv", getenv);
+        push_op(op_table, &mut index, "setenv", setenv);
+
+        Module {
+            imported: HashMap::new(),
+            path: None,
+            name: OS.to_string(),
+            defined: index,
+            exported: HashSet::new(),
+        }
+    }
+}
+
+/// -- arg-vector
+/// The arguments given after the script on the command line.
+fn argv(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let args: Vec<Value> = vm
+        .args
+        .iter()
+        .map(|arg| Value::from(arg.as_str()))
+        .collect();
+    vm.push(shared(args.into()))
+}
+
+/// name -- value|#f
+fn getenv(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let name = vm.pop()?;
+    let name = name.borrow();
+    let name = name
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("getenv name must be string".to_string()))?;
+
+    match env::var(name) {
+        Ok(value) => vm.push(shared(value.into())),
+        Err(_) => push_false(vm),
+    }
+}
+
+/// value name --
+fn setenv(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let name = vm.pop()?;
+    let name = name.borrow();
+    let name = name
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("setenv name must be string".to_string()))?;
+    let value = vm.pop()?;
+    let value = value.borrow();
+    let value = value
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("setenv value must be string".to_string()))?;
+
+    env::set_var(name, value);
+
+    Ok(())
+}
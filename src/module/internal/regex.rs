@@ -0,0 +1,120 @@
+use std::collections::{HashMap, HashSet};
+
+use crate::error::Result;
+use crate::module::Module;
+use crate::regex::Regex;
+use crate::shared::shared;
+use crate::value::{SharedValue, Value, ValueData};
+use crate::vm::VM;
+use crate::{compiler::Compiler, error::VMError};
+
+use super::{push_op, InternalBuilder};
+
+pub const REGEX: &str = "std/regex";
+
+pub struct RegexModule;
+impl InternalBuilder for RegexModule {
+    fn define_module(
+        &self,
+        _module_manager: &crate::module::ModuleManager,
+        op_table: &mut Vec<crate::shared::Shared<crate::value::lambda::Lambda>>,
+    ) -> crate::module::Module {
+        let mut index = HashMap::new();
+
+        push_op(op_table, &mut index, "<regex>", regex);
+        push_op(op_table, &mut index, "regex-match?", regex_match);
+        push_op(op_table, &mut index, "regex-find", regex_find);
+        push_op(op_table, &mut index, "regex-captures", regex_captures);
+        push_op(op_table, &mut index, "regex-replace", regex_replace);
+
+        Module {
+            imported: HashMap::new(),
+            path: None,
+            name: REGEX.to_string(),
+            defined: index,
+            exported: HashSet::new(),
+        }
+    }
+}
+
+fn compile(pattern: &str) -> Result<Regex> {
+    Regex::new(pattern).map_err(|reason| VMError::InvalidRegex(pattern.to_string(), reason).into())
+}
+
+/// Gets a regex off the stack. A string is compiled, so words can be given
+/// a pattern directly.
+fn pop_regex(vm: &mut VM, word: &str) -> Result<Regex> {
+    let value = vm.pop()?;
+    let value = value.borrow();
+    match &value.data {
+        ValueData::Regex(regex) => Ok(regex.clone()),
+        ValueData::String(pattern) => compile(pattern),
+        _ => Err(VMError::TypeMismatch(format!("{} regex", word)).into()),
+    }
+}
+
+fn pop_string(vm: &mut VM, word: &str) -> Result<String> {
+    let value = vm.pop()?;
+    let value = value.borrow();
+    value
+        .as_string()
+        .map(String::from)
+        .ok_or_else(|| VMError::TypeMismatch(format!("{} string", word)).into())
+}
+
+fn substring(input: &str, span: Option<(usize, usize)>) -> SharedValue {
+    let data = match span {
+        Some((from, to)) => ValueData::String(input[from..to].to_string()),
+        None => ValueData::Boolean(false),
+    };
+    shared(Value::new(data))
+}
+
+/// pattern -- regex
+fn regex(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let pattern = pop_string(vm, "<regex>")?;
+    let regex = compile(&pattern)?;
+    vm.push(shared(ValueData::Regex(regex).into()))
+}
+
+/// string regex -- ?
+fn regex_match(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let regex = pop_regex(vm, "regex-match?")?;
+    let input = pop_string(vm, "regex-match?")?;
+    vm.push(shared(ValueData::Boolean(regex.is_match(&input)).into()))
+}
+
+/// string regex -- match/#f
+fn regex_find(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let regex = pop_regex(vm, "regex-find")?;
+    let input = pop_string(vm, "regex-find")?;
+    let span = regex.captures_at(&input, 0).and_then(|groups| groups[0]);
+    vm.push(substring(&input, span))
+}
+
+/// string regex -- groups/#f
+///
+/// The vector has the whole match and then each group. Groups that didn't
+/// take part in the match are `#f`.
+fn regex_captures(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let regex = pop_regex(vm, "regex-captures")?;
+    let input = pop_string(vm, "regex-captures")?;
+    let data = match regex.captures_at(&input, 0) {
+        Some(groups) => ValueData::List(
+            groups
+                .into_iter()
+                .map(|span| substring(&input, span))
+                .collect(),
+        ),
+        None => ValueData::Boolean(false),
+    };
+    vm.push(shared(data.into()))
+}
+
+/// string regex replacement -- string
+fn regex_replace(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let replacement = pop_string(vm, "regex-replace")?;
+    let regex = pop_regex(vm, "regex-replace")?;
+    let input = pop_string(vm, "regex-replace")?;
+    vm.push(shared(regex.replace_all(&input, &replacement).into()))
+}
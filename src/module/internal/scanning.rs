@@ -1,7 +1,8 @@
 use std::collections::{HashMap, HashSet};
+use std::convert::TryFrom;
 
 use crate::compiler::Compiler;
-use crate::error::Result;
+use crate::error::{Result, VMError};
 use crate::module::{Module, ModuleManager};
 use crate::shared::Shared;
 use crate::shared::{shared, unshare_clone};
@@ -22,6 +23,7 @@ impl InternalBuilder for ScanningBuilder {
     ) -> Module {
         let mut index = HashMap::new();
         push_op(op_table, &mut index, "scan-value", scan_value);
+        push_op(op_table, &mut index, "scan-values", scan_values);
         push_op(op_table, &mut index, "scan-value-list", scan_value_list);
         push_op(op_table, &mut index, "scan-object-list", scan_object_list);
         // TODO: peek-value (for things like `inline` after function declarations)
@@ -42,6 +44,28 @@ fn scan_value(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
     Ok(())
 }
 
+/// scan-values ( n -- vector )
+///
+/// Reads the next `n` tokens as they are, without running any macros in
+/// them.
+fn scan_values(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
+    let n = vm
+        .pop()?
+        .borrow()
+        .as_integer()
+        .and_then(|n| usize::try_from(n).ok())
+        .ok_or_else(|| VMError::TypeMismatch("scan-values non-negative integer".to_string()))?;
+
+    let list = (0..n)
+        .map(|_| compiler.scan_word().map(shared))
+        .collect::<Result<Vec<_>>>()?;
+    let value = Value::new(ValueData::List(list));
+
+    vm.push(shared(value))?;
+
+    Ok(())
+}
+
 fn scan_value_list(vm: &mut VM, compiler: &mut Compiler) -> Result<()> {
     let delimiter = vm.pop()?;
     let delimiter: Value = unshare_clone(delimiter);
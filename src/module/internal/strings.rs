@@ -1,4 +1,5 @@
 use std::collections::{HashMap, HashSet};
+use std::convert::TryFrom;
 
 use crate::compiler::Compiler;
 use crate::error::{Result, VMError};
@@ -23,10 +24,15 @@ impl InternalBuilder for StringsBuilder {
 
         push_op(op_table, &mut index, "<string>", create_string);
         push_op(op_table, &mut index, ">string", to_string);
+        push_op(op_table, &mut index, ">hex", to_hex);
         push_op(op_table, &mut index, "utf8>string", utf8_to_string);
         push_op(op_table, &mut index, "concat", string_concat);
         push_op(op_table, &mut index, "nth", nth);
         push_op(op_table, &mut index, ">utf8", to_utf8);
+        push_op(op_table, &mut index, "char->int", char_to_int);
+        push_op(op_table, &mut index, "int->char", int_to_char);
+        push_op(op_table, &mut index, "string>chars", string_to_chars);
+        push_op(op_table, &mut index, "chars>string", chars_to_string);
         push_op(op_table, &mut index, "empty?", is_empty);
         push_op(op_table, &mut index, "in?", is_in);
         push_op(op_table, &mut index, "starts-with?", starts_with);
@@ -44,6 +50,15 @@ impl InternalBuilder for StringsBuilder {
         push_op(op_table, &mut index, "substring", substring);
         push_op(op_table, &mut index, ">lowercase", to_lowercase);
         push_op(op_table, &mut index, ">uppercase", to_uppercase);
+        push_op(op_table, &mut index, "format", format);
+        push_op(op_table, &mut index, "symbol>string", symbol_to_string);
+        push_op(op_table, &mut index, "string>symbol", string_to_symbol);
+        push_op(op_table, &mut index, "string-length", length);
+        push_op(op_table, &mut index, "index-of", index_of);
+        push_op(op_table, &mut index, "replace", replace);
+        push_op(op_table, &mut index, "trim", trim);
+        push_op(op_table, &mut index, "upcase", to_uppercase);
+        push_op(op_table, &mut index, "downcase", to_lowercase);
 
         Module {
             imported: HashMap::new(),
@@ -69,6 +84,21 @@ fn to_string(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     vm.push(shared(ValueData::String(value.to_string()).into()))
 }
 
+/// >hex ( n -- string )
+///
+/// Lowercase hexadecimal digits without a prefix, so `255 >hex` is `"ff"`
+/// and `-255 >hex` is `"-ff"`.
+fn to_hex(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let n = vm
+        .pop()?
+        .borrow()
+        .as_integer()
+        .ok_or_else(|| VMError::TypeMismatch(">hex integer".to_string()))?;
+    let sign = if n < 0 { "-" } else { "" };
+    let hex = format!("{}{:x}", sign, n.unsigned_abs());
+    vm.push(shared(Value::from(hex)))
+}
+
 /// utf8>string ( vec -- string )
 fn utf8_to_string(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let list = vm.pop()?;
@@ -142,6 +172,62 @@ fn nth(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     Ok(())
 }
 
+/// char->int ( c -- code-point )
+fn char_to_int(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let c = vm
+        .pop()?
+        .borrow()
+        .as_char()
+        .ok_or_else(|| VMError::TypeMismatch("char->int char".to_string()))?;
+    vm.push(shared(ValueData::Integer(c as i64).into()))
+}
+
+/// int->char ( code-point -- c )
+fn int_to_char(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let n = vm
+        .pop()?
+        .borrow()
+        .as_integer()
+        .ok_or_else(|| VMError::TypeMismatch("int->char code point".to_string()))?;
+    let c = u32::try_from(n)
+        .ok()
+        .and_then(char::from_u32)
+        .ok_or(VMError::InvalidCodePoint(n))?;
+    vm.push(shared(ValueData::Char(c).into()))
+}
+
+/// string>chars ( str -- char-vec )
+fn string_to_chars(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let s = vm.pop()?;
+    let s = s.borrow();
+    let s = s
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("string>chars".to_string()))?;
+
+    let chars: Vec<Value> = s.chars().map(Value::from).collect();
+    vm.push(shared(chars.into()))
+}
+
+/// chars>string ( char-vec -- str )
+fn chars_to_string(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let list = vm.pop()?;
+    let list = list.borrow();
+    let list = list
+        .as_list()
+        .ok_or_else(|| VMError::TypeMismatch("chars>string vector".to_string()))?;
+
+    let mut s = String::with_capacity(list.len());
+    for item in list {
+        let c = item
+            .borrow()
+            .as_char()
+            .ok_or_else(|| VMError::TypeMismatch("chars>string char".to_string()))?;
+        s.push(c);
+    }
+
+    vm.push(shared(Value::from(s)))
+}
+
 /// >utf8 ( str -- bytes-vec )
 fn to_utf8(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let s = vm.pop()?;
@@ -232,7 +318,18 @@ fn ends_with(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     Ok(())
 }
 
-// index-of? ( str sub -- ? )
+/// The byte offset of the code point at `index` in `s`. The length is the
+/// offset of the end.
+fn byte_offset(s: &str, index: usize) -> Option<usize> {
+    s.char_indices()
+        .map(|(i, _)| i)
+        .chain(Some(s.len()))
+        .nth(index)
+}
+
+/// index-of ( str sub -- i/#f )
+///
+/// `index-of?` is the same word. The index counts code points.
 fn index_of(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let sub = vm.pop()?;
     let sub = sub.borrow();
@@ -247,7 +344,7 @@ fn index_of(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
         .ok_or_else(|| VMError::TypeMismatch("index-of? str".to_string()))?;
 
     let result = if let Some(i) = s.find(sub) {
-        Value::from(i as i64)
+        Value::from(s[..i].chars().count() as i64)
     } else {
         Value::from(false)
     };
@@ -257,7 +354,9 @@ fn index_of(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     Ok(())
 }
 
-// length ( s -- l )
+/// string-length ( s -- l )
+///
+/// `length` is the same word. It counts code points, not bytes.
 fn length(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let s = vm.pop()?;
     let s = s.borrow();
@@ -265,7 +364,7 @@ fn length(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
         .as_string()
         .ok_or_else(|| VMError::TypeMismatch("length".to_string()))?;
 
-    vm.push(shared(Value::from(s.len() as i64)))?;
+    vm.push(shared(Value::from(s.chars().count() as i64)))?;
 
     Ok(())
 }
@@ -296,6 +395,32 @@ fn replace_all(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     Ok(())
 }
 
+/// replace ( str find repl -- out )
+///
+/// This only replaces the first `find`. Use `replace-all` for all of them.
+fn replace(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let replace = vm.pop()?;
+    let replace = replace.borrow();
+    let replace = replace
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("replace replace".to_string()))?;
+
+    let target = vm.pop()?;
+    let target = target.borrow();
+    let target = target
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("replace target".to_string()))?;
+
+    let s = vm.pop()?;
+    let s = s.borrow();
+    let s = s
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("replace str".to_string()))?;
+
+    let result = s.replacen(target, replace, 1);
+    vm.push(shared(Value::from(result)))
+}
+
 // split ( str sub -- prefix suffix/#f )
 fn split(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let sub = vm.pop()?;
@@ -319,7 +444,9 @@ fn split(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     }
 }
 
-// split-all ( str sub -- vec )
+/// split-all ( str sub -- vec )
+///
+/// With an empty `sub`, this splits the string into its characters.
 fn split_all(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let sub = vm.pop()?;
     let sub = sub.borrow();
@@ -333,18 +460,21 @@ fn split_all(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
         .as_string()
         .ok_or_else(|| VMError::TypeMismatch("split-all str".to_string()))?;
 
-    let splits = Value::from(s.split(sub).collect::<Vec<_>>());
-    vm.push(shared(splits))
+    let splits = if sub.is_empty() {
+        s.chars().map(String::from).collect::<Vec<_>>()
+    } else {
+        s.split(sub).map(String::from).collect::<Vec<_>>()
+    };
+    vm.push(shared(Value::from(splits)))
 }
 
-// split_at ( str i -- prefix suffix/#f )
+// split-at ( str i -- prefix suffix/#f )
 fn split_at(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let i = vm.pop()?;
     let i = i.borrow();
     let i = i
         .as_integer()
         .ok_or_else(|| VMError::TypeMismatch("split-at index".to_string()))?;
-    let i = i as usize;
 
     let s_value = vm.pop()?;
     let s = s_value.borrow();
@@ -352,7 +482,7 @@ fn split_at(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
         .as_string()
         .ok_or_else(|| VMError::TypeMismatch("split-at str".to_string()))?;
 
-    if i <= s.len() {
+    if let Some(i) = usize::try_from(i).ok().and_then(|i| byte_offset(s, i)) {
         let (prefix, suffix) = s.split_at(i);
         vm.push(shared(prefix.into()))?;
         vm.push(shared(suffix.into()))
@@ -428,20 +558,22 @@ fn strip_end(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     vm.push(result)
 }
 
-// substring ( str start end -- sub )
+/// substring ( str start end -- sub )
+///
+/// `start` and `end` count code points. It's an error for `end` to be past
+/// the end of the string, or for `start` to be past `end`.
 fn substring(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let end = vm.pop()?;
     let end = end.borrow();
     let end = end
         .as_integer()
-        .ok_or_else(|| VMError::TypeMismatch("substring end".to_string()))? as usize;
+        .ok_or_else(|| VMError::TypeMismatch("substring end".to_string()))?;
 
     let start = vm.pop()?;
     let start = start.borrow();
     let start = start
         .as_integer()
-        .ok_or_else(|| VMError::TypeMismatch("substring start".to_string()))?
-        as usize;
+        .ok_or_else(|| VMError::TypeMismatch("substring start".to_string()))?;
 
     let s = vm.pop()?;
     let s = s.borrow();
@@ -449,11 +581,34 @@ fn substring(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
         .as_string()
         .ok_or_else(|| VMError::TypeMismatch("substring str".to_string()))?;
 
-    // TODO: this is missing some edges. one or two
-    let result = Value::from(&s[start..end]);
+    let length = s.chars().count();
+    let end_offset = usize::try_from(end)
+        .ok()
+        .and_then(|end| byte_offset(s, end))
+        .ok_or(VMError::IndexOutOfBounds(end, length))?;
+    let start_offset = usize::try_from(start)
+        .ok()
+        .filter(|start| *start as i64 <= end)
+        .and_then(|start| byte_offset(s, start))
+        .ok_or(VMError::IndexOutOfBounds(start, end as usize))?;
+
+    let result = Value::from(&s[start_offset..end_offset]);
     vm.push(shared(result))
 }
 
+/// trim ( str -- str' )
+///
+/// Removes whitespace from both ends.
+fn trim(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let s = vm.pop()?;
+    let s = s.borrow();
+    let s = s
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("trim".to_string()))?;
+
+    vm.push(shared(Value::from(s.trim())))
+}
+
 // lowercase ( s -- s' )
 fn to_lowercase(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let s = vm.pop()?;
@@ -475,3 +630,153 @@ fn to_uppercase(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
 
     vm.push(shared(Value::from(s.to_uppercase())))
 }
+
+/// symbol>string ( symbol -- string )
+///
+/// The symbol's name without the colon, so `:red symbol>string` is `"red"`.
+fn symbol_to_string(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let symbol = vm.pop()?;
+    let symbol = symbol.borrow();
+    let symbol = symbol
+        .data
+        .as_keyword()
+        .ok_or_else(|| VMError::TypeMismatch("symbol>string".to_string()))?;
+
+    vm.push(shared(Value::from(symbol.name())))
+}
+
+/// string>symbol ( string -- symbol )
+///
+/// Interns the string, so `"red" string>symbol` is the same value as `:red`.
+fn string_to_symbol(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let s = vm.pop()?;
+    let s = s.borrow();
+    let s = s
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("string>symbol".to_string()))?;
+    let symbol = vm
+        .environment
+        .as_ref()
+        .ok_or(VMError::MissingEnvironment)?
+        .borrow_mut()
+        .intern(s);
+
+    vm.push(shared(ValueData::Keyword(symbol).into()))
+}
+
+enum FormatPiece {
+    Text(String),
+    Placeholder(Option<usize>),
+}
+
+/// Splits a format spec into its literal text and its placeholders. `{}`
+/// uses the value's textual form, `{:.N}` prints a number with `N` digits
+/// after the decimal point, and `{{` and `}}` are literal braces.
+fn parse_format(spec: &str) -> std::result::Result<Vec<FormatPiece>, VMError> {
+    let mut pieces = Vec::new();
+    let mut text = String::new();
+    let mut chars = spec.chars().peekable();
+
+    while let Some(c) = chars.next() {
+        match c {
+            '{' if chars.peek() == Some(&'{') => {
+                chars.next();
+                text.push('{');
+            }
+            '}' if chars.peek() == Some(&'}') => {
+                chars.next();
+                text.push('}');
+            }
+            '{' => {
+                let mut placeholder = String::new();
+                loop {
+                    match chars.next() {
+                        Some('}') => break,
+                        Some(c) => placeholder.push(c),
+                        None => {
+                            return Err(VMError::InvalidFormat(format!(
+                                "unclosed placeholder in {:?}",
+                                spec
+                            )))
+                        }
+                    }
+                }
+                let precision = if placeholder.is_empty() {
+                    None
+                } else {
+                    let precision = placeholder
+                        .strip_prefix(":.")
+                        .and_then(|p| p.parse().ok())
+                        .ok_or_else(|| {
+                            VMError::InvalidFormat(format!(
+                                "unknown placeholder {{{}}}",
+                                placeholder
+                            ))
+                        })?;
+                    Some(precision)
+                };
+                if !text.is_empty() {
+                    pieces.push(FormatPiece::Text(std::mem::take(&mut text)));
+                }
+                pieces.push(FormatPiece::Placeholder(precision));
+            }
+            '}' => {
+                return Err(VMError::InvalidFormat(format!(
+                    "unmatched }} in {:?}",
+                    spec
+                )))
+            }
+            c => text.push(c),
+        }
+    }
+    if !text.is_empty() {
+        pieces.push(FormatPiece::Text(text));
+    }
+
+    Ok(pieces)
+}
+
+fn format_value(value: &Value, precision: Option<usize>) -> std::result::Result<String, VMError> {
+    match (precision, &value.data) {
+        (None, data) => Ok(data.to_string()),
+        (Some(precision), ValueData::Float(x)) => Ok(format!("{:.*}", precision, x)),
+        (Some(precision), ValueData::Integer(n)) => Ok(format!("{:.*}", precision, *n as f64)),
+        (Some(_), _) => Err(VMError::TypeMismatch("format precision".to_string())),
+    }
+}
+
+/// format ( ...values spec -- string )
+///
+/// Fills each placeholder in `spec` with a value from the stack. The first
+/// placeholder takes the deepest value, so `1 2 "{} and {}" format` is
+/// `"1 and 2"`.
+fn format(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let spec = vm.pop()?;
+    let spec = spec.borrow();
+    let spec = spec
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("format spec".to_string()))?;
+    let pieces = parse_format(spec)?;
+
+    let placeholders = pieces
+        .iter()
+        .filter(|piece| matches!(piece, FormatPiece::Placeholder(_)))
+        .count();
+    let mut values = Vec::with_capacity(placeholders);
+    for _ in 0..placeholders {
+        values.push(vm.pop()?);
+    }
+
+    let mut output = String::new();
+    for piece in pieces {
+        match piece {
+            FormatPiece::Text(text) => output.push_str(&text),
+            FormatPiece::Placeholder(precision) => {
+                let value = values.pop().ok_or(VMError::StackUnderflow)?;
+                output.push_str(&format_value(&value.borrow(), precision)?);
+            }
+        }
+    }
+
+    vm.push(shared(Value::from(output)))
+}
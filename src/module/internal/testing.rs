@@ -0,0 +1,173 @@
+use std::collections::{HashMap, HashSet};
+
+use crate::error::Result;
+use crate::module::Module;
+use crate::shared::shared;
+use crate::value::{Value, ValueData};
+use crate::vm::{TestFailure, TestResult, VM};
+use crate::{compiler::Compiler, error::VMError};
+
+use super::{push_op, InternalBuilder};
+
+pub const TESTING: &str = "std/_testing";
+
+/// The modules that assertions can run in on their way from a test. They're
+/// reported where they're called from outside of these.
+const SKIPPED_SOURCES: &[&str] = &["std/testing", "std/kernel"];
+
+pub struct TestingModule;
+impl InternalBuilder for TestingModule {
+    fn define_module(
+        &self,
+        _module_manager: &crate::module::ModuleManager,
+        op_table: &mut Vec<crate::shared::Shared<crate::value::lambda::Lambda>>,
+    ) -> crate::module::Module {
+        let mut index = HashMap::new();
+
+        push_op(op_table, &mut index, "<test-case>", test_case);
+        push_op(op_table, &mut index, "<begin-test>", begin_test);
+        push_op(op_table, &mut index, "<end-test>", end_test);
+        push_op(op_table, &mut index, "<record-assertion>", record_assertion);
+        push_op(op_table, &mut index, "<record-error>", record_error);
+        push_op(op_table, &mut index, "assert", assert);
+        push_op(op_table, &mut index, "assert=", assert_equal);
+
+        Module {
+            imported: HashMap::new(),
+            path: None,
+            name: TESTING.to_string(),
+            defined: index,
+            exported: HashSet::new(),
+        }
+    }
+}
+
+/// Records an assertion in the current test case. Outside of one, it's
+/// recorded as a result of its own.
+fn record(vm: &mut VM, passed: bool, message: String) {
+    let failure = (!passed).then(|| TestFailure {
+        loc: vm
+            .build_trace(vm.ip.saturating_sub(1), 0)
+            .into_iter()
+            .filter_map(|frame| frame.loc)
+            .find(|loc| !SKIPPED_SOURCES.contains(&loc.source.as_str())),
+        message,
+    });
+    push_result(vm, failure);
+}
+
+fn push_result(vm: &mut VM, failure: Option<TestFailure>) {
+    match vm.current_test.as_mut() {
+        Some(test) => test.failures.extend(failure),
+        None => vm.test_results.push(TestResult {
+            name: None,
+            failures: failure.into_iter().collect(),
+        }),
+    }
+}
+
+/// accumulator token lambda --
+///
+/// This finishes the `test:` macro. It adds a call to `run-test` with the
+/// name of the test, which is the word after `test:` without its module. The
+/// call is at the name, so that's where the test case is traced to.
+fn test_case(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let lambda = vm.pop()?;
+    let token = vm.pop()?;
+    let accumulator = vm.pop()?;
+
+    let token = token.borrow();
+    let (module, name) = match token.data {
+        ValueData::Symbol {
+            ref module,
+            ref word,
+        } => (module.clone(), word.clone()),
+        ref data => (String::new(), data.to_string()),
+    };
+    let call = Value {
+        data: ValueData::Symbol {
+            module,
+            word: "run-test".to_string(),
+        },
+        lexeme: Some("run-test".to_string()),
+        pos: token.pos.clone(),
+    };
+
+    accumulator
+        .borrow_mut()
+        .as_list_mut()
+        .map(|list| list.extend([shared(name.into()), lambda, shared(call)]))
+        .ok_or_else(|| VMError::TypeMismatch("test: needs an accumulator".to_string()))?;
+
+    Ok(())
+}
+
+/// name --
+fn begin_test(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let name = vm.pop()?.borrow().to_string();
+    if let Some(test) = vm.current_test.take() {
+        vm.test_results.push(test);
+    }
+    vm.current_test = Some(TestResult {
+        name: Some(name),
+        failures: Vec::new(),
+    });
+    Ok(())
+}
+
+/// --
+fn end_test(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    if let Some(test) = vm.current_test.take() {
+        vm.test_results.push(test);
+    }
+    Ok(())
+}
+
+/// ? message --
+fn record_assertion(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let message = vm.pop()?.borrow().to_string();
+    let passed = vm.pop()?.borrow().as_boolean().ok_or_else(|| {
+        VMError::TypeMismatch("<record-assertion> flag must be boolean".to_string())
+    })?;
+    record(vm, passed, message);
+    Ok(())
+}
+
+/// error --
+///
+/// The error was thrown from somewhere that's already been unwound, so there's
+/// no location to report.
+fn record_error(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let error = vm.pop()?.borrow().to_string();
+    let failure = TestFailure {
+        message: format!("unexpected error: {}", error),
+        loc: None,
+    };
+    push_result(vm, Some(failure));
+    Ok(())
+}
+
+/// ? --
+fn assert(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let passed = vm
+        .pop()?
+        .borrow()
+        .as_boolean()
+        .ok_or_else(|| VMError::TypeMismatch("assert flag must be boolean".to_string()))?;
+    record(vm, passed, "assertion failed".to_string());
+    Ok(())
+}
+
+/// actual expected --
+fn assert_equal(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let expected = vm.pop()?;
+    let actual = vm.pop()?;
+    let passed = *actual.borrow() == *expected.borrow();
+    let message = format!(
+        "expected {}, but got {}",
+        expected.borrow().to_repr(),
+        actual.borrow().to_repr()
+    );
+    record(vm, passed, message);
+    Ok(())
+}
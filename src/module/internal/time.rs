@@ -0,0 +1,151 @@
+use std::collections::{HashMap, HashSet};
+use std::thread;
+use std::time::{Duration, Instant, SystemTime, UNIX_EPOCH};
+
+use lazy_static::lazy_static;
+
+use crate::error::Result;
+use crate::module::Module;
+use crate::shared::shared;
+use crate::value::Value;
+use crate::vm::VM;
+use crate::{compiler::Compiler, error::VMError};
+
+use super::{push_op, InternalBuilder};
+
+pub const TIME: &str = "std/_time";
+
+lazy_static! {
+    /// What `ticks` counts from.
+    static ref STARTED: Instant = Instant::now();
+}
+
+const WEEKDAYS: [&str; 7] = ["Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"];
+const MONTHS: [&str; 12] = [
+    "Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec",
+];
+
+pub struct TimeModule;
+impl InternalBuilder for TimeModule {
+    fn define_module(
+        &self,
+        _module_manager: &crate::module::ModuleManager,
+        op_table: &mut Vec<crate::shared::Shared<crate::value::lambda::Lambda>>,
+    ) -> crate::module::Module {
+        let mut index = HashMap::new();
+
+        push_op(op_table, &mut index, "now", now);
+        push_op(op_table, &mut index, "ticks", ticks);
+        push_op(op_table, &mut index, "sleep", sleep);
+        push_op(op_table, &mut index, "format-time", format_time);
+
+        Module {
+            imported: HashMap::new(),
+            path: None,
+            name: TIME.to_string(),
+            defined: index,
+            exported: HashSet::new(),
+        }
+    }
+}
+
+/// -- ms
+/// The wall-clock time, in milliseconds since the Unix epoch.
+fn now(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let ms = match SystemTime::now().duration_since(UNIX_EPOCH) {
+        Ok(since) => since.as_millis() as i64,
+        Err(err) => -(err.duration().as_millis() as i64),
+    };
+    vm.push(shared(Value::from(ms)))
+}
+
+/// -- ms
+/// Milliseconds from a monotonic clock, which only makes sense compared with
+/// other ticks. Changes to the wall clock don't affect it.
+fn ticks(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let ms = STARTED.elapsed().as_secs_f64() * 1000.0;
+    vm.push(shared(Value::from(ms)))
+}
+
+/// ms --
+fn sleep(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let ms = vm.pop()?;
+    let ms = ms
+        .borrow()
+        .as_integer()
+        .filter(|ms| *ms >= 0)
+        .ok_or_else(|| VMError::TypeMismatch("sleep milliseconds".to_string()))?;
+    thread::sleep(Duration::from_millis(ms as u64));
+    Ok(())
+}
+
+/// ms layout -- string
+/// Formats a time from `now` in UTC. See `format_utc` for the layout.
+fn format_time(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let layout = vm.pop()?;
+    let layout = layout.borrow();
+    let layout = layout
+        .as_string()
+        .ok_or_else(|| VMError::TypeMismatch("format-time layout".to_string()))?;
+    let ms = vm.pop()?;
+    let ms = ms
+        .borrow()
+        .as_integer()
+        .ok_or_else(|| VMError::TypeMismatch("format-time milliseconds".to_string()))?;
+
+    let formatted = format_utc(ms, layout).map_err(VMError::InvalidFormat)?;
+    vm.push(shared(formatted.into()))
+}
+
+/// Formats milliseconds since the epoch with `strftime`-style specifiers:
+/// `%Y`, `%m`, `%d`, `%H`, `%M`, `%S`, `%L` for milliseconds, `%a` and `%b`
+/// for short weekday and month names, `%s` for seconds since the epoch, and
+/// `%%`.
+fn format_utc(ms: i64, layout: &str) -> std::result::Result<String, String> {
+    let days = ms.div_euclid(86_400_000);
+    let ms_of_day = ms.rem_euclid(86_400_000);
+    let (year, month, day) = civil_from_days(days);
+    let weekday = (days + 4).rem_euclid(7) as usize;
+
+    let mut output = String::new();
+    let mut chars = layout.chars();
+    while let Some(c) = chars.next() {
+        if c != '%' {
+            output.push(c);
+            continue;
+        }
+        let field = match chars.next() {
+            Some('Y') => format!("{:04}", year),
+            Some('m') => format!("{:02}", month),
+            Some('d') => format!("{:02}", day),
+            Some('H') => format!("{:02}", ms_of_day / 3_600_000),
+            Some('M') => format!("{:02}", ms_of_day / 60_000 % 60),
+            Some('S') => format!("{:02}", ms_of_day / 1000 % 60),
+            Some('L') => format!("{:03}", ms_of_day % 1000),
+            Some('a') => WEEKDAYS[weekday].to_string(),
+            Some('b') => MONTHS[month as usize - 1].to_string(),
+            Some('s') => ms.div_euclid(1000).to_string(),
+            Some('%') => "%".to_string(),
+            Some(c) => return Err(format!("unknown time specifier %{}", c)),
+            None => return Err("trailing %".to_string()),
+        };
+        output.push_str(&field);
+    }
+    Ok(output)
+}
+
+/// The year, month, and day for a number of days since 1970-01-01, from
+/// Howard Hinnant's `civil_from_days`.
+fn civil_from_days(days: i64) -> (i64, u32, u32) {
+    let z = days + 719_468;
+    let era = z.div_euclid(146_097);
+    let day_of_era = z.rem_euclid(146_097);
+    let year_of_era =
+        (day_of_era - day_of_era / 1460 + day_of_era / 36_524 - day_of_era / 146_096) / 365;
+    let day_of_year = day_of_era - (365 * year_of_era + year_of_era / 4 - year_of_era / 100);
+    let mp = (5 * day_of_year + 2) / 153;
+    let day = (day_of_year - (153 * mp + 2) / 5 + 1) as u32;
+    let month = if mp < 10 { mp + 3 } else { mp - 9 } as u32;
+    let year = year_of_era + era * 400 + if month <= 2 { 1 } else { 0 };
+    (year, month, day)
+}
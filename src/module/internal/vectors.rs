@@ -1,4 +1,6 @@
+use std::cmp::Ordering;
 use std::collections::{HashMap, HashSet};
+use std::convert::TryFrom;
 
 use crate::compiler::Compiler;
 use crate::error::{Error, Result, VMError};
@@ -37,6 +39,7 @@ impl InternalBuilder for VectorsBuilder {
         push_op(op_table, &mut index, "subvector", subvector);
         push_op(op_table, &mut index, "join", join);
         push_op(op_table, &mut index, "sort!", sort);
+        push_op(op_table, &mut index, "sort", sorted);
 
         Module {
             imported: HashMap::new(),
@@ -143,15 +146,15 @@ fn nth(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
         .pop()?
         .borrow()
         .as_integer()
-        .ok_or_else(|| VMError::TypeMismatch("nth index".to_string()))? as usize;
+        .ok_or_else(|| VMError::TypeMismatch("nth index".to_string()))?;
     let item = (*list)
         .borrow()
         .as_list()
         .ok_or_else(|| VMError::TypeMismatch("nth list".to_string()))
-        .map(|l| {
-            l.get(index)
-                .cloned()
-                .unwrap_or_else(|| shared(false.into()))
+        .and_then(|l| {
+            to_index(index, l.len())
+                .map(|i| l[i].clone())
+                .ok_or(VMError::IndexOutOfBounds(index, l.len()))
         })?;
 
     vm.push(item)
@@ -160,22 +163,27 @@ fn nth(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
 /// set-nth! ( x i vector -- )
 fn set_nth(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let list = vm.pop()?;
-    let index =
-        vm.pop()?
-            .borrow()
-            .as_integer()
-            .ok_or_else(|| VMError::TypeMismatch("set-nth! index".to_string()))? as usize;
+    let index = vm
+        .pop()?
+        .borrow()
+        .as_integer()
+        .ok_or_else(|| VMError::TypeMismatch("set-nth! index".to_string()))?;
     let item = vm.pop()?;
     let mut list = list.borrow_mut();
     let list = list
         .as_list_mut()
         .ok_or_else(|| VMError::TypeMismatch("set-nth! of list".to_string()))?;
 
-    list[index] = item.clone();
+    let i = to_index(index, list.len()).ok_or(VMError::IndexOutOfBounds(index, list.len()))?;
+    list[i] = item.clone();
 
     Ok(())
 }
 
+fn to_index(index: i64, length: usize) -> Option<usize> {
+    usize::try_from(index).ok().filter(|i| *i < length)
+}
+
 /// length ( vector -- length )
 fn length(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
     let popped = vm.pop()?;
@@ -288,3 +296,44 @@ fn sort(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
 
     Ok(())
 }
+
+/// sort ( vector -- sorted )
+///
+/// The sort is stable. If two items can't be compared, the first pair that
+/// the sort finds is thrown, with the one that came first in the vector
+/// named first.
+fn sorted(vm: &mut VM, _compiler: &mut Compiler) -> Result<()> {
+    let popped = vm.pop()?;
+    let items = popped
+        .borrow()
+        .as_list()
+        .cloned()
+        .ok_or_else(|| VMError::TypeMismatch("sort vector".to_string()))?;
+
+    // Sorting the indexes keeps track of which item of a pair came first.
+    let mut order: Vec<usize> = (0..items.len()).collect();
+    let mut error = None;
+    order.sort_by(|&i, &j| {
+        let (first, second) = (i.min(j), i.max(j));
+        let ordering = items[first]
+            .borrow()
+            .data
+            .compare(&items[second].borrow().data);
+        match ordering {
+            Ok(ordering) if first == i => ordering,
+            Ok(ordering) => ordering.reverse(),
+            Err(err) => {
+                if error.is_none() {
+                    error = Some(err);
+                }
+                Ordering::Equal
+            }
+        }
+    });
+    if let Some(err) = error {
+        return Err(err);
+    }
+
+    let items = order.into_iter().map(|i| items[i].clone()).collect();
+    vm.push(shared(ValueData::List(items).into()))
+}
@@ -3,14 +3,21 @@ use std::iter::FromIterator;
 use std::path::{Path, PathBuf};
 use std::{env, fmt};
 
+use internal::coroutines::COROUTINES;
 use internal::define_module;
 use internal::fs::FS;
 use internal::internals::INTERNALS;
 use internal::io::IO;
+use internal::json::JSON;
 use internal::kernel::KERNEL;
+use internal::math::MATH;
+use internal::os::OS;
+use internal::regex::REGEX;
 use internal::sandbox::SANDBOX;
 use internal::scanning::SCANNING;
 use internal::strings::STRINGS;
+use internal::testing::TESTING;
+use internal::time::TIME;
 use internal::vectors::VECTORS;
 use lazy_static::lazy_static;
 
@@ -24,14 +31,21 @@ pub mod internal;
 
 lazy_static! {
     static ref INTERNAL_MODULES: HashSet<String> = vec![
+        COROUTINES.to_string(),
         FS.to_string(),
         HASHMAPS.to_string(),
         INTERNALS.to_string(),
         IO.to_string(),
+        JSON.to_string(),
         KERNEL.to_string(),
+        MATH.to_string(),
+        OS.to_string(),
+        REGEX.to_string(),
         SANDBOX.to_string(),
         SCANNING.to_string(),
         STRINGS.to_string(),
+        TESTING.to_string(),
+        TIME.to_string(),
         VECTORS.to_string(),
     ]
     .into_iter()
@@ -1,3 +1,4 @@
+use std::convert::TryFrom;
 use std::env;
 use std::path::{Path, PathBuf};
 use std::str::FromStr;
@@ -7,6 +8,7 @@ use pretty_assertions::assert_eq;
 use super::*;
 use crate::error::Result;
 use crate::shared::Shared;
+use crate::vm::OpCode;
 
 fn setup() -> Result<ModuleManager> {
     let pwd = env::current_dir()?;
@@ -176,3 +178,60 @@ fn test_load_internal_module() {
     assert!(!module.defined.is_empty());
     assert_eq!(module.defined.len(), op_table.len());
 }
+
+#[test]
+fn test_kernel_op_table_matches_opcodes() {
+    let cwd = env::current_dir().unwrap();
+    let mut module_manager = ModuleManager::new(&[cwd.join("tests/modules")]);
+    let mut op_table: Vec<Shared<Lambda>> = vec![];
+
+    let result = module_manager.load_internal(KERNEL, &mut op_table);
+    assert!(result.is_ok(), "result {:?}", result);
+
+    let expected = [
+        (OpCode::Nop, "<nop>"),
+        (OpCode::Lit, "<lit>"),
+        (OpCode::Dup, "dup"),
+        (OpCode::Swap, "swap"),
+        (OpCode::Rot, "rot"),
+        (OpCode::Drop, "drop"),
+        (OpCode::Clear, "clear"),
+        (OpCode::StackSize, "stack-size"),
+        (OpCode::Add, "+"),
+        (OpCode::Subtract, "-"),
+        (OpCode::Multiply, "*"),
+        (OpCode::Divide, "/"),
+        (OpCode::Equal, "=="),
+        (OpCode::Less, "<"),
+        (OpCode::Greater, ">"),
+        (OpCode::Not, "!"),
+        (OpCode::Question, "?"),
+        (OpCode::ToR, ">r"),
+        (OpCode::RFrom, "r>"),
+        (OpCode::RFetch, "r@"),
+        (OpCode::Apply, "apply"),
+        (OpCode::Return, "return"),
+        (OpCode::Stop, "stop"),
+        (OpCode::Bye, "bye"),
+        (OpCode::Jump, "jump"),
+        (OpCode::JumpStack, "jump-stack"),
+        (OpCode::LitStack, "lit"),
+        (OpCode::Compile, "compile"),
+        (OpCode::Break, "break"),
+        (OpCode::Continue, "continue"),
+        (OpCode::TailCall, "<tail-call>"),
+        (OpCode::TailApply, "<tail-apply>"),
+        (OpCode::LoadLocal, "<load-local>"),
+        (OpCode::StoreLocal, "<store-local>"),
+    ];
+    assert!(OpCode::try_from(expected.len()).is_err());
+    for (i, (op, name)) in expected.iter().enumerate() {
+        assert_eq!(OpCode::try_from(i).ok(), Some(*op), "opcode {}", i);
+        assert_eq!(
+            op_table[i].borrow().name.as_deref(),
+            Some(*name),
+            "op_table[{}]",
+            i
+        );
+    }
+}
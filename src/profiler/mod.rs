@@ -0,0 +1,268 @@
+//! A profiler for the VM.
+//!
+//! The `Profiler` is a `Hook`, so it costs nothing unless it's set. Before
+//! each instruction, it counts the word that's about to run and closes out
+//! the one before it. A compiled word's time lasts until it returns, so it
+//! includes the words it calls; a built-in's lasts until the next
+//! instruction. Literals aren't words, so they aren't counted.
+
+use std::collections::HashMap;
+use std::fmt::Write as _;
+use std::io::{self, Write};
+use std::str::FromStr;
+use std::time::{Duration, Instant};
+
+use serde_json::json;
+
+use crate::error::Result;
+use crate::shared::{shared, Shared};
+use crate::value::ValueData;
+use crate::vm::{Hook, VM};
+
+/// How a profiler report is written.
+#[derive(Debug, Clone, Copy, Default, PartialEq)]
+pub enum ReportFormat {
+    #[default]
+    Text,
+    Csv,
+    Json,
+}
+
+impl FromStr for ReportFormat {
+    type Err = String;
+
+    fn from_str(s: &str) -> std::result::Result<Self, Self::Err> {
+        match s {
+            "text" => Ok(ReportFormat::Text),
+            "csv" => Ok(ReportFormat::Csv),
+            "json" => Ok(ReportFormat::Json),
+            _ => Err(format!("unknown profile format {:?}", s)),
+        }
+    }
+}
+
+/// How often a word ran and how long it took in all.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct WordProfile {
+    pub word: String,
+    pub calls: u64,
+    pub time: Duration,
+}
+
+/// A word that's still running.
+#[derive(Debug)]
+struct Running {
+    word: String,
+    start: Instant,
+    return_depth: usize,
+}
+
+#[derive(Debug, Default)]
+struct State {
+    words: HashMap<String, WordProfile>,
+    /// The instruction that ran last, which can't be timed until the next
+    /// one starts.
+    pending: Option<Running>,
+    /// The compiled words that haven't returned yet.
+    frames: Vec<Running>,
+    started: Option<Instant>,
+    finished: Option<Instant>,
+}
+
+impl State {
+    /// Adds how long a word ran to its time, unless it's inside another call
+    /// to the same word, whose time already covers it.
+    fn record(&mut self, running: Running, now: Instant) {
+        if self.frames.iter().any(|outer| outer.word == running.word) {
+            return;
+        }
+        if let Some(profile) = self.words.get_mut(&running.word) {
+            profile.time += now - running.start;
+        }
+    }
+
+    /// Closes out the last instruction, and any words that have returned.
+    /// `called` is whether there's a new return address on top of the
+    /// return stack, since `>r` can also make it deeper.
+    fn close(&mut self, return_depth: usize, called: bool, now: Instant) {
+        if let Some(pending) = self.pending.take() {
+            if called && return_depth > pending.return_depth {
+                self.frames.push(pending);
+            } else {
+                self.record(pending, now);
+            }
+        }
+        while self
+            .frames
+            .last()
+            .is_some_and(|frame| frame.return_depth >= return_depth)
+        {
+            let frame = self.frames.pop().unwrap();
+            self.record(frame, now);
+        }
+    }
+}
+
+/// A profiler. It's cheap to clone, and the clones share what they've
+/// counted, so one can be kept to read the report after another is handed
+/// to the VM.
+#[derive(Debug, Clone)]
+pub struct Profiler(Shared<State>);
+
+impl Profiler {
+    pub fn new() -> Self {
+        Profiler(shared(State::default()))
+    }
+
+    /// Stops the timing of anything that's still running. Call this when
+    /// the program's done.
+    pub fn finish(&self) {
+        let now = Instant::now();
+        let mut state = self.0.borrow_mut();
+        state.close(0, false, now);
+        state.finished.get_or_insert(now);
+    }
+
+    /// How long the program ran, from the first instruction until `finish`.
+    pub fn elapsed(&self) -> Duration {
+        let state = self.0.borrow();
+        match (state.started, state.finished) {
+            (Some(started), Some(finished)) => finished - started,
+            _ => Duration::ZERO,
+        }
+    }
+
+    /// The words that ran, with the ones that took the longest first.
+    pub fn report(&self) -> Vec<WordProfile> {
+        let mut words: Vec<WordProfile> = self.0.borrow().words.values().cloned().collect();
+        words.sort_by(|a, b| {
+            b.time
+                .cmp(&a.time)
+                .then(b.calls.cmp(&a.calls))
+                .then(a.word.cmp(&b.word))
+        });
+        words
+    }
+
+    pub fn write_report<W: Write>(&self, output: &mut W, format: ReportFormat) -> io::Result<()> {
+        let report = self.report();
+        match format {
+            ReportFormat::Text => output.write_all(format_text(&report, self.elapsed()).as_bytes()),
+            ReportFormat::Csv => output.write_all(format_csv(&report).as_bytes()),
+            ReportFormat::Json => {
+                let words: Vec<_> = report
+                    .iter()
+                    .map(|profile| {
+                        json!({
+                            "word": profile.word,
+                            "calls": profile.calls,
+                            "time_us": profile.time.as_micros() as u64,
+                        })
+                    })
+                    .collect();
+                writeln!(output, "{}", json!(words))
+            }
+        }
+    }
+}
+
+impl Default for Profiler {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Hook for Profiler {
+    fn before_instruction(&mut self, vm: &mut VM, ip: usize) -> Result<()> {
+        let now = Instant::now();
+        let return_depth = vm.return_stack.len();
+        let called = vm
+            .return_stack
+            .last()
+            .is_some_and(|top| matches!(top.borrow().data, ValueData::Return(..)));
+        let mut state = self.0.borrow_mut();
+        state.started.get_or_insert(now);
+        state.close(return_depth, called, now);
+
+        let word = vm
+            .environment
+            .as_ref()
+            .and_then(|env| env.borrow().get_called_word(ip));
+        if let Some(word) = word {
+            state
+                .words
+                .entry(word.clone())
+                .or_insert_with(|| WordProfile {
+                    word: word.clone(),
+                    ..WordProfile::default()
+                })
+                .calls += 1;
+            state.pending = Some(Running {
+                word,
+                start: now,
+                return_depth,
+            });
+        }
+
+        Ok(())
+    }
+}
+
+/// Each word's time is also shown as a percentage of `elapsed`. Since a
+/// word's time includes its callees', these don't add up to 100.
+fn format_text(report: &[WordProfile], elapsed: Duration) -> String {
+    let width = report
+        .iter()
+        .map(|profile| profile.word.chars().count())
+        .chain(Some("word".len()))
+        .max()
+        .unwrap_or_default();
+
+    let mut text = String::new();
+    writeln!(
+        text,
+        "{:<width$}  {:>10}  {:>12}  {:>6}",
+        "word", "calls", "time (ms)", "%"
+    )
+    .unwrap();
+    for profile in report {
+        let percent = if elapsed.is_zero() {
+            0.0
+        } else {
+            100.0 * profile.time.as_secs_f64() / elapsed.as_secs_f64()
+        };
+        writeln!(
+            text,
+            "{:<width$}  {:>10}  {:>12.3}  {:>6.1}",
+            profile.word,
+            profile.calls,
+            profile.time.as_secs_f64() * 1000.0,
+            percent
+        )
+        .unwrap();
+    }
+    text
+}
+
+fn format_csv(report: &[WordProfile]) -> String {
+    let mut text = String::from("word,calls,time_us\n");
+    for profile in report {
+        let word = if profile.word.contains([',', '"', '\n']) {
+            format!("\"{}\"", profile.word.replace('"', "\"\""))
+        } else {
+            profile.word.clone()
+        };
+        writeln!(
+            text,
+            "{},{},{}",
+            word,
+            profile.calls,
+            profile.time.as_micros()
+        )
+        .unwrap();
+    }
+    text
+}
+
+#[cfg(test)]
+mod tests;
@@ -0,0 +1,106 @@
+use pretty_assertions::assert_eq;
+
+use super::*;
+use crate::core::Tardi;
+use crate::module::internal::sandbox::SANDBOX;
+
+fn profile(script: &str) -> Profiler {
+    let mut tardi = Tardi::new(None).unwrap();
+    tardi.compile_str(SANDBOX, script).unwrap();
+    let profiler = Profiler::new();
+    tardi.set_hook(Box::new(profiler.clone()));
+    let result = tardi.execute();
+    assert!(result.is_ok(), "Expected Ok, got {:?}", result);
+    profiler.finish();
+    profiler
+}
+
+fn calls(profiler: &Profiler, word: &str) -> Option<u64> {
+    profiler
+        .report()
+        .into_iter()
+        .find(|profile| profile.word == word)
+        .map(|profile| profile.calls)
+}
+
+#[test]
+fn test_profiler_counts_words() {
+    let profiler = profile(": square ( n -- n^2 ) dup * ; 3 square 4 square + 5 [ 1 + ] apply");
+    assert_eq!(calls(&profiler, "square"), Some(2));
+    assert_eq!(calls(&profiler, "dup"), Some(2));
+    assert_eq!(calls(&profiler, "*"), Some(2));
+    assert_eq!(calls(&profiler, "+"), Some(2));
+    assert_eq!(calls(&profiler, "apply"), Some(1));
+    // Literals aren't words.
+    assert_eq!(calls(&profiler, "3"), None);
+}
+
+#[test]
+fn test_profiler_includes_callees() {
+    let profiler =
+        profile(": square ( n -- n^2 ) dup * ; : cube ( n -- n^3 ) dup square * ; 3 cube");
+    let report = profiler.report();
+    let time = |word: &str| report.iter().find(|p| p.word == word).unwrap().time;
+    assert!(time("cube") >= time("square"));
+    assert!(time("square") >= time("dup"));
+    assert!(profiler.elapsed() >= time("cube"));
+}
+
+#[test]
+fn test_profiler_recursion() {
+    let profiler =
+        profile(": countdown ( n -- ) dup 0 > [ 1 - countdown ] [ drop ] if ; 5 countdown");
+    assert_eq!(calls(&profiler, "countdown"), Some(6));
+    let countdown = profiler
+        .report()
+        .into_iter()
+        .find(|p| p.word == "countdown")
+        .unwrap();
+    assert!(profiler.elapsed() >= countdown.time);
+}
+
+#[test]
+fn test_profiler_report_formats() {
+    let profiler = Profiler::new();
+    profiler.0.borrow_mut().words.insert(
+        "a,b".to_string(),
+        WordProfile {
+            word: "a,b".to_string(),
+            calls: 3,
+            time: Duration::from_micros(1500),
+        },
+    );
+
+    let mut output = Vec::new();
+    profiler
+        .write_report(&mut output, ReportFormat::Csv)
+        .unwrap();
+    assert_eq!(
+        String::from_utf8(output).unwrap(),
+        "word,calls,time_us\n\"a,b\",3,1500\n"
+    );
+
+    let mut output = Vec::new();
+    profiler
+        .write_report(&mut output, ReportFormat::Json)
+        .unwrap();
+    let json: serde_json::Value = serde_json::from_slice(&output).unwrap();
+    assert_eq!(
+        json,
+        serde_json::json!([{ "word": "a,b", "calls": 3, "time_us": 1500 }])
+    );
+
+    let mut output = Vec::new();
+    profiler
+        .write_report(&mut output, ReportFormat::Text)
+        .unwrap();
+    let text = String::from_utf8(output).unwrap();
+    let lines: Vec<_> = text.lines().collect();
+    assert_eq!(lines.len(), 2);
+    assert!(lines[0].starts_with("word"));
+    assert!(lines[1].starts_with("a,b"));
+    assert!(lines[1].contains("1.500"));
+
+    assert_eq!("json".parse(), Ok(ReportFormat::Json));
+    assert!("xml".parse::<ReportFormat>().is_err());
+}
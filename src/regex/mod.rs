@@ -0,0 +1,153 @@
+//! Regular expressions, using the `regex` crate.
+//!
+//! That covers the common syntax: literals, `.`, classes, the `\d`, `\w`,
+//! and `\s` escapes, anchors, word boundaries, groups, alternation, and
+//! greedy and lazy quantifiers. Matching takes time linear in the input, so
+//! there aren't any patterns that backtrack forever.
+
+use std::cell::RefCell;
+use std::fmt;
+use std::hash::{Hash, Hasher};
+use std::rc::Rc;
+
+use indexmap::IndexMap;
+
+/// How many compiled patterns are kept. When the cache is full, the one that
+/// was used longest ago is dropped.
+const CACHE_SIZE: usize = 64;
+
+/// A compiled regular expression. Compiling a pattern that's been compiled
+/// recently shares the program that was made then.
+#[derive(Debug, Clone)]
+pub struct Regex {
+    pattern: String,
+    program: Rc<regex::Regex>,
+}
+
+/// Where a group matched, as byte offsets into the input.
+pub type Span = Option<(usize, usize)>;
+
+thread_local! {
+    static COMPILED: RefCell<IndexMap<String, Rc<regex::Regex>>> = RefCell::new(IndexMap::new());
+}
+
+impl Regex {
+    /// Compiles `pattern`, or returns why it isn't valid.
+    pub fn new(pattern: &str) -> Result<Regex, String> {
+        let program = COMPILED.with(|compiled| {
+            let mut compiled = compiled.borrow_mut();
+            // Move it to the end, so the front is the one used longest ago.
+            if let Some(program) = compiled.shift_remove(pattern) {
+                compiled.insert(pattern.to_string(), program.clone());
+                return Ok(program);
+            }
+
+            let program = Rc::new(regex::Regex::new(pattern).map_err(reason)?);
+            if compiled.len() >= CACHE_SIZE {
+                compiled.shift_remove_index(0);
+            }
+            compiled.insert(pattern.to_string(), program.clone());
+            Ok(program)
+        })?;
+        Ok(Regex {
+            pattern: pattern.to_string(),
+            program,
+        })
+    }
+
+    pub fn pattern(&self) -> &str {
+        &self.pattern
+    }
+
+    pub fn is_match(&self, input: &str) -> bool {
+        self.program.is_match(input)
+    }
+
+    /// The spans of the whole match and then each group for the first
+    /// match that starts at or after the byte offset `start`.
+    pub fn captures_at(&self, input: &str, start: usize) -> Option<Vec<Span>> {
+        self.program.captures_at(input, start).map(|captures| {
+            captures
+                .iter()
+                .map(|group| group.map(|group| (group.start(), group.end())))
+                .collect()
+        })
+    }
+
+    /// Replaces each match with `replacement`, where `$0` to `$9` are
+    /// replaced with what the groups matched and `$$` is a `$`.
+    pub fn replace_all(&self, input: &str, replacement: &str) -> String {
+        let mut output = String::new();
+        let mut last = 0;
+        for captures in self.program.captures_iter(input) {
+            let groups: Vec<Span> = captures
+                .iter()
+                .map(|group| group.map(|group| (group.start(), group.end())))
+                .collect();
+            let (from, to) = groups[0].unwrap();
+            output.push_str(&input[last..from]);
+            expand(replacement, input, &groups, &mut output);
+            last = to;
+        }
+        output.push_str(&input[last..]);
+        output
+    }
+}
+
+/// Syntax errors from the `regex` crate show the pattern with a caret under
+/// the problem, and then what the problem is. Only that last part is kept,
+/// so the message fits on one line.
+fn reason(err: regex::Error) -> String {
+    let message = err.to_string();
+    match message.rsplit_once("error: ") {
+        Some((_, reason)) => reason.trim().to_string(),
+        None => message,
+    }
+}
+
+fn expand(replacement: &str, input: &str, groups: &[Span], output: &mut String) {
+    let mut chars = replacement.chars().peekable();
+    while let Some(c) = chars.next() {
+        if c != '$' {
+            output.push(c);
+            continue;
+        }
+        match chars.peek().copied() {
+            Some('$') => {
+                chars.next();
+                output.push('$');
+            }
+            Some(digit @ '0'..='9') => {
+                chars.next();
+                let index = digit.to_digit(10).unwrap() as usize;
+                if let Some(Some((from, to))) = groups.get(index) {
+                    output.push_str(&input[*from..*to]);
+                }
+            }
+            _ => output.push('$'),
+        }
+    }
+}
+
+impl fmt::Display for Regex {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "<regex {:?}>", self.pattern)
+    }
+}
+
+impl PartialEq for Regex {
+    fn eq(&self, other: &Self) -> bool {
+        self.pattern == other.pattern
+    }
+}
+
+impl Eq for Regex {}
+
+impl Hash for Regex {
+    fn hash<H: Hasher>(&self, state: &mut H) {
+        self.pattern.hash(state);
+    }
+}
+
+#[cfg(test)]
+mod tests;
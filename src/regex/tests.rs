@@ -0,0 +1,93 @@
+use pretty_assertions::assert_eq;
+
+use super::*;
+
+fn find<'a>(pattern: &str, input: &'a str) -> Option<&'a str> {
+    let regex = Regex::new(pattern).unwrap();
+    regex
+        .captures_at(input, 0)
+        .map(|groups| groups[0].unwrap())
+        .map(|(from, to)| &input[from..to])
+}
+
+fn groups<'a>(pattern: &str, input: &'a str) -> Vec<Option<&'a str>> {
+    let regex = Regex::new(pattern).unwrap();
+    regex
+        .captures_at(input, 0)
+        .unwrap()
+        .into_iter()
+        .map(|span| span.map(|(from, to)| &input[from..to]))
+        .collect()
+}
+
+#[test]
+fn test_regex_find() {
+    assert_eq!(find("b+", "abbbc"), Some("bbb"));
+    assert_eq!(find("b+?", "abbbc"), Some("b"));
+    assert_eq!(find("^a.c$", "abc"), Some("abc"));
+    assert_eq!(find("^b", "abc"), None);
+    assert_eq!(find("[^a-c]+", "abcdef"), Some("def"));
+    assert_eq!(find(r"\d{2,3}", "a12345"), Some("123"));
+    assert_eq!(find(r"\bcat\b", "concat cat"), Some("cat"));
+    assert_eq!(find("cat|dog", "hotdog"), Some("dog"));
+    assert_eq!(find("x*", "abc"), Some(""));
+    assert_eq!(find("é+", "caféé!"), Some("éé"));
+    assert_eq!(find("a{2}", "a{2}aa"), Some("aa"));
+}
+
+#[test]
+fn test_regex_long_inputs() {
+    // These would overflow the stack or backtrack for a very long time in a
+    // backtracking engine.
+    let long = "x".repeat(100_000);
+    assert_eq!(find(".*", &long).map(str::len), Some(long.len()));
+    assert_eq!(find("(a*)*b", &"a".repeat(40)), None);
+}
+
+#[test]
+fn test_regex_groups() {
+    assert_eq!(
+        groups(r"(\w+)@(\w+)\.com", "mail bob@example.com"),
+        vec![Some("bob@example.com"), Some("bob"), Some("example")]
+    );
+    assert_eq!(groups("(a)|(b)", "b"), vec![Some("b"), None, Some("b")]);
+    assert_eq!(
+        groups("(?:ab)+(c)", "ababc"),
+        vec![Some("ababc"), Some("c")]
+    );
+}
+
+#[test]
+fn test_regex_replace_all() {
+    let regex = Regex::new(r"(\w+)=(\d+)").unwrap();
+    assert_eq!(regex.replace_all("a=1, b=22", "$2:$1"), "1:a, 22:b");
+    let regex = Regex::new("x*").unwrap();
+    assert_eq!(regex.replace_all("abc", "-"), "-a-b-c-");
+    let regex = Regex::new("o").unwrap();
+    assert_eq!(regex.replace_all("foo", "$$"), "f$$");
+}
+
+#[test]
+fn test_regex_errors() {
+    for pattern in ["(a", "a)", "[a", "*a", r"\q", "[z-a]", "a{3,1}", "\\"] {
+        assert!(
+            Regex::new(pattern).is_err(),
+            "{:?} should be an error",
+            pattern
+        );
+    }
+}
+
+#[test]
+fn test_regex_cache() {
+    let first = Regex::new("cached+").unwrap();
+    let second = Regex::new("cached+").unwrap();
+    assert!(Rc::ptr_eq(&first.program, &second.program));
+
+    // Once enough other patterns have been compiled, it's compiled again.
+    for i in 0..CACHE_SIZE {
+        Regex::new(&format!("other{}", i)).unwrap();
+    }
+    let third = Regex::new("cached+").unwrap();
+    assert!(!Rc::ptr_eq(&first.program, &third.program));
+}
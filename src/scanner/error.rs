@@ -9,12 +9,22 @@ pub type ScannerResult<T> = result::Result<T, ScannerError>;
 
 #[derive(Debug)]
 pub enum ScannerError {
-    InvalidNumber(String),
+    InvalidNumber {
+        number: String,
+        reason: String,
+        line: usize,
+        column: usize,
+    },
     InvalidLiteral(String),
     UnexpectedCharacter(char),
     UnterminatedString,
     UnterminatedChar,
-    InvalidEscapeSequence(String),
+    UnterminatedComment,
+    InvalidEscapeSequence {
+        reason: String,
+        line: usize,
+        column: usize,
+    },
     IoError(io::Error),
     UnexpectedEndOfInput,
     NotInitialized,
@@ -23,12 +33,30 @@ pub enum ScannerError {
 impl fmt::Display for ScannerError {
     fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
         match self {
-            ScannerError::InvalidNumber(s) => write!(f, "Invalid number: {}", s),
+            ScannerError::InvalidNumber {
+                number,
+                reason,
+                line,
+                column,
+            } => write!(
+                f,
+                "Invalid number {} at line {}, column {}: {}",
+                number, line, column, reason
+            ),
             ScannerError::InvalidLiteral(s) => write!(f, "Invalid literal: {}", s),
             ScannerError::UnexpectedCharacter(c) => write!(f, "Unexpected character: {}", c),
             ScannerError::UnterminatedString => write!(f, "Unterminated string"),
             ScannerError::UnterminatedChar => write!(f, "Unterminated character literal"),
-            ScannerError::InvalidEscapeSequence(s) => write!(f, "Invalid escape sequence: {}", s),
+            ScannerError::UnterminatedComment => write!(f, "Unterminated comment"),
+            ScannerError::InvalidEscapeSequence {
+                reason,
+                line,
+                column,
+            } => write!(
+                f,
+                "Invalid escape sequence at line {}, column {}: {}",
+                line, column, reason
+            ),
             ScannerError::IoError(err) => err.fmt(f),
             ScannerError::UnexpectedEndOfInput => write!(f, "End of input"),
             ScannerError::NotInitialized => write!(f, "Scanner not initialized"),
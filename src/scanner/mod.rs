@@ -2,11 +2,15 @@ pub mod error;
 
 use crate::module::internal::sandbox::SANDBOX;
 use crate::scanner::error::{ScannerError, ScannerResult};
-use crate::value::{Value, ValueData};
+use crate::value::{Keyword, Pos, Value, ValueData};
 use std::convert::TryFrom;
+use std::io::{self, Read};
 use std::iter::from_fn;
 use std::path::{Path, PathBuf};
-use std::{char, fs, result};
+use std::{char, fs, mem, result, str};
+
+/// How many bytes a streaming scanner reads at a time.
+const CHUNK_SIZE: usize = 8192;
 
 #[derive(Debug, Default)]
 pub enum Source {
@@ -61,20 +65,80 @@ impl TryFrom<Source> for Scanner {
     }
 }
 
+/// Where a streaming scanner gets more input from.
+struct Reader {
+    inner: Box<dyn Read>,
+
+    /// The start of a UTF-8 character that was split between reads.
+    pending: Vec<u8>,
+}
+
+impl Reader {
+    /// Reads the next chunk, up to the last whole character in it. This
+    /// returns `None` at the end of the input.
+    fn read_chunk(&mut self) -> ScannerResult<Option<String>> {
+        let mut buffer = [0; CHUNK_SIZE];
+        let n = loop {
+            match self.inner.read(&mut buffer) {
+                Ok(n) => break n,
+                Err(err) if err.kind() == io::ErrorKind::Interrupted => continue,
+                Err(err) => return Err(err.into()),
+            }
+        };
+        if n == 0 {
+            if self.pending.is_empty() {
+                return Ok(None);
+            }
+            return Err(invalid_utf8());
+        }
+
+        self.pending.extend_from_slice(&buffer[..n]);
+        let valid = match str::from_utf8(&self.pending) {
+            Ok(text) => text.len(),
+            // The chunk ended partway through a character.
+            Err(err) if err.error_len().is_none() => err.valid_up_to(),
+            Err(_) => return Err(invalid_utf8()),
+        };
+        let rest = self.pending.split_off(valid);
+        let text = mem::replace(&mut self.pending, rest);
+        Ok(Some(String::from_utf8(text).unwrap()))
+    }
+}
+
+fn invalid_utf8() -> ScannerError {
+    io::Error::new(
+        io::ErrorKind::InvalidData,
+        "stream did not contain valid UTF-8",
+    )
+    .into()
+}
+
 /// Scanner that converts source text into a stream of tokens
 pub struct Scanner {
     /// The source that the input is from.
     pub source: Source,
 
-    /// Source text being scanned
+    /// Source text being scanned. For a streaming scanner, this is only
+    /// what's been read and not discarded yet.
     input: String,
 
-    /// Vector of source characters
+    /// The offset of the start of `input` in the source.
+    input_offset: usize,
+
+    /// Vector of source characters, the same ones as in `input`.
     chars: Vec<char>,
 
     /// Index of current character (in `chars`).
     index: usize,
 
+    /// Where more input comes from, for a streaming scanner that hasn't
+    /// read all of it yet.
+    reader: Option<Reader>,
+
+    /// An error from reading, which is returned once the input before it
+    /// has been scanned.
+    read_error: Option<ScannerError>,
+
     /// Current line number (1-based)
     line: usize,
 
@@ -83,6 +147,10 @@ pub struct Scanner {
 
     /// Current offset from start of `source`
     offset: usize,
+
+    /// The vocabularies (`vocab: name ... ;vocab`) that we're in, innermost
+    /// last. Words are scanned into the innermost one.
+    vocab_stack: Vec<String>,
 }
 
 impl Default for Scanner {
@@ -92,11 +160,15 @@ impl Default for Scanner {
         Scanner {
             source: Source::InputString,
             input: source,
+            input_offset: 0,
             chars,
             index: 0,
+            reader: None,
+            read_error: None,
             line: 1,
             column: 1,
             offset: 0,
+            vocab_stack: Vec::new(),
         }
     }
 }
@@ -165,6 +237,30 @@ impl Scanner {
         }
     }
 
+    /// Creates a new Scanner that reads its input lazily from `reader`.
+    ///
+    /// Only about a chunk of the input is kept in memory at a time, so this
+    /// works for large files and for input that's still arriving.
+    ///
+    /// # Arguments
+    ///
+    /// * `source` - Where the input is from
+    /// * `reader` - The reader to scan
+    ///
+    /// # Returns
+    ///
+    /// A new Scanner instance that hasn't read anything yet
+    pub fn from_reader<R: Read + 'static>(source: Source, reader: R) -> Self {
+        Scanner {
+            source,
+            reader: Some(Reader {
+                inner: Box::new(reader),
+                pending: Vec::new(),
+            }),
+            ..Scanner::default()
+        }
+    }
+
     pub fn from_internal_module(name: &str, input: &str) -> Self {
         let name = name.to_string();
         let source = Source::Internal { name };
@@ -177,10 +273,42 @@ impl Scanner {
         }
     }
 
+    /// The module that words are being scanned into. This is the innermost
+    /// vocabulary, if there is one, or the source's module.
+    pub fn module_key(&self) -> String {
+        self.vocab_stack
+            .last()
+            .cloned()
+            .unwrap_or_else(|| self.source.get_key())
+    }
+
+    /// Where the scanner is in the input. After an error, this is how far
+    /// it had read.
+    pub fn pos(&self) -> Pos {
+        Pos {
+            line: self.line,
+            column: self.column,
+            offset: self.offset,
+            length: 0,
+        }
+    }
+
+    /// Starts scanning words into the vocabulary `name`.
+    pub fn push_vocab(&mut self, name: &str) {
+        self.vocab_stack.push(name.to_string());
+    }
+
+    /// Finishes the innermost vocabulary, returning its name.
+    pub fn pop_vocab(&mut self) -> Option<String> {
+        self.vocab_stack.pop()
+    }
+
     pub fn set_input_string(&mut self, input: &str) {
         self.source = Source::InputString;
         self.input = input.to_string();
+        self.input_offset = 0;
         self.chars = input.chars().collect();
+        self.reader = None;
     }
 
     /// Scans and returns the next value from the input.
@@ -199,9 +327,10 @@ impl Scanner {
         self.skip_whitespace();
 
         // Check if we've reached the end of input
-        if self.index >= self.chars.len() {
-            return None;
+        if self.peek().is_none() {
+            return self.read_error.take().map(Err);
         }
+        self.discard_scanned();
 
         // Record start position of token
         let start_line = self.line;
@@ -212,7 +341,7 @@ impl Scanner {
             Some('"') => self.scan_any_string(),
             Some('\'') => self.scan_char(),
             Some(c) => match self.scan_word(c) {
-                Ok(Some(ValueData::Word(w))) => Ok(self.parse_word(&w)),
+                Ok(Some(ValueData::Word(w))) => self.parse_word(&w, start_line, start_column),
                 Ok(Some(vd)) => Ok(vd),
                 Ok(None) => {
                     return self.scan_value();
@@ -299,7 +428,8 @@ impl Scanner {
         let delimiter_chars: Vec<char> = delimiter.chars().collect();
         let delimiter_len = delimiter_chars.len();
 
-        while self.index < self.chars.len() {
+        while self.peek().is_some() {
+            self.fill(delimiter_len);
             if self.chars[self.index..].starts_with(&delimiter_chars) {
                 for _ in 0..delimiter_len {
                     self.next_char();
@@ -323,7 +453,7 @@ impl Scanner {
         let length = self.offset - start_offset;
         Value::from_parts(
             value_data,
-            &self.input[start_offset..self.offset],
+            &self.input[start_offset - self.input_offset..self.offset - self.input_offset],
             start_line,
             start_column,
             start_offset,
@@ -331,12 +461,13 @@ impl Scanner {
         )
     }
 
-    /// Scans hexadecimal digits up to the specified length
-    fn scan_hex_digits(&mut self, max_len: usize) -> ScannerResult<u32> {
+    /// Scans hexadecimal digits up to the specified length. This is `None`
+    /// if there aren't any.
+    fn scan_hex_digits(&mut self, max_len: usize) -> Option<u32> {
         let mut value = 0u32;
         let mut count = 0;
 
-        while let Some(&c) = self.chars.get(self.index) {
+        while let Some(c) = self.peek() {
             if count >= max_len {
                 break;
             }
@@ -352,16 +483,23 @@ impl Scanner {
         }
 
         if count == 0 {
-            return Err(ScannerError::InvalidEscapeSequence(
-                "Expected hexadecimal digits".to_string(),
-            ));
+            None
+        } else {
+            Some(value)
         }
-
-        Ok(value)
     }
 
-    /// Processes an escape sequence in a string or character literal
+    /// Processes an escape sequence in a string or character literal. The
+    /// backslash has already been read, and errors point to it.
     fn process_escape_sequence(&mut self) -> ScannerResult<char> {
+        let line = self.line;
+        let column = self.column - 1;
+        let invalid = |reason: String| ScannerError::InvalidEscapeSequence {
+            reason,
+            line,
+            column,
+        };
+
         match self.next_char() {
             Some('n') => Ok('\n'),
             Some('r') => Ok('\r'),
@@ -370,38 +508,27 @@ impl Scanner {
             Some('\'') => Ok('\''),
             Some('"') => Ok('"'),
             Some('u') => {
-                match self.peek() {
+                let value = match self.peek() {
                     Some('{') => {
-                        // Unicode escape \u{XXXX}
+                        // Unicode escape \u{XXXXXX}
                         self.next_char(); // consume '{'
-                        let value = self.scan_hex_digits(6)?;
-                        match self.next_char() {
-                            Some('}') => match char::from_u32(value) {
-                                Some(c) => Ok(c),
-                                None => Err(ScannerError::InvalidEscapeSequence(format!(
-                                    "Invalid Unicode codepoint: {}",
-                                    value
-                                ))),
-                            },
-                            _ => Err(ScannerError::InvalidEscapeSequence(
-                                "Expected closing '}'".to_string(),
-                            )),
+                        let value = self
+                            .scan_hex_digits(6)
+                            .ok_or_else(|| invalid("Expected hexadecimal digits".to_string()))?;
+                        if self.next_char() != Some('}') {
+                            return Err(invalid("Expected closing '}'".to_string()));
                         }
+                        value
                     }
-                    _ => {
-                        // ASCII escape \uXX
-                        let value = self.scan_hex_digits(2)?;
-                        if value > 0x7F {
-                            return Err(ScannerError::InvalidEscapeSequence(format!(
-                                "ASCII value out of range: {}",
-                                value
-                            )));
-                        }
-                        Ok(char::from_u32(value).unwrap())
-                    }
-                }
+                    // Unicode escape \uXXXX, which can be shorter, like \u41
+                    _ => self
+                        .scan_hex_digits(4)
+                        .ok_or_else(|| invalid("Expected hexadecimal digits".to_string()))?,
+                };
+                char::from_u32(value)
+                    .ok_or_else(|| invalid(format!("Invalid Unicode codepoint: {}", value)))
             }
-            Some(c) => Err(ScannerError::InvalidEscapeSequence(format!("\\{}", c))),
+            Some(c) => Err(invalid(format!("\\{}", c))),
             None => Err(ScannerError::UnterminatedChar),
         }
     }
@@ -431,8 +558,7 @@ impl Scanner {
         let mut quote_count = 0;
 
         // Check for triple quotes
-        if self.chars.get(self.index) == Some(&'"') && self.chars.get(self.index + 1) == Some(&'"')
-        {
+        if self.peek() == Some('"') && self.peek_at(1) == Some('"') {
             is_triple = true;
             // Consume the remaining two quotes
             if self.next_char() != Some('"') || self.next_char() != Some('"') {
@@ -475,8 +601,49 @@ impl Scanner {
     }
 
     /// Peeks at the next character without consuming it
-    fn peek(&self) -> Option<char> {
-        self.chars.get(self.index).copied()
+    fn peek(&mut self) -> Option<char> {
+        self.peek_at(0)
+    }
+
+    /// Peeks at the character `n` after the next one
+    fn peek_at(&mut self, n: usize) -> Option<char> {
+        self.fill(n + 1);
+        self.chars.get(self.index + n).copied()
+    }
+
+    /// Reads from a streaming scanner's reader until there are at least `n`
+    /// characters after the current one, or the input runs out.
+    fn fill(&mut self, n: usize) {
+        while self.chars.len() < self.index + n {
+            let reader = match self.reader.as_mut() {
+                Some(reader) => reader,
+                None => return,
+            };
+            match reader.read_chunk() {
+                Ok(Some(text)) => {
+                    self.chars.extend(text.chars());
+                    self.input.push_str(&text);
+                }
+                Ok(None) => self.reader = None,
+                Err(err) => {
+                    self.read_error = Some(err);
+                    self.reader = None;
+                }
+            }
+        }
+    }
+
+    /// Drops the input that's already been scanned, so a streaming scanner
+    /// only holds about a chunk at a time. Values copy their lexemes out of
+    /// `input`, so this happens between them, and not on every one.
+    fn discard_scanned(&mut self) {
+        if self.reader.is_none() || self.index < CHUNK_SIZE {
+            return;
+        }
+        self.chars.drain(..self.index);
+        self.input.drain(..self.offset - self.input_offset);
+        self.input_offset = self.offset;
+        self.index = 0;
     }
 
     /// Consumes and returns the next character
@@ -518,16 +685,24 @@ impl Scanner {
         }
     }
 
-    fn parse_word(&self, lexeme: &str) -> ValueData {
+    /// Parses a word that starts at `line` and `column`. That's only used
+    /// to report where a malformed number is.
+    fn parse_word(&self, lexeme: &str, line: usize, column: usize) -> ScannerResult<ValueData> {
         // Try parsing in order of specificity
         // TODO: have it look in imported/defined names for one and use that module here?
         // TODO: parse `module/word` pairs into symbols
-        self.parse_boolean(lexeme)
-            .or_else(|| self.parse_number(lexeme))
+        if let Some(value) = self.parse_boolean(lexeme) {
+            return Ok(value);
+        }
+        if let Some(value) = self.parse_radix_integer(lexeme, line, column)? {
+            return Ok(value);
+        }
+        Ok(self
+            .parse_number(lexeme)
             .unwrap_or_else(|| ValueData::Symbol {
-                module: self.source.get_key(),
+                module: self.module_key(),
                 word: lexeme.to_string(),
-            })
+            }))
     }
 
     fn parse_boolean(&self, lexeme: &str) -> Option<ValueData> {
@@ -544,21 +719,62 @@ impl Scanner {
             return Some(ValueData::Integer(number));
         }
 
-        // Then try float parsing
-        if let Ok(number) = lexeme.parse::<f64>() {
-            return Some(ValueData::Float(number));
+        // Then try float parsing. This handles exponential notation (1e-10) too.
+        // Rust also parses `inf` and `NaN`, but those should stay words.
+        if lexeme.chars().any(|c| c.is_ascii_digit()) {
+            if let Ok(number) = lexeme.parse::<f64>() {
+                return Some(ValueData::Float(number));
+            }
         }
 
         // TODO: Future number format support:
-        // - Binary (0b prefix)
-        // - Octal (0o prefix)
-        // - Hexadecimal (0x prefix)
         // - Rational type (e.g., 3/4)
-        // - Exponential notation (e.g., 1e-10)
-        // - Floats without leading digit (e.g., .5)
         None
     }
 
+    /// Parses integers with a radix prefix, like `0xFF`, `0o755`, and
+    /// `-0b1010`. Anything that starts with a prefix has to be a valid
+    /// integer, so `0x` and `0b2` are errors rather than words.
+    fn parse_radix_integer(
+        &self,
+        lexeme: &str,
+        line: usize,
+        column: usize,
+    ) -> ScannerResult<Option<ValueData>> {
+        let (sign, unsigned) = match lexeme.strip_prefix(['+', '-']) {
+            Some(rest) => (&lexeme[..1], rest),
+            None => ("", lexeme),
+        };
+        let (radix, name) = match unsigned.get(..2) {
+            Some("0x") => (16, "hexadecimal"),
+            Some("0o") => (8, "octal"),
+            Some("0b") => (2, "binary"),
+            _ => return Ok(None),
+        };
+        let digits = &unsigned[2..];
+        let prefix_len = sign.len() + 2;
+        let invalid = |offset: usize, reason: String| ScannerError::InvalidNumber {
+            number: lexeme.to_string(),
+            reason,
+            line,
+            column: column + offset,
+        };
+
+        if digits.is_empty() {
+            return Err(invalid(0, format!("it doesn't have any {} digits", name)));
+        }
+        if let Some((i, c)) = digits.chars().enumerate().find(|(_, c)| !c.is_digit(radix)) {
+            return Err(invalid(
+                prefix_len + i,
+                format!("`{}` isn't a {} digit", c, name),
+            ));
+        }
+
+        i64::from_str_radix(&format!("{}{}", sign, digits), radix)
+            .map(|n| Some(ValueData::Integer(n)))
+            .map_err(|_| invalid(0, "it's too big for an integer".to_string()))
+    }
+
     /// Scans a word (any sequence of non-whitespace characters)
     fn scan_word(&mut self, first_char: char) -> ScannerResult<Option<ValueData>> {
         let word = self.scan_word_chars(first_char);
@@ -570,11 +786,78 @@ impl Scanner {
         }
 
         match word.as_str() {
+            "(" => {
+                self.scan_comment_words("(", ")")?;
+                Ok(None)
+            }
+            "(*" => {
+                self.scan_comment_words("(*", "*)")?;
+                Ok(None)
+            }
             "MACRO:" => Ok(Some(ValueData::Macro)),
+            _ if Keyword::is_keyword(&word) => {
+                Ok(Some(ValueData::Keyword(Keyword::new(&word[1..]))))
+            }
             _ => Ok(Some(ValueData::Word(word))),
         }
     }
 
+    /// Scans a stack effect, `( a b -- c )`, returning the words in it. This
+    /// lets definitions read what would otherwise be skipped as a comment.
+    pub fn scan_stack_effect(&mut self) -> ScannerResult<Vec<String>> {
+        self.skip_whitespace();
+        match self.next_char() {
+            Some(c) => {
+                let word = self.scan_word_chars(c);
+                if word != "(" {
+                    return Err(ScannerError::InvalidLiteral(format!(
+                        "expected a stack effect, but got {}",
+                        word
+                    )));
+                }
+            }
+            None => return Err(ScannerError::UnexpectedEndOfInput),
+        }
+        self.scan_comment_words("(", ")")
+    }
+
+    /// Scans a stack effect if the next word starts one. Otherwise, nothing
+    /// is consumed and this returns `None`.
+    pub fn scan_optional_stack_effect(&mut self) -> ScannerResult<Option<Vec<String>>> {
+        self.skip_whitespace();
+        let starts_effect =
+            self.peek() == Some('(') && self.peek_at(1).map_or(true, |c| c.is_ascii_whitespace());
+        if starts_effect {
+            self.scan_stack_effect().map(Some)
+        } else {
+            Ok(None)
+        }
+    }
+
+    /// Scans the words in a comment after its opening word, up to its
+    /// matching closing word. Comments nest, so `( a ( b ) )` is one comment.
+    fn scan_comment_words(&mut self, open: &str, close: &str) -> ScannerResult<Vec<String>> {
+        let mut words = Vec::new();
+        let mut depth = 0;
+
+        loop {
+            self.skip_whitespace();
+            let word = match self.next_char() {
+                Some(c) => self.scan_word_chars(c),
+                None => return Err(ScannerError::UnterminatedComment),
+            };
+            if word == close {
+                if depth == 0 {
+                    return Ok(words);
+                }
+                depth -= 1;
+            } else if word == open {
+                depth += 1;
+            }
+            words.push(word);
+        }
+    }
+
     /// Scans the characters that make up a word
     fn scan_word_chars(&mut self, first_char: char) -> String {
         let mut word = String::from(first_char);
@@ -591,6 +874,14 @@ impl Scanner {
     }
 }
 
+impl Iterator for Scanner {
+    type Item = ScannerResult<Value>;
+
+    fn next(&mut self) -> Option<Self::Item> {
+        self.scan_value()
+    }
+}
+
 fn input_chars(input: &str) -> (String, Vec<char>) {
     let input = input.to_string();
     let chars = input.chars().collect();
@@ -192,6 +192,117 @@ fn test_scan_floats() {
     // assert!(scanner.next().unwrap().is_err()); // ".14" is invalid (no leading digit)
 }
 
+#[test]
+fn test_scan_float_exponents_and_signs() {
+    let mut tokens = scan("1e-9 -0.5 2.5E3 inf NaN");
+
+    let token = assert_top(&mut tokens, 1, 1, 4, Some("1e-9"));
+    assert_eq!(token.data, ValueData::Float(1e-9));
+    let token = assert_top(&mut tokens, 1, 6, 4, Some("-0.5"));
+    assert_eq!(token.data, ValueData::Float(-0.5));
+    let token = assert_top(&mut tokens, 1, 11, 5, Some("2.5E3"));
+    assert_eq!(token.data, ValueData::Float(2500.0));
+
+    // These parse as floats in Rust, but they should be words here.
+    let token = assert_top(&mut tokens, 1, 17, 3, Some("inf"));
+    assert_eq!(token.data.get_word(), Some("inf"));
+    let token = assert_top(&mut tokens, 1, 21, 3, Some("NaN"));
+    assert_eq!(token.data.get_word(), Some("NaN"));
+}
+
+#[test]
+fn test_scan_radix_integers() {
+    let mut tokens = scan("0xFF 0o755 0b1010 -0x10 +0b1");
+
+    let token = assert_top(&mut tokens, 1, 1, 4, Some("0xFF"));
+    assert_eq!(token.data, ValueData::Integer(255));
+    let token = assert_top(&mut tokens, 1, 6, 5, Some("0o755"));
+    assert_eq!(token.data, ValueData::Integer(0o755));
+    let token = assert_top(&mut tokens, 1, 12, 6, Some("0b1010"));
+    assert_eq!(token.data, ValueData::Integer(10));
+    let token = assert_top(&mut tokens, 1, 19, 5, Some("-0x10"));
+    assert_eq!(token.data, ValueData::Integer(-16));
+    let token = assert_top(&mut tokens, 1, 25, 4, Some("+0b1"));
+    assert_eq!(token.data, ValueData::Integer(1));
+}
+
+#[test]
+fn test_scan_malformed_radix_integers() {
+    let tokens = scan_raw("1 0x");
+    assert!(matches!(
+        tokens.last(),
+        Some(Err(ScannerError::InvalidNumber {
+            line: 1,
+            column: 3,
+            ..
+        }))
+    ));
+
+    let tokens = scan_raw("1\n  0b102");
+    assert!(matches!(
+        tokens.last(),
+        Some(Err(ScannerError::InvalidNumber {
+            line: 2,
+            column: 7,
+            ..
+        }))
+    ));
+
+    let tokens = scan_raw("0x8000000000000000");
+    assert!(matches!(
+        tokens.last(),
+        Some(Err(ScannerError::InvalidNumber { .. }))
+    ));
+}
+
+#[test]
+fn test_scan_string_escapes() {
+    let tokens = scan(r#""\n \t \\ \" \u00e9" "\u41\u42\u43" "\u{1F642}" '\u00e9'"#);
+    let data: Vec<_> = tokens.into_iter().map(|token| token.data).collect();
+    assert_eq!(
+        data,
+        vec![
+            ValueData::String("\n \t \\ \" é".to_string()),
+            ValueData::String("ABC".to_string()),
+            ValueData::String("🙂".to_string()),
+            ValueData::Char('é'),
+        ]
+    );
+}
+
+#[test]
+fn test_scan_raw_strings() {
+    // Triple-quoted strings don't have escapes.
+    let mut tokens = scan(r#""""\d+\n "quoted" \q""""#);
+    let token = top(&mut tokens);
+    assert_eq!(
+        token.data,
+        ValueData::String(r#"\d+\n "quoted" \q"#.to_string())
+    );
+}
+
+#[test]
+fn test_scan_invalid_escapes() {
+    // The error points to the backslash.
+    for (input, line, column) in [
+        (r#""ok\q""#, 1, 4),
+        ("1 2\n  \"a \\q\"", 2, 6),
+        (r#"'\q'"#, 1, 2),
+        (r#""\u{110000}""#, 1, 2),
+        (r#""\uD800""#, 1, 2),
+        (r#""\u{41""#, 1, 2),
+        (r#""\ux""#, 1, 2),
+    ] {
+        let tokens = scan_raw(input);
+        match tokens.iter().find(|token| token.is_err()) {
+            Some(Err(ScannerError::InvalidEscapeSequence {
+                line: l, column: c, ..
+            })) => assert_eq!((*l, *c), (line, column), "for {}", input),
+            token => panic!("expected an invalid escape for {}, got {:?}", input, token),
+        }
+    }
+}
+
 #[test]
 fn test_scan_booleans() {
     let mut tokens = scan_raw("#t #f #x");
@@ -247,6 +358,70 @@ fn test_scan_comments() {
     assert!(tokens.is_empty());
 }
 
+#[test]
+fn test_scan_paren_comments() {
+    let mut tokens =
+        scan(": sq ( n -- n*n ) dup * ;\n( nested ( parens ) here ) 42 (not-a-comment)");
+
+    assert_eq!(top(&mut tokens).data.get_word(), Some(":"));
+    assert_eq!(top(&mut tokens).data.get_word(), Some("sq"));
+    assert_eq!(top(&mut tokens).data.get_word(), Some("dup"));
+    assert_eq!(top(&mut tokens).data.get_word(), Some("*"));
+    assert_eq!(top(&mut tokens).data.get_word(), Some(";"));
+    let token = assert_top(&mut tokens, 2, 28, 2, Some("42"));
+    assert_eq!(token.data, ValueData::Integer(42));
+    assert_eq!(top(&mut tokens).data.get_word(), Some("(not-a-comment)"));
+    assert!(tokens.is_empty());
+}
+
+#[test]
+fn test_scan_block_comments_nest() {
+    let mut tokens = scan("1 (* one\n (* two *) still\n*) 2");
+
+    let token = top(&mut tokens);
+    assert_eq!(token.data, ValueData::Integer(1));
+    let token = assert_top(&mut tokens, 3, 4, 1, Some("2"));
+    assert_eq!(token.data, ValueData::Integer(2));
+    assert!(tokens.is_empty());
+}
+
+#[test]
+fn test_scan_unterminated_comment() {
+    let tokens = scan_raw("1 (* never closed");
+    assert!(matches!(
+        tokens.last(),
+        Some(Err(ScannerError::UnterminatedComment))
+    ));
+
+    let tokens = scan_raw("1 ( never closed");
+    assert!(matches!(
+        tokens.last(),
+        Some(Err(ScannerError::UnterminatedComment))
+    ));
+}
+
+#[test]
+fn test_scan_stack_effect() {
+    let mut scanner = Scanner::from_input_string("  ( a b -- c ) rest");
+    let effect = scanner.scan_stack_effect().unwrap();
+    assert_eq!(effect, vec!["a", "b", "--", "c"]);
+    let rest = scanner.scan_value().unwrap().unwrap();
+    assert_eq!(rest.data.get_word(), Some("rest"));
+
+    let mut scanner = Scanner::from_input_string("dup");
+    assert!(scanner.scan_stack_effect().is_err());
+}
+
+#[test]
+fn test_scan_optional_stack_effect() {
+    let mut scanner = Scanner::from_input_string("  ( a -- ) (* b *) dup");
+    let effect = scanner.scan_optional_stack_effect().unwrap();
+    assert_eq!(effect, Some(vec!["a".to_string(), "--".to_string()]));
+    assert_eq!(scanner.scan_optional_stack_effect().unwrap(), None);
+    let rest = scanner.scan_value().unwrap().unwrap();
+    assert_eq!(rest.data.get_word(), Some("dup"));
+}
+
 #[test]
 fn test_set_source() {
     let scanner = Scanner::from_input_string("something something here");
@@ -433,3 +608,96 @@ fn test_multi_byte_utf8_characters() {
     assert_eq!(scanner.column, 12); // 'world' starts at column 12 (1-based)
     assert_eq!(scanner.offset, 21); // 'こんにちは' is 15 bytes + 1 space + 5 bytes for 'world'
 }
+
+#[test]
+fn test_scan_symbols() {
+    let mut tokens = scan(":red : :: :a:b");
+
+    let token = assert_top(&mut tokens, 1, 1, 4, Some(":red"));
+    assert!(matches!(token.data, ValueData::Keyword(ref k) if k.name() == "red"));
+    let token = assert_top(&mut tokens, 1, 6, 1, Some(":"));
+    assert!(matches!(token.data, ValueData::Symbol { ref word, .. } if word == ":"));
+    let token = assert_top(&mut tokens, 1, 8, 2, Some("::"));
+    assert!(matches!(token.data, ValueData::Symbol { ref word, .. } if word == "::"));
+    let token = assert_top(&mut tokens, 1, 11, 4, Some(":a:b"));
+    assert!(matches!(token.data, ValueData::Keyword(ref k) if k.name() == "a:b"));
+}
+
+/// A reader that hands out its input a few bytes at a time, so characters
+/// and tokens get split between reads.
+struct Trickle {
+    input: Vec<u8>,
+    at: usize,
+    size: usize,
+}
+
+impl Read for Trickle {
+    fn read(&mut self, buffer: &mut [u8]) -> io::Result<usize> {
+        let n = self.size.min(buffer.len()).min(self.input.len() - self.at);
+        buffer[..n].copy_from_slice(&self.input[self.at..self.at + n]);
+        self.at += n;
+        Ok(n)
+    }
+}
+
+fn trickle(input: &[u8], size: usize) -> Scanner {
+    let reader = Trickle {
+        input: input.to_vec(),
+        at: 0,
+        size,
+    };
+    Scanner::from_reader(Source::InputString, reader)
+}
+
+#[test]
+fn test_streaming_matches_input_string() {
+    let input =
+        "こんにちは \"wörld\" 'é' ( a -- b )\n  0xFF 3.14 :red\n\"\"\"triple \"é\" \"\"\" end";
+    // Values only compare their data, so this compares where they are too.
+    let parts = |tokens: Vec<Value>| -> Vec<_> {
+        tokens
+            .into_iter()
+            .map(|token| (token.data, token.lexeme, token.pos))
+            .collect()
+    };
+    let expected = parts(scan(input));
+    for size in [1, 2, 3, 5, 7] {
+        let tokens: ScannerResult<Vec<Value>> = trickle(input.as_bytes(), size).collect();
+        assert_eq!(
+            parts(tokens.unwrap()),
+            expected,
+            "reading {} bytes at a time",
+            size
+        );
+    }
+}
+
+#[test]
+fn test_streaming_positions_across_chunks() {
+    // This is long enough that the scanner discards what it's read.
+    let line = "héllo wörld\n";
+    let input = line.repeat(CHUNK_SIZE / 4);
+    let tokens: ScannerResult<Vec<Value>> = trickle(input.as_bytes(), 1000).collect();
+    let tokens = tokens.unwrap();
+    assert_eq!(tokens.len(), CHUNK_SIZE / 2);
+
+    let last = tokens.last().unwrap();
+    let pos = last.pos.as_ref().unwrap();
+    assert_eq!(last.lexeme, Some("wörld".to_string()));
+    assert_eq!(pos.line, CHUNK_SIZE / 4);
+    assert_eq!(pos.column, 7);
+    assert_eq!(pos.offset, input.len() - "wörld\n".len());
+    assert_eq!(pos.length, "wörld".len());
+}
+
+#[test]
+fn test_streaming_invalid_utf8() {
+    let tokens: Vec<_> = trickle(b"ok \xff", 1).collect();
+    assert_eq!(tokens.len(), 2);
+    assert!(tokens[0].is_ok());
+    assert!(matches!(tokens[1], Err(ScannerError::IoError(_))));
+
+    // A character that's cut off at the end is invalid too.
+    let tokens: Vec<_> = trickle("ok é".as_bytes().split_last().unwrap().1, 1).collect();
+    assert!(matches!(tokens.last(), Some(Err(ScannerError::IoError(_)))));
+}
@@ -13,3 +13,45 @@ fn test_tardi_execute_str() {
     let stack = tardi.stack();
     assert_eq!(stack, vec![ValueData::Integer(42).into()]);
 }
+
+#[test]
+fn test_is_incomplete() {
+    assert!(!is_incomplete("1 2 +"));
+    assert!(!is_incomplete(": double 2 * ;"));
+    assert!(!is_incomplete("{ 1 2 } [ dup * ] map"));
+
+    assert!(is_incomplete("[ 1 2"));
+    assert!(is_incomplete("{ 1 [ 2 ]"));
+    assert!(is_incomplete(": double\n  2 *"));
+    assert!(is_incomplete("\"unterminated"));
+    assert!(is_incomplete("(* a\ncomment"));
+    assert!(is_incomplete(":"));
+}
+
+#[test]
+fn test_is_incomplete_names_and_escapes() {
+    // `[` is the name being defined here, and `;` is quoted.
+    assert!(!is_incomplete(": [ 1 ;"));
+    assert!(is_incomplete("MACRO: foo \\ ; scan-object-list"));
+    assert!(!is_incomplete("MACRO: foo \\ ; scan-object-list ;"));
+}
+
+#[test]
+fn test_is_incomplete_other_forms() {
+    assert!(is_incomplete("H{ { :a 1 }"));
+    assert!(!is_incomplete("H{ { :a 1 } }"));
+    assert!(is_incomplete("record: point x y"));
+    assert!(!is_incomplete("record: point x y ;"));
+    assert!(is_incomplete("test: \"adds\" 1 2 + 3 assert="));
+    assert!(!is_incomplete("test: \"adds\" 1 2 + 3 assert= ;"));
+}
+
+#[test]
+fn test_format_stack() {
+    assert_eq!(format_stack(&[]), "( -- )");
+    let stack = vec![
+        ValueData::Integer(1).into(),
+        ValueData::String("two".to_string()).into(),
+    ];
+    assert_eq!(format_stack(&stack), "( 1 \"two\" -- )");
+}
@@ -10,14 +10,22 @@ use lambda::Lambda;
 
 use crate::error::{Error, Result, VMError};
 use crate::shared::{shared, unshare_clone};
+pub use crate::value::coroutine::Coroutine;
+use crate::value::data::char_repr;
 pub use crate::value::data::ValueData;
 pub use crate::value::io::reader::TardiReader;
 pub use crate::value::io::writer::TardiWriter;
+pub use crate::value::keyword::Keyword;
+pub use crate::value::record::Record;
 
+pub mod coroutine;
 pub mod data;
 pub mod frozen;
 pub mod io;
+pub mod keyword;
 pub mod lambda;
+pub mod pretty;
+pub mod record;
 
 // TODO: group Value and ValueData implementations better
 
@@ -118,10 +126,12 @@ impl Value {
     }
 
     pub fn to_repr(&self) -> String {
-        if let ValueData::String(ref s) = self.data {
-            format!("\"{}\"", s.replace("\\", "\\\\").replace("\"", "\\\""))
-        } else {
-            self.data.to_string()
+        match self.data {
+            ValueData::String(ref s) => {
+                format!("\"{}\"", s.replace("\\", "\\\\").replace("\"", "\\\""))
+            }
+            ValueData::Char(c) => char_repr(c),
+            _ => self.data.to_string(),
         }
     }
 
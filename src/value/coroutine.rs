@@ -0,0 +1,76 @@
+use std::fmt;
+use std::hash::{Hash, Hasher};
+use std::rc::Rc;
+
+use crate::shared::{shared, Shared};
+use crate::value::SharedValue;
+use crate::vm::CatchFrame;
+
+/// A handle to a quotation that can stop partway through with `yield` and
+/// be resumed later. Copies of the handle share the same coroutine.
+#[derive(Debug, Clone)]
+pub struct Coroutine(pub Shared<CoroutineState>);
+
+#[derive(Debug, Clone)]
+pub enum CoroutineState {
+    /// It hasn't started yet, so this is the lambda it will run.
+    Ready(SharedValue),
+    /// It's running, possibly with other coroutines it resumed on top of it.
+    Running,
+    /// It's stopped at a `yield`.
+    Suspended(Suspension),
+    /// Its lambda has returned, or it threw something.
+    Done,
+}
+
+/// What a coroutine had on the stacks when it yielded, above where it was
+/// resumed from. The depths in the catch frames are relative to that.
+#[derive(Debug, Clone)]
+pub struct Suspension {
+    pub ip: usize,
+    pub stack: Vec<SharedValue>,
+    pub return_stack: Vec<SharedValue>,
+    pub catch_frames: Vec<CatchFrame>,
+}
+
+impl Coroutine {
+    pub fn new(lambda: SharedValue) -> Self {
+        Coroutine(shared(CoroutineState::Ready(lambda)))
+    }
+
+    pub fn is_done(&self) -> bool {
+        matches!(*self.0.borrow(), CoroutineState::Done)
+    }
+
+    /// Sets the state, returning the old one.
+    pub fn replace(&self, state: CoroutineState) -> CoroutineState {
+        self.0.replace(state)
+    }
+}
+
+impl fmt::Display for Coroutine {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let state = match *self.0.borrow() {
+            CoroutineState::Ready(_) => "ready",
+            CoroutineState::Running => "running",
+            CoroutineState::Suspended(_) => "suspended",
+            CoroutineState::Done => "done",
+        };
+        write!(f, "<coroutine {}>", state)
+    }
+}
+
+// Coroutines are only equal to themselves.
+impl PartialEq for Coroutine {
+    fn eq(&self, other: &Self) -> bool {
+        Rc::ptr_eq(&self.0, &other.0)
+    }
+}
+
+impl Eq for Coroutine {}
+
+impl Hash for Coroutine {
+    fn hash<H: Hasher>(&self, state: &mut H) {
+        Rc::as_ptr(&self.0).hash(state);
+    }
+}
@@ -1,14 +1,18 @@
 use std::cmp::Ordering;
-use std::collections::HashMap;
 use std::fmt;
 use std::hash::Hash;
 use std::ops::{Add, Div, Mul, Sub};
 
+use indexmap::IndexMap;
+
 use crate::error::{Result, VMError};
+use crate::regex::Regex;
 use crate::value::frozen::FrozenValueData;
+use crate::value::keyword::Keyword;
 use crate::value::lambda::Lambda;
+use crate::value::record::Record;
 
-use super::{SharedValue, TardiReader, TardiWriter, Value};
+use super::{Coroutine, SharedValue, TardiReader, TardiWriter, Value};
 
 // -- they're more closely tied to `Environment` and they're part of what
 // bridges across layers
@@ -16,6 +20,9 @@ use super::{SharedValue, TardiReader, TardiWriter, Value};
 // TODO: cache common values like small numbers, booleans, and empty collections.
 // TODO: immutable tuple type
 /// Enum representing different types of values that can be stored on the stack
+///
+/// Hashmaps iterate in insertion order, so `>vector`, `keys`, printing, and
+/// serialization are stable across runs.
 #[derive(Debug, Clone)]
 pub enum ValueData {
     Integer(i64),
@@ -25,16 +32,20 @@ pub enum ValueData {
     String(String),
     // TODO: rename to Vector
     List(Vec<SharedValue>),
-    HashMap(HashMap<FrozenValueData, SharedValue>),
+    HashMap(IndexMap<FrozenValueData, SharedValue>),
     Function(Lambda),
     Address(usize),
     Word(String),
     Symbol { module: String, word: String },
+    Keyword(Keyword),
+    Record(Record),
     Macro,
     Literal(Box<Value>),
     Return(usize, bool),
     Writer(TardiWriter),
     Reader(TardiReader),
+    Coroutine(Coroutine),
+    Regex(Regex),
     EndOfInput,
 }
 
@@ -47,6 +58,42 @@ impl ValueData {
         }
     }
 
+    /// The name of the value's type, for error messages.
+    pub fn type_name(&self) -> &'static str {
+        match self {
+            ValueData::Integer(_) => "integer",
+            ValueData::Float(_) => "float",
+            ValueData::Boolean(_) => "boolean",
+            ValueData::Char(_) => "char",
+            ValueData::String(_) => "string",
+            ValueData::List(_) => "vector",
+            ValueData::HashMap(_) => "hashmap",
+            ValueData::Function(_) => "function",
+            ValueData::Address(_) => "address",
+            ValueData::Word(_) => "word",
+            ValueData::Symbol { .. } => "symbol",
+            ValueData::Keyword(_) => "keyword",
+            ValueData::Record(_) => "record",
+            ValueData::Macro => "macro",
+            ValueData::Literal(_) => "literal",
+            ValueData::Return(..) => "return address",
+            ValueData::Writer(_) => "writer",
+            ValueData::Reader(_) => "reader",
+            ValueData::Coroutine(_) => "coroutine",
+            ValueData::Regex(_) => "regex",
+            ValueData::EndOfInput => "end of input",
+        }
+    }
+
+    /// Orders this and `other` the way `compare` does. If they can't be
+    /// ordered, the error names both of them.
+    pub fn compare(&self, other: &ValueData) -> Result<Ordering> {
+        self.partial_cmp(other).ok_or_else(|| {
+            let describe = |v: &ValueData| format!("{} {}", v.type_name(), v.to_repr());
+            VMError::NotComparable(describe(self), describe(other)).into()
+        })
+    }
+
     pub fn get_word(&self) -> Option<&str> {
         if let ValueData::Word(ref w) = self {
             Some(w)
@@ -89,6 +136,46 @@ impl ValueData {
         }
     }
 
+    pub fn as_coroutine(&self) -> Option<&Coroutine> {
+        if let Self::Coroutine(v) = self {
+            Some(v)
+        } else {
+            None
+        }
+    }
+
+    pub fn as_keyword(&self) -> Option<&Keyword> {
+        if let Self::Keyword(v) = self {
+            Some(v)
+        } else {
+            None
+        }
+    }
+
+    pub fn as_record(&self) -> Option<&Record> {
+        if let Self::Record(v) = self {
+            Some(v)
+        } else {
+            None
+        }
+    }
+
+    pub fn as_record_mut(&mut self) -> Option<&mut Record> {
+        if let Self::Record(v) = self {
+            Some(v)
+        } else {
+            None
+        }
+    }
+
+    pub fn as_regex(&self) -> Option<&Regex> {
+        if let Self::Regex(v) = self {
+            Some(v)
+        } else {
+            None
+        }
+    }
+
     /// Returns `true` if the value data is [`HashMap`].
     ///
     /// [`HashMap`]: ValueData::HashMap
@@ -96,7 +183,7 @@ impl ValueData {
         matches!(self, Self::HashMap(..))
     }
 
-    pub fn as_hash_map(&self) -> Option<&HashMap<FrozenValueData, SharedValue>> {
+    pub fn as_hash_map(&self) -> Option<&IndexMap<FrozenValueData, SharedValue>> {
         if let Self::HashMap(v) = self {
             Some(v)
         } else {
@@ -104,7 +191,7 @@ impl ValueData {
         }
     }
 
-    pub fn as_hash_map_mut(&mut self) -> Option<&mut HashMap<FrozenValueData, SharedValue>> {
+    pub fn as_hash_map_mut(&mut self) -> Option<&mut IndexMap<FrozenValueData, SharedValue>> {
         if let Self::HashMap(v) = self {
             Some(v)
         } else {
@@ -156,6 +243,18 @@ impl From<ValueData> for Value {
     }
 }
 
+/// The source form of a character literal, like `'a'` or `'\n'`.
+pub fn char_repr(c: char) -> String {
+    match c {
+        '\n' => "'\\n'".to_string(),
+        '\r' => "'\\r'".to_string(),
+        '\t' => "'\\t'".to_string(),
+        '\\' => "'\\\\'".to_string(),
+        '\'' => "'\\''".to_string(),
+        c => format!("'{}'", c),
+    }
+}
+
 impl fmt::Display for ValueData {
     fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
         match self {
@@ -170,14 +269,7 @@ impl fmt::Display for ValueData {
             }
             ValueData::Boolean(true) => write!(f, "#t"),
             ValueData::Boolean(false) => write!(f, "#f"),
-            ValueData::Char(c) => match c {
-                '\n' => write!(f, "'\\n'"),
-                '\r' => write!(f, "'\\r'"),
-                '\t' => write!(f, "'\\t'"),
-                '\\' => write!(f, "'\\\\'"),
-                '\'' => write!(f, "'\\''"),
-                c => write!(f, "'{}'", c),
-            },
+            ValueData::Char(c) => write!(f, "{}", c),
             ValueData::List(list) => {
                 write!(f, "{{")?;
                 for item in list.iter() {
@@ -187,9 +279,7 @@ impl fmt::Display for ValueData {
             }
             ValueData::HashMap(hash_map) => {
                 write!(f, "H{{")?;
-                let mut pairs = hash_map.iter().collect::<Vec<_>>();
-                pairs.sort();
-                for (k, v) in pairs.iter() {
+                for (k, v) in hash_map.iter() {
                     write!(f, " {{ {} {} }}", k.to_repr(), v.borrow().to_repr())?;
                 }
                 write!(f, " }}")
@@ -200,11 +290,15 @@ impl fmt::Display for ValueData {
             ValueData::Word(word) => write!(f, "{}", word),
             // TODO: escape word if it starts with punctuation (`module::\:` or something)
             ValueData::Symbol { module, word } => write!(f, "{}::{}", module, word),
+            ValueData::Keyword(keyword) => write!(f, "{}", keyword),
+            ValueData::Record(record) => write!(f, "{}", record),
             ValueData::Macro => write!(f, "MACRO:"),
             ValueData::Literal(value) => write!(f, "\\ {}", value),
             ValueData::Return(address, breakpoint) => write!(f, "<@{} - {}>", address, breakpoint),
             ValueData::Writer(writer) => write!(f, "{}", writer),
             ValueData::Reader(reader) => write!(f, "{}", reader),
+            ValueData::Coroutine(coroutine) => write!(f, "{}", coroutine),
+            ValueData::Regex(regex) => write!(f, "{}", regex),
             ValueData::EndOfInput => write!(f, "<EOI>"),
         }
     }
@@ -256,8 +350,12 @@ impl PartialEq for ValueData {
                     word: b,
                 },
             ) => m1 == m2 && a == b,
+            (ValueData::Keyword(a), ValueData::Keyword(b)) => a == b,
+            (ValueData::Record(a), ValueData::Record(b)) => a == b,
             (ValueData::Macro, ValueData::Macro) => true,
             (ValueData::Literal(a), ValueData::Literal(b)) => a == b,
+            (ValueData::Coroutine(a), ValueData::Coroutine(b)) => a == b,
+            (ValueData::Regex(a), ValueData::Regex(b)) => a == b,
             (ValueData::EndOfInput, ValueData::EndOfInput) => true,
             _ => false,
         }
@@ -275,26 +373,20 @@ impl PartialOrd for ValueData {
             (ValueData::Char(a), ValueData::Char(b)) => a.partial_cmp(b),
             (ValueData::Boolean(a), ValueData::Boolean(b)) => a.partial_cmp(b),
             (ValueData::List(a), ValueData::List(b)) => {
-                // First compare lengths
-                match a.len().partial_cmp(&b.len()) {
-                    Some(Ordering::Equal) => {
-                        // If lengths are equal, compare elements
-                        for (x, y) in a.iter().zip(b.iter()) {
-                            let x_val = &*x.borrow();
-                            let y_val = &*y.borrow();
-                            match x_val.partial_cmp(y_val) {
-                                Some(Ordering::Equal) => continue,
-                                other => return other,
-                            }
-                        }
-                        Some(Ordering::Equal)
+                // Compare elements in order, and if one is a prefix of the
+                // other, the shorter one is less.
+                for (x, y) in a.iter().zip(b.iter()) {
+                    let x_val = &x.borrow().data;
+                    let y_val = &y.borrow().data;
+                    match x_val.partial_cmp(y_val) {
+                        Some(Ordering::Equal) => continue,
+                        other => return other,
                     }
-                    other => other,
                 }
+                a.len().partial_cmp(&b.len())
             }
             // TODO: compare hashmaps
             (ValueData::String(a), ValueData::String(b)) => a.partial_cmp(b),
-            (ValueData::Function(a), ValueData::Function(b)) => a.partial_cmp(b), // Functions cannot be ordered
             (ValueData::Word(a), ValueData::Word(b)) => a.partial_cmp(b),
             (
                 ValueData::Symbol {
@@ -306,6 +398,8 @@ impl PartialOrd for ValueData {
                     word: w2,
                 },
             ) => (m1, w1).partial_cmp(&(m2, w2)),
+            (ValueData::Keyword(a), ValueData::Keyword(b)) => a.partial_cmp(b),
+            (ValueData::Record(a), ValueData::Record(b)) => a.partial_cmp(b),
 
             _ => None,
         }
@@ -340,6 +434,8 @@ impl Hash for ValueData {
                 module.hash(state);
                 word.hash(state);
             }
+            ValueData::Keyword(keyword) => keyword.hash(state),
+            ValueData::Record(record) => record.hash(state),
             ValueData::Macro => "MACRO".hash(state),
             ValueData::Literal(value) => value.hash(state),
             ValueData::Return(address, breakpoint) => {
@@ -348,6 +444,8 @@ impl Hash for ValueData {
             }
             ValueData::Writer(writer) => writer.hash(state),
             ValueData::Reader(reader) => reader.hash(state),
+            ValueData::Coroutine(coroutine) => coroutine.hash(state),
+            ValueData::Regex(regex) => regex.hash(state),
             ValueData::EndOfInput => "EOI".hash(state),
         }
     }
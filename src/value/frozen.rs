@@ -3,7 +3,8 @@ use std::fmt;
 use std::hash::Hash;
 
 use crate::error::{Error, VMError};
-use crate::value::ValueData;
+use crate::value::data::char_repr;
+use crate::value::{Keyword, ValueData};
 
 #[derive(Debug, Clone, PartialEq, Eq, PartialOrd, Ord, Hash)]
 pub enum FrozenValueData {
@@ -14,15 +15,18 @@ pub enum FrozenValueData {
     Address(usize),
     Word(String),
     Symbol { module: String, word: String },
+    Keyword(Keyword),
     Return(usize, bool),
 }
 
 impl FrozenValueData {
     pub fn to_repr(&self) -> String {
-        if let FrozenValueData::String(ref s) = self {
-            format!("\"{}\"", s.replace("\\", "\\\\").replace("\"", "\\\""))
-        } else {
-            self.to_string()
+        match self {
+            FrozenValueData::String(ref s) => {
+                format!("\"{}\"", s.replace("\\", "\\\\").replace("\"", "\\\""))
+            }
+            FrozenValueData::Char(c) => char_repr(*c),
+            _ => self.to_string(),
         }
     }
 }
@@ -33,19 +37,13 @@ impl fmt::Display for FrozenValueData {
             FrozenValueData::Integer(n) => write!(f, "{}", n),
             FrozenValueData::Boolean(true) => write!(f, "#t"),
             FrozenValueData::Boolean(false) => write!(f, "#f"),
-            FrozenValueData::Char(c) => match c {
-                '\n' => write!(f, "'\\n'"),
-                '\r' => write!(f, "'\\r'"),
-                '\t' => write!(f, "'\\t'"),
-                '\\' => write!(f, "'\\\\'"),
-                '\'' => write!(f, "'\\''"),
-                c => write!(f, "'{}'", c),
-            },
+            FrozenValueData::Char(c) => write!(f, "{}", c),
             FrozenValueData::String(s) => write!(f, "{}", s),
             FrozenValueData::Address(addr) => write!(f, "<@{}>", addr),
             FrozenValueData::Word(word) => write!(f, "{}", word),
             // TODO: escape word if it starts with punctuation (`module::\:` or something)
             FrozenValueData::Symbol { module, word } => write!(f, "{}::{}", module, word),
+            FrozenValueData::Keyword(keyword) => write!(f, "{}", keyword),
             FrozenValueData::Return(address, breakpoint) => {
                 write!(f, "<@{} - {}>", address, breakpoint)
             }
@@ -65,15 +63,19 @@ impl TryFrom<ValueData> for FrozenValueData {
             ValueData::Address(a) => Ok(FrozenValueData::Address(a)),
             ValueData::Word(w) => Ok(FrozenValueData::Word(w)),
             ValueData::Symbol { module, word } => Ok(FrozenValueData::Symbol { module, word }),
+            ValueData::Keyword(keyword) => Ok(FrozenValueData::Keyword(keyword)),
             ValueData::Return(a, bp) => Ok(FrozenValueData::Return(a, bp)),
             ValueData::Float(_)
             | ValueData::List(_)
             | ValueData::HashMap(_)
+            | ValueData::Record(_)
             | ValueData::Function(_)
             | ValueData::Macro
             | ValueData::Literal(_)
             | ValueData::Writer(_)
             | ValueData::Reader(_)
+            | ValueData::Coroutine(_)
+            | ValueData::Regex(_)
             | ValueData::EndOfInput => Err(VMError::UnfreezableValue(value).into()),
         }
     }
@@ -89,6 +91,7 @@ impl From<FrozenValueData> for ValueData {
             FrozenValueData::Address(a) => ValueData::Address(a),
             FrozenValueData::Word(w) => ValueData::Word(w),
             FrozenValueData::Symbol { module, word } => ValueData::Symbol { module, word },
+            FrozenValueData::Keyword(keyword) => ValueData::Keyword(keyword),
             FrozenValueData::Return(a, f) => ValueData::Return(a, f),
         }
     }
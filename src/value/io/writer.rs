@@ -61,6 +61,14 @@ impl TardiWriter {
         Ok(TardiWriter::File { name, writer })
     }
 
+    /// Opens a file for writing, replacing anything already in it.
+    pub fn create(path: &Path) -> Result<Self> {
+        let name = path.to_string_lossy().to_string();
+        let file = File::create(path)?;
+        let writer = shared(BufWriter::new(file));
+        Ok(TardiWriter::File { name, writer })
+    }
+
     pub fn get_path(&self) -> Option<String> {
         let name = match self {
             TardiWriter::Stdout => "<stdout>".to_string(),
@@ -0,0 +1,69 @@
+use std::cmp::Ordering;
+use std::fmt;
+use std::hash::{Hash, Hasher};
+use std::rc::Rc;
+
+/// A symbol, like `:red`. It's a name that's distinct from a string, and
+/// the environment interns them, so every `:red` shares the same text and
+/// comparing two of them only has to compare pointers.
+#[derive(Debug, Clone)]
+pub struct Keyword(Rc<str>);
+
+impl Keyword {
+    /// Creates a symbol that hasn't been interned. Use
+    /// `Environment::intern` to get the shared one.
+    pub fn new(name: &str) -> Self {
+        Keyword(Rc::from(name))
+    }
+
+    pub fn name(&self) -> &str {
+        &self.0
+    }
+
+    /// Whether both are the same interned symbol.
+    pub fn ptr_eq(&self, other: &Self) -> bool {
+        Rc::ptr_eq(&self.0, &other.0)
+    }
+
+    /// Whether `word` is written as a symbol. That's a colon followed by at
+    /// least one character, as long as that isn't another colon, so `:` and
+    /// `::` are still words.
+    pub fn is_keyword(word: &str) -> bool {
+        word.strip_prefix(':')
+            .is_some_and(|name| !name.is_empty() && !name.starts_with(':'))
+    }
+}
+
+impl fmt::Display for Keyword {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, ":{}", self.0)
+    }
+}
+
+// Interned symbols with the same name are the same pointer, so that's
+// checked first.
+impl PartialEq for Keyword {
+    fn eq(&self, other: &Self) -> bool {
+        self.ptr_eq(other) || self.0 == other.0
+    }
+}
+
+impl Eq for Keyword {}
+
+impl Hash for Keyword {
+    fn hash<H: Hasher>(&self, state: &mut H) {
+        self.0.hash(state);
+    }
+}
+
+impl PartialOrd for Keyword {
+    fn partial_cmp(&self, other: &Self) -> Option<Ordering> {
+        Some(self.cmp(other))
+    }
+}
+
+impl Ord for Keyword {
+    fn cmp(&self, other: &Self) -> Ordering {
+        self.0.cmp(&other.0)
+    }
+}
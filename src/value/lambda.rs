@@ -7,7 +7,7 @@ use crate::{Compiler, VM};
 pub type OpFn = fn(&mut VM, &mut Compiler) -> Result<()>;
 
 /// Function structure for user-defined functions and lambdas
-#[derive(Debug, Clone, PartialEq, PartialOrd, Hash)]
+#[derive(Debug, Clone, PartialEq, Hash)]
 pub struct Lambda {
     // TODO: this needs to include the module as well somehow
     /// Optional name (None for lambdas)
@@ -46,6 +46,19 @@ impl Lambda {
         }
     }
 
+    /// Creates a word for a function that the host program provides. It
+    /// expects at least `arity` items on the stack.
+    pub fn new_primitive(name: &str, arity: usize, function: OpFn) -> Self {
+        let name = Some(name.to_string());
+        let callable = Callable::Primitive { function, arity };
+        Lambda {
+            name,
+            immediate: false,
+            defined: true,
+            callable,
+        }
+    }
+
     pub fn new_compiled(_name: &str, _words: &[String], _ip: usize) -> Self {
         todo!("Lambda::new_compiled")
     }
@@ -94,7 +107,10 @@ impl Lambda {
     }
 
     pub fn is_builtin(&self) -> bool {
-        matches!(self.callable, Callable::BuiltIn { .. })
+        matches!(
+            self.callable,
+            Callable::BuiltIn { .. } | Callable::Primitive { .. }
+        )
     }
 
     pub fn is_compiled(&self) -> bool {
@@ -162,6 +178,8 @@ impl Lambda {
 pub enum Callable {
     /// Built-in function implemented in Rust
     BuiltIn { function: OpFn },
+    /// Function provided by the program embedding Tardi
+    Primitive { function: OpFn, arity: usize },
     /// User-defined function or lambda
     Compiled {
         words: Vec<String>,
@@ -178,6 +196,13 @@ impl Callable {
                 log::trace!("calling built-in function");
                 function(vm, compiler)
             }
+            Callable::Primitive { function, arity } => {
+                log::trace!("calling primitive function");
+                if vm.stack_size() < *arity {
+                    return Err(VMError::StackUnderflow.into());
+                }
+                function(vm, compiler)
+            }
             Callable::Compiled {
                 ip: instructions,
                 words,
@@ -200,6 +225,9 @@ impl PartialEq for Callable {
         // Could also compare the words. Would this be better?
         match (self, other) {
             (Callable::BuiltIn { function: a }, Callable::BuiltIn { function: b }) => ptr::eq(a, b),
+            (Callable::Primitive { function: a, .. }, Callable::Primitive { function: b, .. }) => {
+                ptr::eq(a, b)
+            }
             (Callable::Compiled { ip: a, .. }, Callable::Compiled { ip: b, .. }) => a == b,
             _ => false,
         }
@@ -209,7 +237,7 @@ impl PartialEq for Callable {
 impl std::hash::Hash for Callable {
     fn hash<H: std::hash::Hasher>(&self, state: &mut H) {
         match self {
-            Callable::BuiltIn { function } => {
+            Callable::BuiltIn { function } | Callable::Primitive { function, .. } => {
                 std::ptr::hash(function, state);
             }
             Callable::Compiled { ip, .. } => {
@@ -219,12 +247,6 @@ impl std::hash::Hash for Callable {
     }
 }
 
-impl PartialOrd for Callable {
-    fn partial_cmp(&self, _other: &Self) -> Option<std::cmp::Ordering> {
-        None
-    }
-}
-
 impl fmt::Display for Lambda {
     fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
         if let Some(ref name) = self.name {
@@ -238,7 +260,7 @@ impl fmt::Display for Lambda {
 impl fmt::Display for Callable {
     fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
         match self {
-            Callable::BuiltIn { .. } => write!(f, "fn"),
+            Callable::BuiltIn { .. } | Callable::Primitive { .. } => write!(f, "fn"),
             Callable::Compiled { words, ip, .. } => write!(f, "[ {} ]@{}", words.join(" "), ip),
         }
     }
@@ -0,0 +1,130 @@
+use std::rc::Rc;
+
+use super::{SharedValue, ValueData};
+
+/// How `pretty` lays out values.
+#[derive(Debug, Clone, PartialEq)]
+pub struct PrettyOptions {
+    /// How many columns a line can take before a container is broken
+    /// across lines.
+    pub width: usize,
+
+    /// How deeply containers can nest before the rest are shown as
+    /// `{ ... }`. `None` shows them all.
+    pub depth: Option<usize>,
+}
+
+impl Default for PrettyOptions {
+    fn default() -> Self {
+        PrettyOptions {
+            width: 80,
+            depth: None,
+        }
+    }
+}
+
+/// What's printed for a value. A group is a container, which goes on one
+/// line if it fits, or otherwise has one item on each line after `open`.
+#[derive(Debug)]
+enum Doc {
+    Text(String),
+    Group { open: String, items: Vec<Doc> },
+}
+
+/// Formats a value with `options`. Vectors, hashmaps, and records that don't
+/// fit on a line are broken up with each item indented on its own line. A
+/// container that's inside itself is shown as `<cycle>`.
+pub fn pretty(value: &SharedValue, options: &PrettyOptions) -> String {
+    let mut ancestors = Vec::new();
+    let doc = build(value, options, 0, &mut ancestors);
+    let mut output = String::new();
+    render(&doc, options.width, 0, &mut output);
+    output
+}
+
+/// `ancestors` are the containers that `value` is inside of.
+fn build(
+    value: &SharedValue,
+    options: &PrettyOptions,
+    depth: usize,
+    ancestors: &mut Vec<*const ()>,
+) -> Doc {
+    let ptr = Rc::as_ptr(value) as *const ();
+    if ancestors.contains(&ptr) {
+        return Doc::Text("<cycle>".to_string());
+    }
+
+    let borrowed = value.borrow();
+    let (open, is_empty) = match &borrowed.data {
+        ValueData::List(items) => ("{".to_string(), items.is_empty()),
+        ValueData::HashMap(pairs) => ("H{".to_string(), pairs.is_empty()),
+        ValueData::Record(record) => (format!("T{{ {}", record.name()), record.slots.is_empty()),
+        _ => return Doc::Text(borrowed.to_repr()),
+    };
+    if !is_empty && options.depth.is_some_and(|max| depth >= max) {
+        return Doc::Text(format!("{} ... }}", open));
+    }
+
+    ancestors.push(ptr);
+    let items = match &borrowed.data {
+        ValueData::List(items) => items
+            .iter()
+            .map(|item| build(item, options, depth + 1, ancestors))
+            .collect(),
+        ValueData::HashMap(pairs) => pairs
+            .iter()
+            .map(|(key, value)| Doc::Group {
+                open: "{".to_string(),
+                items: vec![
+                    Doc::Text(key.to_repr()),
+                    build(value, options, depth + 1, ancestors),
+                ],
+            })
+            .collect(),
+        ValueData::Record(record) => record
+            .slots
+            .iter()
+            .map(|slot| build(slot, options, depth + 1, ancestors))
+            .collect(),
+        _ => unreachable!("only containers have items"),
+    };
+    ancestors.pop();
+
+    Doc::Group { open, items }
+}
+
+fn flat(doc: &Doc) -> String {
+    match doc {
+        Doc::Text(text) => text.clone(),
+        Doc::Group { open, items } => {
+            let mut text = open.clone();
+            for item in items {
+                text.push(' ');
+                text.push_str(&flat(item));
+            }
+            text.push_str(" }");
+            text
+        }
+    }
+}
+
+fn render(doc: &Doc, width: usize, indent: usize, output: &mut String) {
+    let text = flat(doc);
+    let (open, items) = match doc {
+        Doc::Group { open, items } if indent + text.chars().count() > width => (open, items),
+        _ => {
+            output.push_str(&text);
+            return;
+        }
+    };
+
+    output.push_str(open);
+    for item in items {
+        output.push('\n');
+        output.push_str(&" ".repeat(indent + 4));
+        render(item, width, indent + 4, output);
+    }
+    output.push('\n');
+    output.push_str(&" ".repeat(indent));
+    output.push('}');
+}
@@ -0,0 +1,71 @@
+use std::cmp::Ordering;
+use std::fmt;
+use std::hash::{Hash, Hasher};
+
+use super::keyword::Keyword;
+use super::SharedValue;
+
+/// An instance of a type declared with `record: point x y ;`. `kind` is the
+/// record's name, and the slots are in the order they were declared.
+#[derive(Debug, Clone)]
+pub struct Record {
+    pub kind: Keyword,
+    pub slots: Vec<SharedValue>,
+}
+
+impl Record {
+    pub fn new(kind: Keyword, slots: Vec<SharedValue>) -> Self {
+        Record { kind, slots }
+    }
+
+    pub fn name(&self) -> &str {
+        self.kind.name()
+    }
+}
+
+impl fmt::Display for Record {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "T{{ {}", self.name())?;
+        for slot in self.slots.iter() {
+            write!(f, " {}", slot.borrow().to_repr())?;
+        }
+        write!(f, " }}")
+    }
+}
+
+impl PartialEq for Record {
+    fn eq(&self, other: &Self) -> bool {
+        self.kind == other.kind
+            && self.slots.len() == other.slots.len()
+            && self
+                .slots
+                .iter()
+                .zip(other.slots.iter())
+                .all(|(a, b)| *a.borrow() == *b.borrow())
+    }
+}
+
+impl Hash for Record {
+    fn hash<H: Hasher>(&self, state: &mut H) {
+        self.kind.hash(state);
+        for slot in self.slots.iter() {
+            slot.borrow().hash(state);
+        }
+    }
+}
+
+// Only records of the same type can be ordered, slot by slot.
+impl PartialOrd for Record {
+    fn partial_cmp(&self, other: &Self) -> Option<Ordering> {
+        if self.kind != other.kind {
+            return None;
+        }
+        for (a, b) in self.slots.iter().zip(other.slots.iter()) {
+            match a.borrow().data.partial_cmp(&b.borrow().data)? {
+                Ordering::Equal => {}
+                ordering => return Some(ordering),
+            }
+        }
+        Some(self.slots.len().cmp(&other.slots.len()))
+    }
+}
@@ -3,13 +3,18 @@ use crate::value::lambda::Lambda;
 use crate::Compiler;
 use log::{log_enabled, Level};
 
-use crate::env::{EnvLoc, Environment};
+use std::fmt;
+use std::io::Write;
+use std::iter;
+
+use crate::env::{EnvLoc, Environment, SourceLoc};
 use crate::error::{Error, Result, VMError};
 
 pub mod ops;
 pub use self::ops::OpCode;
 
-use crate::value::{SharedValue, Value, ValueData};
+use crate::value::coroutine::{CoroutineState, Suspension};
+use crate::value::{Coroutine, SharedValue, TardiWriter, Value, ValueData};
 
 use crate::core::Execute;
 
@@ -33,6 +38,128 @@ pub struct VM {
 
     /// A stack of the module we're currently executing.
     pub module_stack: Vec<String>,
+
+    /// The handlers for the `catch` blocks we're currently executing.
+    pub catch_frames: Vec<CatchFrame>,
+
+    /// The coroutines that are running, innermost last.
+    pub coroutine_frames: Vec<CoroutineFrame>,
+
+    /// Where `print` and friends write to, if not stdout. The last one is
+    /// the current output.
+    pub outputs: Vec<OutputFrame>,
+
+    /// The command-line arguments given after the script.
+    pub args: Vec<String>,
+
+    /// The test cases that have finished, and any assertions made outside
+    /// of one.
+    pub test_results: Vec<TestResult>,
+
+    /// The test case that's running.
+    pub current_test: Option<TestResult>,
+
+    /// The call trace for the last uncaught error, innermost frame first.
+    pub trace: Vec<TraceFrame>,
+
+    /// Called before each instruction runs, if it's set.
+    pub hook: Option<Box<dyn Hook>>,
+
+    /// Set by `breakpoint`, so a hook can pause there.
+    pub break_requested: bool,
+
+    /// How many values the data stack can hold.
+    pub max_stack: usize,
+
+    /// How many values the return stack can hold. Each call that isn't a
+    /// tail call takes one, so this is how deep recursion can go.
+    pub max_depth: usize,
+}
+
+/// The default for `VM::max_stack`.
+pub const DEFAULT_MAX_STACK: usize = 100_000;
+
+/// The default for `VM::max_depth`.
+pub const DEFAULT_MAX_DEPTH: usize = 100_000;
+
+/// Something that watches the VM run, like a debugger.
+pub trait Hook {
+    /// Called with the address of each instruction before it runs. An error
+    /// is handled like one from the instruction itself.
+    fn before_instruction(&mut self, vm: &mut VM, ip: usize) -> Result<()>;
+}
+
+/// What's needed to unwind the stacks to a `catch` and run its handler.
+#[derive(Debug, Clone)]
+pub struct CatchFrame {
+    pub stack_depth: usize,
+    pub return_depth: usize,
+    pub output_depth: usize,
+    pub coroutine_depth: usize,
+    pub handler: SharedValue,
+}
+
+/// Where output is redirected to, and how deep the return stack was when it
+/// was.
+#[derive(Debug)]
+pub struct OutputFrame {
+    pub writer: TardiWriter,
+    pub return_depth: usize,
+}
+
+/// A coroutine that's running, and how deep the stacks were when it was
+/// resumed. `yield` saves what's above these and puts them back.
+#[derive(Debug, Clone)]
+pub struct CoroutineFrame {
+    pub coroutine: Coroutine,
+    pub stack_depth: usize,
+    pub return_depth: usize,
+    pub catch_depth: usize,
+}
+
+/// A word that was executing when an uncaught error happened, and where.
+#[derive(Debug, Clone, PartialEq)]
+pub struct TraceFrame {
+    pub word: String,
+    pub loc: Option<SourceLoc>,
+}
+
+impl fmt::Display for TraceFrame {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self.loc {
+            Some(ref loc) => write!(f, "{} at {}", self.word, loc),
+            None => write!(f, "{}", self.word),
+        }
+    }
+}
+
+/// A test case, or an assertion made outside of one, and how it failed.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct TestResult {
+    pub name: Option<String>,
+    pub failures: Vec<TestFailure>,
+}
+
+impl TestResult {
+    pub fn passed(&self) -> bool {
+        self.failures.is_empty()
+    }
+}
+
+/// A failed assertion, or an error that a test case didn't catch.
+#[derive(Debug, Clone, PartialEq)]
+pub struct TestFailure {
+    pub message: String,
+    pub loc: Option<SourceLoc>,
+}
+
+impl fmt::Display for TestFailure {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self.loc {
+            Some(ref loc) => write!(f, "{}: {}", loc, self.message),
+            None => write!(f, "{}", self.message),
+        }
+    }
 }
 
 impl Default for VM {
@@ -50,6 +177,17 @@ impl VM {
             stack: Vec::new(),
             return_stack: Vec::new(),
             module_stack: Vec::new(),
+            catch_frames: Vec::new(),
+            coroutine_frames: Vec::new(),
+            outputs: Vec::new(),
+            args: Vec::new(),
+            test_results: Vec::new(),
+            current_test: None,
+            trace: Vec::new(),
+            hook: None,
+            break_requested: false,
+            max_stack: DEFAULT_MAX_STACK,
+            max_depth: DEFAULT_MAX_DEPTH,
         }
     }
 
@@ -61,8 +199,8 @@ impl VM {
 
     /// Pushes a shared value onto the return stack
     pub fn push_return(&mut self, value: SharedValue) -> Result<()> {
-        if self.return_stack.len() >= 1024 {
-            return Err(VMError::ReturnStackOverflow.into());
+        if self.return_stack.len() >= self.max_depth {
+            return Err(VMError::ReturnStackOverflow(self.max_depth).into());
         }
         self.return_stack.push(value);
         Ok(())
@@ -96,10 +234,39 @@ impl VM {
         }
     }
 
+    /// Copies a local onto the data stack. The operand is how far down the
+    /// return stack the local is.
+    pub fn load_local(&mut self) -> Result<()> {
+        let slot = self.get_local_slot()?;
+        let value = self.return_stack[slot].clone();
+        self.push(value)
+    }
+
+    /// Pops the top of the data stack into a local.
+    pub fn store_local(&mut self) -> Result<()> {
+        let slot = self.get_local_slot()?;
+        let value = self.pop()?;
+        self.return_stack[slot] = value;
+        Ok(())
+    }
+
+    fn get_local_slot(&mut self) -> Result<usize> {
+        let depth = self
+            .environment
+            .as_ref()
+            .ok_or(VMError::MissingEnvironment)
+            .and_then(|e| e.borrow().get_instruction(self.ip))?;
+        self.ip += 1;
+        self.return_stack
+            .len()
+            .checked_sub(depth + 1)
+            .ok_or_else(|| VMError::ReturnStackUnderflow.into())
+    }
+
     /// Pushes a shared value onto the data stack
     pub fn push(&mut self, value: SharedValue) -> Result<()> {
-        if self.stack.len() >= 1024 {
-            return Err(VMError::StackOverflow.into());
+        if self.stack.len() >= self.max_stack {
+            return Err(VMError::StackOverflow(self.max_stack).into());
         }
         self.stack.push(value);
         Ok(())
@@ -253,6 +420,17 @@ impl VM {
         }
     }
 
+    /// Compares two values, leaving -1 if a is less than b, 0 if they're
+    /// equal, and 1 if a is greater. Numbers compare by value, strings and
+    /// chars by content, booleans with `#f` first, symbols by name, and
+    /// vectors element by element. Anything else can't be ordered.
+    pub fn compare(&mut self) -> Result<()> {
+        let b = self.pop()?.borrow().clone();
+        let a = self.pop()?.borrow().clone();
+        let ordering = a.data.compare(&b.data)?;
+        self.push(shared(ValueData::Integer(ordering as i64).into()))
+    }
+
     /// Performs logical NOT operation on the top stack item
     pub fn not(&mut self) -> Result<()> {
         let value = self.pop()?.borrow().clone();
@@ -326,6 +504,47 @@ impl VM {
         Ok(())
     }
 
+    /// Calls a function by its index in the op_table, reusing the current
+    /// return frame when it's compiled
+    pub fn tail_call(&mut self, compiler: &mut Compiler) -> Result<()> {
+        let env = self
+            .environment
+            .as_ref()
+            .ok_or(VMError::MissingEnvironment)?;
+        let op_table_index = env.borrow().get_instruction(self.ip)?;
+        self.ip += 1;
+
+        let lambda = env
+            .borrow()
+            .get_callable(op_table_index)
+            .ok_or(VMError::InvalidAddress(op_table_index))?;
+        let lambda = lambda.borrow();
+        self.tail_call_lambda(&lambda, compiler)
+    }
+
+    /// Calls a function from the stack, reusing the current return frame when
+    /// it's compiled
+    pub fn tail_apply(&mut self, compiler: &mut Compiler) -> Result<()> {
+        let func = self.pop()?;
+        let func = func.borrow();
+        let lambda = func.as_function().ok_or_else(|| {
+            Error::from(VMError::TypeMismatch(format!("not a word: {}", func)))
+        })?;
+        self.tail_call_lambda(lambda, compiler)
+    }
+
+    fn tail_call_lambda(&mut self, lambda: &Lambda, compiler: &mut Compiler) -> Result<()> {
+        // Loops need their own frame to mark where `break` unwinds to.
+        match lambda.get_ip() {
+            Some(ip) if lambda.defined && !lambda.is_loop() => {
+                log::trace!("VM::tail_call_lambda moving instruction pointer to {}", ip);
+                self.ip = ip;
+                Ok(())
+            }
+            _ => lambda.call(self, compiler),
+        }
+    }
+
     /// Defines a named function
     pub fn function(&mut self) -> Result<()> {
         let lambda = self.pop()?;
@@ -390,12 +609,26 @@ impl VM {
             .ok_or_else(|| VMError::TypeMismatch("function name".to_string()))?;
         log::trace!("VM::predefine_function {}::{}", module_name, name_str);
 
-        let lambda = Lambda::new_undefined(name_str);
-        // Add the function to the op_table
-        self.environment
+        let env = self
+            .environment
             .as_ref()
             .ok_or(VMError::MissingEnvironment)?
-            .borrow_mut()
+            .clone();
+
+        // A word that was called before it was defined already has a slot.
+        let forward_ref = env
+            .borrow()
+            .get_module(module_name)
+            .and_then(|module| module.defined.get(name_str).copied())
+            .and_then(|index| env.borrow().get_callable(index))
+            .is_some_and(|lambda| !lambda.borrow().defined);
+        if forward_ref {
+            return Ok(());
+        }
+
+        let lambda = Lambda::new_undefined(name_str);
+        // Add the function to the op_table
+        env.borrow_mut()
             .add_to_op_table(module_name, shared(lambda))?;
 
         Ok(())
@@ -418,9 +651,286 @@ impl VM {
             .ok_or_else(|| VMError::TypeMismatch("return addres".to_string()))?;
         self.ip = addr;
 
+        self.drop_stale_frames()
+    }
+
+    /// Drops the catch handlers, outputs, and coroutines that were set up
+    /// deeper in the return stack than it is now. `return` and `break` can
+    /// leave these behind when they jump out of the word that set them up.
+    fn drop_stale_frames(&mut self) -> Result<()> {
+        let depth = self.return_stack.len();
+        while self
+            .catch_frames
+            .last()
+            .is_some_and(|frame| frame.return_depth > depth)
+        {
+            self.catch_frames.pop();
+        }
+        while self
+            .coroutine_frames
+            .last()
+            .is_some_and(|frame| frame.return_depth > depth)
+        {
+            let frame = self.coroutine_frames.pop().unwrap();
+            frame.coroutine.replace(CoroutineState::Done);
+        }
+        while self
+            .outputs
+            .last()
+            .is_some_and(|output| output.return_depth > depth)
+        {
+            let mut output = self.outputs.pop().unwrap();
+            output.writer.flush()?;
+        }
+        Ok(())
+    }
+
+    /// Sets up a handler for the lambda under it on the stack. The stacks are
+    /// recorded as they will be once that lambda is applied.
+    pub fn catch_begin(&mut self) -> Result<()> {
+        let handler = self.pop()?;
+        if self.stack.is_empty() {
+            return Err(VMError::StackUnderflow.into());
+        }
+        let frame = CatchFrame {
+            stack_depth: self.stack.len() - 1,
+            return_depth: self.return_stack.len(),
+            output_depth: self.outputs.len(),
+            coroutine_depth: self.coroutine_frames.len(),
+            handler,
+        };
+        log::trace!(
+            "VM::catch_begin stack {} return stack {}",
+            frame.stack_depth,
+            frame.return_depth
+        );
+        self.catch_frames.push(frame);
         Ok(())
     }
 
+    /// Removes the handler when the lambda finishes without throwing.
+    pub fn catch_end(&mut self) -> Result<()> {
+        log::trace!("VM::catch_end");
+        self.catch_frames.pop();
+        Ok(())
+    }
+
+    /// Throws the value on the top of the stack.
+    pub fn throw(&mut self) -> Result<()> {
+        let value = self.pop()?;
+        let value = unshare_clone(value);
+        log::trace!("VM::throw {}", value);
+        Err(VMError::Throw(value).into())
+    }
+
+    /// Creates a coroutine that will run the lambda on the top of the stack.
+    pub fn coroutine(&mut self) -> Result<()> {
+        let lambda = self.pop()?;
+        if lambda.borrow().as_function().is_none() {
+            return Err(VMError::TypeMismatch("coroutine lambda".to_string()).into());
+        }
+        self.push(shared(ValueData::Coroutine(Coroutine::new(lambda)).into()))
+    }
+
+    /// Starts the coroutine on the top of the stack, or puts back what it
+    /// had when it yielded. Either way, it comes back to the next
+    /// instruction, `<resume-end>`, when it yields or its lambda returns.
+    pub fn resume(&mut self, compiler: &mut Compiler) -> Result<()> {
+        let value = self.pop()?;
+        let coroutine = value
+            .borrow()
+            .data
+            .as_coroutine()
+            .cloned()
+            .ok_or_else(|| VMError::TypeMismatch("resume coroutine".to_string()))?;
+        let frame = CoroutineFrame {
+            coroutine: coroutine.clone(),
+            stack_depth: self.stack.len(),
+            return_depth: self.return_stack.len(),
+            catch_depth: self.catch_frames.len(),
+        };
+        log::trace!(
+            "VM::resume stack {} return stack {}",
+            frame.stack_depth,
+            frame.return_depth
+        );
+
+        match coroutine.replace(CoroutineState::Running) {
+            CoroutineState::Ready(lambda) => {
+                let lambda = lambda.borrow().as_function().cloned().unwrap();
+                self.coroutine_frames.push(frame);
+                lambda.call(self, compiler)
+            }
+            CoroutineState::Suspended(suspension) => {
+                self.push_ip(false)?;
+                let coroutine_depth = self.coroutine_frames.len();
+                self.stack.extend(suspension.stack);
+                self.return_stack.extend(suspension.return_stack);
+                self.catch_frames
+                    .extend(suspension.catch_frames.into_iter().map(|catch| CatchFrame {
+                        stack_depth: catch.stack_depth + frame.stack_depth,
+                        return_depth: catch.return_depth + frame.return_depth,
+                        coroutine_depth: catch.coroutine_depth + coroutine_depth,
+                        ..catch
+                    }));
+                self.coroutine_frames.push(frame);
+                self.ip = suspension.ip;
+                Ok(())
+            }
+            state => {
+                let description = match state {
+                    CoroutineState::Done => "done",
+                    _ => "already running",
+                };
+                coroutine.replace(state);
+                Err(VMError::InvalidResume(description.to_string()).into())
+            }
+        }
+    }
+
+    /// Finishes `resume`. If the coroutine yielded, that's already left the
+    /// value and the coroutine on the stack. Otherwise its lambda returned,
+    /// so it's done, and what it left on the stack is dropped.
+    pub fn resume_end(&mut self) -> Result<()> {
+        let finished = self
+            .coroutine_frames
+            .last()
+            .is_some_and(|frame| frame.return_depth == self.return_stack.len());
+        if !finished {
+            return Ok(());
+        }
+
+        let frame = self.coroutine_frames.pop().unwrap();
+        log::trace!("VM::resume_end {} finished", frame.coroutine);
+        frame.coroutine.replace(CoroutineState::Done);
+        self.stack.truncate(frame.stack_depth);
+        self.catch_frames.truncate(frame.catch_depth);
+        self.push(shared(ValueData::Boolean(false).into()))?;
+        self.push(shared(ValueData::Coroutine(frame.coroutine).into()))
+    }
+
+    /// Suspends the innermost coroutine that's running, and returns from the
+    /// `resume` that ran it with the value on the top of the stack.
+    pub fn yield_value(&mut self) -> Result<()> {
+        if self.coroutine_frames.is_empty() {
+            return Err(VMError::YieldOutsideCoroutine.into());
+        }
+        let value = self.pop()?;
+        let frame = self.coroutine_frames.pop().unwrap();
+        let coroutine_depth = self.coroutine_frames.len();
+        log::trace!(
+            "VM::yield_value {} from {}",
+            value.borrow(),
+            frame.coroutine
+        );
+
+        // The first return address goes back to `<resume-end>`. Resuming
+        // pushes a new one, in case it's resumed from somewhere else.
+        let mut return_stack = self.return_stack.split_off(frame.return_depth);
+        let resume_end = if return_stack.is_empty() {
+            self.ip
+        } else {
+            let address = return_stack.remove(0);
+            let address = address.borrow();
+            address
+                .as_address()
+                .ok_or_else(|| VMError::TypeMismatch("resume return address".to_string()))?
+        };
+        let catch_frames = self
+            .catch_frames
+            .split_off(frame.catch_depth)
+            .into_iter()
+            .map(|catch| CatchFrame {
+                stack_depth: catch.stack_depth.saturating_sub(frame.stack_depth),
+                return_depth: catch.return_depth - frame.return_depth,
+                coroutine_depth: catch.coroutine_depth - coroutine_depth,
+                ..catch
+            })
+            .collect();
+        let stack = self
+            .stack
+            .split_off(frame.stack_depth.min(self.stack.len()));
+
+        frame
+            .coroutine
+            .replace(CoroutineState::Suspended(Suspension {
+                ip: self.ip,
+                stack,
+                return_stack,
+                catch_frames,
+            }));
+        self.ip = resume_end;
+        self.push(value)?;
+        self.push(shared(ValueData::Coroutine(frame.coroutine).into()))
+    }
+
+    /// Unwinds the stacks to the nearest `catch` and runs its handler with the
+    /// error on the stack. If there isn't one, this returns the error.
+    fn unwind(&mut self, err: Error, compiler: &mut Compiler) -> Result<()> {
+        if matches!(err, Error::VMError(VMError::Bye)) {
+            return Err(err);
+        }
+        let frame = match self.catch_frames.pop() {
+            Some(frame) => frame,
+            None => return Err(uncaught(err)),
+        };
+        log::trace!("VM::unwind {}", err);
+        self.trace.clear();
+
+        self.stack.truncate(frame.stack_depth);
+        self.return_stack.truncate(frame.return_depth);
+        self.outputs.truncate(frame.output_depth);
+        // The coroutines it throws out of can't be resumed.
+        for running in self.coroutine_frames.split_off(frame.coroutine_depth) {
+            running.coroutine.replace(CoroutineState::Done);
+        }
+        let value = match err {
+            Error::VMError(VMError::Throw(value)) => value,
+            err => Value::new(ValueData::String(err.to_string())),
+        };
+        self.push(shared(value))?;
+
+        // Return from `catch`, and have the handler return there too.
+        let return_addr = self.pop_return()?;
+        let return_addr = return_addr
+            .borrow()
+            .as_address()
+            .ok_or_else(|| VMError::TypeMismatch("catch return address".to_string()))?;
+        self.ip = return_addr;
+
+        let handler = frame.handler.borrow().as_function().cloned().ok_or_else(|| {
+            VMError::TypeMismatch(format!("catch handler: {}", frame.handler.borrow()))
+        })?;
+        handler.call(self, compiler)
+    }
+
+    /// Walks the return stack from the failing instruction out to where this
+    /// run started, naming each word and where it was called from.
+    pub fn build_trace(&self, ip: usize, return_base: usize) -> Vec<TraceFrame> {
+        let env = match self.environment.as_ref() {
+            Some(env) => env.borrow(),
+            None => return Vec::new(),
+        };
+        // Return addresses point just past the call.
+        let return_ips = self.return_stack[return_base.min(self.return_stack.len())..]
+            .iter()
+            .rev()
+            .filter_map(|value| match value.borrow().data {
+                ValueData::Return(return_ip, _) => Some(return_ip.saturating_sub(1)),
+                _ => None,
+            });
+        iter::once(ip)
+            .chain(return_ips)
+            .map(|ip| TraceFrame {
+                word: env
+                    .get_containing_op_name(ip)
+                    .or_else(|| env.is_in_lambda(ip).then(|| "<lambda>".to_string()))
+                    .unwrap_or_else(|| "<top level>".to_string()),
+                loc: env.get_position(ip).cloned(),
+            })
+            .collect()
+    }
+
     /// Break from a loop by removing things from the return stack until getting to a breakpoint
     /// and then jumping to the next ip value.
     pub fn clear_jump(&mut self) -> Result<()> {
@@ -436,10 +946,30 @@ impl VM {
             }
             self.pop_return()?;
         }
+        self.drop_stale_frames()?;
 
         self.jump()
     }
 
+    /// Asks the hook to pause before the next instruction.
+    pub fn breakpoint(&mut self) -> Result<()> {
+        log::trace!("breakpoint");
+        self.break_requested = true;
+        Ok(())
+    }
+
+    /// Runs the hook for the instruction at `ip`.
+    fn run_hook(&mut self, ip: usize) -> Result<()> {
+        match self.hook.take() {
+            Some(mut hook) => {
+                let result = hook.before_instruction(self, ip);
+                self.hook = Some(hook);
+                result
+            }
+            None => Ok(()),
+        }
+    }
+
     /// Return from a macro
     pub fn stop(&self) -> Result<()> {
         // I tried calling `return_op` here, but it seemed to break
@@ -492,6 +1022,22 @@ impl VM {
         Ok(())
     }
 
+    /// Compiles the body of a `::` definition, with the locals from its
+    /// stack effect in scope.
+    pub fn compile_locals(&mut self, compiler: &mut Compiler) -> Result<()> {
+        log::trace!("VM::compile_locals");
+        let value = self.pop()?;
+        let value = unshare_clone(value);
+        let words = value
+            .as_list()
+            .ok_or_else(|| VMError::TypeMismatch(format!("locals body: {}", value)))?
+            .iter()
+            .map(|word| word.borrow().clone())
+            .collect::<Vec<_>>();
+        let lambda = compiler.compile_locals(self, &words)?;
+        self.push(shared(Value::new(ValueData::Function(lambda))))
+    }
+
     fn debug_op(&self) {
         let env_loc = EnvLoc::new(self.environment.clone().unwrap(), self.ip);
         let debugged = format!("{:?}", env_loc);
@@ -533,6 +1079,8 @@ impl Execute for VM {
             .unwrap()
             .borrow()
             .instructions_len();
+        // Macros are run with their return address already pushed.
+        let return_base = self.return_stack.len();
         while self.ip < max_ip {
             // Get the next instruction (OpCode)
             let op_code = self
@@ -556,21 +1104,34 @@ impl Execute for VM {
                 .ok_or(VMError::MissingEnvironment)
                 .and_then(|e| e.borrow().get_op(&self.ip, op_code))?;
 
+            let op_ip = self.ip;
+            let result = self.run_hook(op_ip);
             self.ip += 1;
 
             // Execute the operation
             let operation = operation.borrow();
-            let result = operation.call(self, compiler);
+            let result = result.and_then(|()| operation.call(self, compiler));
             match result {
                 Ok(()) => {}
                 Err(Error::VMError(VMError::Stop)) => {
                     log::trace!("stopping");
                     return Ok(());
                 }
-                err => {
-                    self.ip = max_ip;
-                    self.return_stack.clear();
-                    return err;
+                Err(err) => {
+                    if let Err(err) = self.unwind(err, compiler) {
+                        // A nested run may have already recorded a deeper trace.
+                        if self.trace.is_empty() && !matches!(err, Error::VMError(VMError::Bye)) {
+                            self.trace = self.build_trace(op_ip, return_base);
+                        }
+                        self.ip = max_ip;
+                        self.return_stack.clear();
+                        self.catch_frames.clear();
+                        for running in self.coroutine_frames.drain(..) {
+                            running.coroutine.replace(CoroutineState::Done);
+                        }
+                        self.outputs.clear();
+                        return Err(err);
+                    }
                 }
             }
         }
@@ -673,5 +1234,23 @@ impl Execute for VM {
     }
 }
 
+/// Tags a thrown value that nothing caught so it reads well at the top level.
+fn uncaught(err: Error) -> Error {
+    if let Error::VMError(VMError::Throw(value)) = err {
+        let message = match &value.pos {
+            Some(pos) => format!(
+                "{} (line {}, column {})",
+                value.to_repr(),
+                pos.line,
+                pos.column
+            ),
+            None => value.to_repr(),
+        };
+        VMError::UncaughtThrow(message).into()
+    } else {
+        err
+    }
+}
+
 #[cfg(test)]
 mod tests;
@@ -40,6 +40,10 @@ pub enum OpCode {
     Compile,
     Break,
     Continue,
+    TailCall,   // Call a word, reusing the current return frame
+    TailApply,  // Call a function object on the stack, reusing the current return frame
+    LoadLocal,  // Copy a local from the return stack frame to the data stack
+    StoreLocal, // Move the top of the data stack into a local in the return stack frame
 }
 
 impl From<OpCode> for usize {
@@ -83,6 +87,10 @@ impl TryFrom<usize> for OpCode {
             27 => Ok(OpCode::Compile),
             28 => Ok(OpCode::Break),
             29 => Ok(OpCode::Continue),
+            30 => Ok(OpCode::TailCall),
+            31 => Ok(OpCode::TailApply),
+            32 => Ok(OpCode::LoadLocal),
+            33 => Ok(OpCode::StoreLocal),
             _ => Err(Error::InvalidOpCode(value)),
         }
     }
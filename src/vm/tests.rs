@@ -202,9 +202,14 @@ fn test_return_stack_operations() {
         .map(|n| format!("{} >r", n))
         .collect::<Vec<_>>()
         .join(" ");
-    let result = eval(&script);
+    let mut tardi = Tardi::default();
+    tardi.set_max_depth(1024);
+    let result = tardi.execute_str(&script);
     assert!(
-        matches!(result, Err(Error::VMError(VMError::ReturnStackOverflow)),),
+        matches!(
+            result,
+            Err(Error::VMError(VMError::ReturnStackOverflow(1024)))
+        ),
         "actual result {:?}",
         result
     );
@@ -0,0 +1,43 @@
+use std::process::Command;
+
+use assert_cmd::prelude::*;
+use pretty_assertions::assert_eq;
+
+/// Runs `tardi --print-stack` with `args` and returns what it printed to
+/// stderr, which is the stack when it exits.
+fn print_stack(args: &[&str]) -> String {
+    let output = Command::cargo_bin(env!["CARGO_PKG_NAME"])
+        .unwrap()
+        .arg("--print-stack")
+        .args(args)
+        .output()
+        .unwrap();
+    assert!(
+        output.status.success(),
+        "tardi {:?} failed: {}",
+        args,
+        String::from_utf8_lossy(&output.stderr)
+    );
+    String::from_utf8_lossy(&output.stderr).replace("\r\n", "\n")
+}
+
+#[test]
+fn test_eval_args() {
+    assert_eq!(
+        print_stack(&["-e", "uses: std/os argv", "a", "b"]),
+        "{ \"a\" \"b\" }\n"
+    );
+}
+
+#[test]
+fn test_eval_without_args() {
+    assert_eq!(print_stack(&["-e", "uses: std/os argv"]), "{ }\n");
+}
+
+#[test]
+fn test_eval_args_after_double_dash() {
+    assert_eq!(
+        print_stack(&["-e", "uses: std/os argv", "--", "-x", "y"]),
+        "{ \"-x\" \"y\" }\n"
+    );
+}
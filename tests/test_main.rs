@@ -6,15 +6,21 @@ use assert_cmd::prelude::*;
 use pretty_assertions::assert_eq;
 
 fn test_tardi_file(tardi_file: &Path) -> datatest_stable::Result<()> {
-    let output = Command::cargo_bin(env!["CARGO_PKG_NAME"])
-        .unwrap()
+    let mut command = Command::cargo_bin(env!["CARGO_PKG_NAME"]).unwrap();
+    command
         .arg("--print-stack")
         // TODO: some way to make the next line optional
         // .arg("-vvvv")
         // .arg("-vvv")
-        .arg(tardi_file)
-        .output()
-        .unwrap();
+        .arg(tardi_file);
+
+    // If there's a `.stdin` file, it's piped into the script.
+    let stdin_file = tardi_file.with_extension("stdin");
+    if stdin_file.exists() {
+        command.stdin(fs::File::open(stdin_file).unwrap());
+    }
+
+    let output = command.output().unwrap();
 
     // Validate results
     validate_status(tardi_file, &output);